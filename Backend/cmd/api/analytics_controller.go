@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"project/internal/data"
+	"project/utils"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultAnalyticsDays = 30
+	maxAnalyticsDays     = 366
+	defaultTopBooks      = 10
+	maxTopBooks          = 100
+)
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// recordBookEvent counts a view or download. Analytics must never break the
+// request itself, so failures are only logged.
+func (app *application) recordBookEvent(r *http.Request, bookID uuid.UUID, kind string) {
+	userID, _ := r.Context().Value(UserIDKey).(string)
+	visitor := data.VisitorKey(userID, clientIP(r))
+	if err := app.Model.AnalyticsDB.RecordBookEvent(bookID, kind, visitor); err != nil {
+		app.logError(r, err)
+	}
+}
+
+// DownloadBookFileHandler serves the book's file to callers its access level
+// allows and counts the download.
+func (app *application) DownloadBookFileHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid book ID"))
+		return
+	}
+
+	book, err := app.Model.BookDB.GetBookFile(bookID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if book.File == nil || *book.File == "" {
+		app.handleRetrievalError(w, r, data.ErrRecordNotFound)
+		return
+	}
+	if !app.authorizeBookFiles(w, r, book) {
+		return
+	}
+
+	app.recordBookEvent(r, bookID, data.BookEventDownload)
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filepath.Base(*book.File)))
+	http.ServeFile(w, r, utils.LocalFilePath(*book.File))
+}
+
+// parseAnalyticsPeriod reads ?from=YYYY-MM-DD&to=YYYY-MM-DD, both inclusive,
+// defaulting to the last 30 days.
+func parseAnalyticsPeriod(queryParams url.Values) (time.Time, time.Time, error) {
+	today := time.Now().Truncate(24 * time.Hour)
+	to := today
+	if value := queryParams.Get("to"); value != "" {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid to date, expected YYYY-MM-DD")
+		}
+		to = parsed
+	}
+
+	from := to.AddDate(0, 0, -(defaultAnalyticsDays - 1))
+	if value := queryParams.Get("from"); value != "" {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid from date, expected YYYY-MM-DD")
+		}
+		from = parsed
+	}
+
+	if from.After(to) {
+		return time.Time{}, time.Time{}, errors.New("from must not be after to")
+	}
+	if to.Sub(from) > maxAnalyticsDays*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("the period cannot be longer than %d days", maxAnalyticsDays)
+	}
+	return from, to, nil
+}
+
+// requestUserID is the authenticated caller, or uuid.Nil without a valid token.
+func requestUserID(r *http.Request) uuid.UUID {
+	userID, _ := r.Context().Value(UserIDKey).(string)
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return uuid.Nil
+	}
+	return id
+}
+
+// TopBooksHandler ranks books by views or downloads over a period. Admins see
+// the whole archive, teachers only the books they advise.
+func (app *application) TopBooksHandler(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+
+	from, to, err := parseAnalyticsPeriod(queryParams)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	metric := queryParams.Get("metric")
+	if metric == "" {
+		metric = data.BookEventView
+	}
+	if metric != data.BookEventView && metric != data.BookEventDownload {
+		app.badRequestResponse(w, r, errors.New("metric must be view or download"))
+		return
+	}
+
+	limit, _ := strconv.Atoi(queryParams.Get("limit"))
+	if limit <= 0 {
+		limit = defaultTopBooks
+	}
+	if limit > maxTopBooks {
+		limit = maxTopBooks
+	}
+
+	var advisorID *uuid.UUID
+	if !app.can(r, data.PermAnalyticsViewAll) {
+		userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		advisorID = &userID
+	}
+
+	books, err := app.Model.AnalyticsDB.TopBooks(metric, from, to, limit, advisorID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"books":  books,
+		"metric": metric,
+		"from":   from.Format("2006-01-02"),
+		"to":     to.Format("2006-01-02"),
+	})
+}
+
+// BookAnalyticsHandler returns the daily views and downloads of a book, for
+// admins and the book's advisors.
+func (app *application) BookAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid book ID"))
+		return
+	}
+
+	if !app.can(r, data.PermAnalyticsViewAll) {
+		userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		advisor, err := app.Model.BookDB.IsBookAdvisor(bookID, userID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !advisor {
+			app.forbiddenResponse(w, r)
+			return
+		}
+	}
+
+	from, to, err := parseAnalyticsPeriod(r.URL.Query())
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	series, err := app.Model.AnalyticsDB.BookTimeSeries(bookID, from, to)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	views, downloads := 0, 0
+	for _, day := range series {
+		views += day.Views
+		downloads += day.Downloads
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"book_id":   bookID,
+		"from":      from.Format("2006-01-02"),
+		"to":        to.Format("2006-01-02"),
+		"views":     views,
+		"downloads": downloads,
+		"series":    series,
+	})
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const maxAttachmentSize = 200 << 20
+
+func (app *application) ListBookAttachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	app.listAttachments(w, r, data.AttachmentOwnerBook)
+}
+
+func (app *application) CreateBookAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	app.createAttachment(w, r, data.AttachmentOwnerBook)
+}
+
+func (app *application) ReorderBookAttachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	app.reorderAttachments(w, r, data.AttachmentOwnerBook)
+}
+
+// DownloadBookAttachmentHandler serves a book attachment under the same access
+// rules as the book's own file.
+func (app *application) DownloadBookAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid book ID"))
+		return
+	}
+
+	book, err := app.Model.BookDB.GetBookFile(bookID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if !app.authorizeBookFiles(w, r, book) {
+		return
+	}
+
+	app.downloadAttachment(w, r, data.AttachmentOwnerBook)
+}
+
+func (app *application) DeleteBookAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	app.deleteAttachment(w, r, data.AttachmentOwnerBook)
+}
+
+func (app *application) ListPreProjectAttachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	app.listAttachments(w, r, data.AttachmentOwnerPreProject)
+}
+
+func (app *application) CreatePreProjectAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	app.createAttachment(w, r, data.AttachmentOwnerPreProject)
+}
+
+func (app *application) ReorderPreProjectAttachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	app.reorderAttachments(w, r, data.AttachmentOwnerPreProject)
+}
+
+func (app *application) DownloadPreProjectAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	app.downloadAttachment(w, r, data.AttachmentOwnerPreProject)
+}
+
+func (app *application) DeletePreProjectAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	app.deleteAttachment(w, r, data.AttachmentOwnerPreProject)
+}
+
+// auditEntityType is the audited entity an attachment belongs to.
+func auditEntityType(owner data.AttachmentOwner) string {
+	if owner == data.AttachmentOwnerBook {
+		return data.AuditEntityBook
+	}
+	return data.AuditEntityPreProject
+}
+
+func (app *application) listAttachments(w http.ResponseWriter, r *http.Request, owner data.AttachmentOwner) {
+	ownerID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid ID"))
+		return
+	}
+
+	attachments, err := app.Model.AttachmentDB.ListAttachments(owner, ownerID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"attachments": attachments})
+}
+
+func (app *application) createAttachment(w http.ResponseWriter, r *http.Request, owner data.AttachmentOwner) {
+	ownerID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid ID"))
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize)
+	file, fileHeader, err := r.FormFile("file")
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("a file is required"))
+		return
+	}
+	defer file.Close()
+
+	attachment := &data.Attachment{
+		Type:         strings.ToLower(r.FormValue("type")),
+		Title:        strings.TrimSpace(r.FormValue("title")),
+		OriginalName: fileHeader.Filename,
+		ContentType:  fileHeader.Header.Get("Content-Type"),
+		Size:         fileHeader.Size,
+	}
+	if attachment.Title == "" {
+		attachment.Title = fileHeader.Filename
+	}
+	if attachment.ContentType == "" {
+		attachment.ContentType = "application/octet-stream"
+	}
+	if userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string)); err == nil {
+		attachment.UploadedBy = &userID
+	}
+
+	v := validator.New()
+	data.ValidateAttachment(v, attachment)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The checksum is computed while the upload is written to disk.
+	hash := sha256.New()
+	fileName, err := utils.SaveFile(io.TeeReader(file, hash), "attachments", fileHeader.Filename)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "invalid file")
+		return
+	}
+	attachment.File = fileName
+	attachment.Checksum = hex.EncodeToString(hash.Sum(nil))
+
+	if err := app.Model.AttachmentDB.InsertAttachment(owner, ownerID, attachment); err != nil {
+		utils.DeleteFile(fileName)
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	attachment.File = data.AttachmentURL(owner, ownerID, attachment.ID)
+	app.audit(r, data.AuditAddAttachment, auditEntityType(owner), ownerID, nil, attachment)
+
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"attachment": attachment})
+}
+
+func (app *application) downloadAttachment(w http.ResponseWriter, r *http.Request, owner data.AttachmentOwner) {
+	ownerID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid ID"))
+		return
+	}
+	attachmentID, err := uuid.Parse(r.PathValue("attachment_id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid attachment ID"))
+		return
+	}
+
+	attachment, err := app.Model.AttachmentDB.GetAttachment(owner, ownerID, attachmentID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.OriginalName}))
+	http.ServeFile(w, r, utils.LocalFilePath(attachment.File))
+}
+
+// reorderAttachments takes the attachment IDs, comma separated, in their new
+// order.
+func (app *application) reorderAttachments(w http.ResponseWriter, r *http.Request, owner data.AttachmentOwner) {
+	ownerID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid ID"))
+		return
+	}
+
+	before, err := app.Model.AttachmentDB.ListAttachments(owner, ownerID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var ids []uuid.UUID
+	for _, raw := range strings.Split(r.FormValue("ids"), ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid attachment ID"))
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	err = app.Model.AttachmentDB.ReorderAttachments(owner, ownerID, ids)
+	if err != nil {
+		if errors.Is(err, data.ErrAttachmentOrder) {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	attachments, err := app.Model.AttachmentDB.ListAttachments(owner, ownerID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.audit(r, data.AuditReorderAttachments, auditEntityType(owner), ownerID, before, attachments)
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"attachments": attachments})
+}
+
+func (app *application) deleteAttachment(w http.ResponseWriter, r *http.Request, owner data.AttachmentOwner) {
+	ownerID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid ID"))
+		return
+	}
+	attachmentID, err := uuid.Parse(r.PathValue("attachment_id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid attachment ID"))
+		return
+	}
+
+	attachment, err := app.Model.AttachmentDB.GetAttachment(owner, ownerID, attachmentID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	file, err := app.Model.AttachmentDB.DeleteAttachment(owner, ownerID, attachmentID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if err := utils.DeleteFile(file); err != nil {
+		log.Printf("Failed to delete attachment file %s: %v", file, err)
+	}
+	attachment.File = data.AttachmentURL(owner, ownerID, attachmentID)
+	app.audit(r, data.AuditDeleteAttachment, auditEntityType(owner), ownerID, attachment, nil)
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "attachment deleted successfully"})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"project/internal/data"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// parseBookAccess reads the access_level and embargo_until form values into
+// book. Missing values keep whatever the book already has. embargo_until is
+// cleared for any level other than embargoed.
+func parseBookAccess(r *http.Request, book *data.Book) error {
+	if level := strings.ToLower(strings.TrimSpace(r.FormValue("access_level"))); level != "" {
+		book.AccessLevel = level
+	}
+
+	if value := strings.TrimSpace(r.FormValue("embargo_until")); value != "" {
+		until, err := parseTimeParam(value)
+		if err != nil {
+			return fmt.Errorf("invalid embargo_until, expected YYYY-MM-DD or RFC 3339")
+		}
+		book.EmbargoUntil = &until
+	}
+
+	if book.AccessLevel != data.BookAccessEmbargoed {
+		book.EmbargoUntil = nil
+	}
+	return nil
+}
+
+// authorizeBookFiles checks the caller against the book's access level and
+// writes the error response when they may not download its files. Staff and
+// the book's own participants get through every level, embargoes included.
+func (app *application) authorizeBookFiles(w http.ResponseWriter, r *http.Request, book *data.Book) bool {
+	level := book.EffectiveAccessLevel(time.Now())
+	if level == data.BookAccessPublic {
+		return true
+	}
+
+	userIDStr, _ := r.Context().Value(UserIDKey).(string)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		app.unauthorizedResponse(w, r)
+		return false
+	}
+	if level == data.BookAccessAuthenticated || app.can(r, data.PermBooksViewRestricted) {
+		return true
+	}
+
+	participant, err := app.Model.BookDB.IsBookParticipant(book.ID, userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if participant {
+		return true
+	}
+
+	if level == data.BookAccessEmbargoed {
+		app.errorResponse(w, r, http.StatusForbidden,
+			fmt.Sprintf("the files of this book are under embargo until %s", book.EmbargoUntil.Format("2006-01-02")))
+		return false
+	}
+	app.forbiddenResponse(w, r)
+	return false
+}
+
+// protectUploads serves the uploads folder except for book files and
+// attachments, which go through their download endpoints so the access level
+// of the book is enforced.
+func protectUploads(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cleaned := path.Clean("/" + r.URL.Path)
+		if strings.HasPrefix(cleaned, "/books/") || strings.HasPrefix(cleaned, "/attachments/") {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"project/internal/data"
 	"project/utils"
 	"project/utils/validator"
@@ -15,6 +16,14 @@ import (
 	"github.com/google/uuid"
 )
 
+// parseBookProgram reads the program form value into book. A missing value
+// keeps the book's program.
+func parseBookProgram(r *http.Request, book *data.Book) {
+	if program := strings.ToLower(strings.TrimSpace(r.FormValue("program"))); program != "" {
+		book.Program = &program
+	}
+}
+
 func (app *application) CreateBookHandler(w http.ResponseWriter, r *http.Request) {
 	name := r.FormValue("name")
 	description := r.FormValue("description")
@@ -114,6 +123,13 @@ func (app *application) CreateBookHandler(w http.ResponseWriter, r *http.Request
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
+	if err := parseBookAccess(r, book); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	parseBookProgram(r, book)
+	parseProjectMetadata(r, &book.ProjectMetadata)
+	book.CompleteMetadata()
 
 	// Handle file upload
 	if file, fileHeader, err := r.FormFile("file"); err == nil {
@@ -136,7 +152,7 @@ func (app *application) CreateBookHandler(w http.ResponseWriter, r *http.Request
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	similarityCheckResp, err := utils.CheckProjectSimilarity(name, description)
+	similarityCheckResp, err := utils.CheckProjectSimilarity(book.Name, stringOrEmpty(book.Description))
 	if err != nil {
 		if err.Error() == "server is offline or unreachable" {
 
@@ -198,6 +214,7 @@ func (app *application) CreateBookHandler(w http.ResponseWriter, r *http.Request
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.audit(r, data.AuditCreate, data.AuditEntityBook, book.ID, nil, createdBookWithDetails)
 
 	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"book": createdBookWithDetails})
 }
@@ -234,6 +251,10 @@ func (app *application) GetBookWithDetailsHandler(w http.ResponseWriter, r *http
 		app.handleRetrievalError(w, r, err)
 		return
 	}
+	app.recordBookEvent(r, id, data.BookEventView)
+	if lang := negotiateLanguage(w, r); lang != "" {
+		bookWithDetails.Localize(lang)
+	}
 	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"book": bookWithDetails})
 }
 
@@ -253,12 +274,29 @@ func (app *application) UpdateBookHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// The listing only exposes the download URL, so the stored path comes
+	// from here.
+	storedBook, err := app.Model.BookDB.GetBookFile(bookID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
 	// Prepare book for update
 	book := &data.Book{
-		ID:        bookID,
-		CreatedAt: existingBookWithDetails.Book.CreatedAt,
-		UpdatedAt: time.Now(),
+		ID:              bookID,
+		CreatedAt:       existingBookWithDetails.Book.CreatedAt,
+		UpdatedAt:       time.Now(),
+		AccessLevel:     existingBookWithDetails.Book.AccessLevel,
+		EmbargoUntil:    existingBookWithDetails.Book.EmbargoUntil,
+		Program:         existingBookWithDetails.Book.Program,
+		ProjectMetadata: existingBookWithDetails.Book.ProjectMetadata,
+	}
+	if err := parseBookAccess(r, book); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
 	}
+	parseBookProgram(r, book)
 
 	// Parse and update name if provided
 	name := r.FormValue("name")
@@ -308,6 +346,8 @@ func (app *application) UpdateBookHandler(w http.ResponseWriter, r *http.Request
 	} else {
 		book.Season = existingBookWithDetails.Book.Season
 	}
+	parseProjectMetadata(r, &book.ProjectMetadata)
+	book.CompleteMetadata()
 
 	// Handle file upload
 	var file *string
@@ -320,17 +360,13 @@ func (app *application) UpdateBookHandler(w http.ResponseWriter, r *http.Request
 			return
 		}
 		file = &fileName
-		oldFile = existingBookWithDetails.Book.File
+		oldFile = storedBook.File
 		book.File = file
 	} else if err != http.ErrMissingFile {
 		app.errorResponse(w, r, http.StatusBadRequest, "invalid file upload")
 		return
 	} else {
-		if existingBookWithDetails.Book.File != nil {
-			*existingBookWithDetails.Book.File = strings.TrimPrefix(*existingBookWithDetails.Book.File, data.Domain+"/")
-		}
-
-		existingBookWithDetails.File = existingBookWithDetails.Book.File
+		book.File = storedBook.File
 	}
 
 	// Determine if user lists are being updated
@@ -489,9 +525,7 @@ func (app *application) UpdateBookHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// Delete old file if a new file was uploaded
-	if oldFile != nil {
-		*oldFile = strings.TrimPrefix(*oldFile, data.Domain+"/")
-
+	if oldFile != nil && *oldFile != "" {
 		if err := utils.DeleteFile(*oldFile); err != nil {
 
 			log.Printf("Failed to delete old file %s: %v", *oldFile, err)
@@ -504,6 +538,7 @@ func (app *application) UpdateBookHandler(w http.ResponseWriter, r *http.Request
 		app.handleRetrievalError(w, r, err)
 		return
 	}
+	app.audit(r, data.AuditUpdate, data.AuditEntityBook, bookID, existingBookWithDetails, updatedBookWithDetails)
 
 	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
 		"book": updatedBookWithDetails,
@@ -519,7 +554,7 @@ func (app *application) DeleteBookHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// Check if book exists
-	_, err = app.Model.BookDB.GetBook(id)
+	book, err := app.Model.BookDB.GetBook(id)
 	if err != nil {
 		if errors.Is(err, data.ErrRecordNotFound) {
 			app.errorResponse(w, r, 404, "Book was not found")
@@ -529,17 +564,18 @@ func (app *application) DeleteBookHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.Model.BookDB.DeleteBook(id)
+	err = app.Model.BookDB.DeleteBook(id, requestUserID(r))
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.handleRetrievalError(w, r, err)
 		return
 	}
+	app.audit(r, data.AuditDelete, data.AuditEntityBook, id, book, nil)
 
-	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "book deleted successfully"})
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "book moved to trash"})
 }
 
-func (app *application) ListBooksHandler(w http.ResponseWriter, r *http.Request) {
-	queryParams := r.URL.Query()
+// addIDFilter folds the ?id= shortcut into the "filters" query parameter.
+func addIDFilter(queryParams url.Values) {
 	id := queryParams.Get("id")
 	if id != "" {
 		// Dynamically add the ID to the filters
@@ -550,11 +586,44 @@ func (app *application) ListBooksHandler(w http.ResponseWriter, r *http.Request)
 			queryParams.Set("filters", fmt.Sprintf("%s,id:%s", filters, id))
 		}
 	}
-	books, meta, err := app.Model.BookDB.ListBooks(queryParams)
+}
+
+func (app *application) ListBooksHandler(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	addIDFilter(queryParams)
+	var books []data.Book
+	var meta interface{}
+	var err error
+	if utils.UseCursor(queryParams) {
+		books, meta, err = app.Model.BookDB.ListBooksCursor(queryParams)
+	} else {
+		books, meta, err = app.Model.BookDB.ListBooks(queryParams)
+	}
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.handleRetrievalError(w, r, err)
 		return
 	}
+	if lang := negotiateLanguage(w, r); lang != "" {
+		for i := range books {
+			books[i].Localize(lang)
+		}
+	}
+
+	// include=participants attaches students, advisors and discussants to the
+	// whole page with a single extra query.
+	if queryParams.Get("include") == "participants" {
+		booksWithDetails, err := app.Model.BookDB.LoadBookParticipants(books)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+			"meta":  meta,
+			"books": booksWithDetails,
+		})
+		return
+	}
+
 	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
 		"meta":  meta,
 		"books": books,
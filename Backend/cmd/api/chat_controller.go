@@ -125,6 +125,19 @@ func (app *application) GetChatsHandler(w http.ResponseWriter, r *http.Request)
 	// Extract query parameters from the request
 	queryParams := r.URL.Query()
 
+	if utils.UseCursor(queryParams) {
+		chats, meta, err := app.Model.ChatDB.GetChatsByConversationIDCursor(conversationID, queryParams)
+		if err != nil {
+			app.handleRetrievalError(w, r, err)
+			return
+		}
+		utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+			"chats": chats,
+			"meta":  meta,
+		})
+		return
+	}
+
 	// Call the GetChatsByConversationID function with the conversation ID and query parameters
 	chats, meta, err := app.Model.ChatDB.GetChatsByConversationID(conversationID, queryParams)
 	if err != nil {
@@ -169,6 +182,7 @@ func (app *application) DeleteChatHandler(w http.ResponseWriter, r *http.Request
 		}
 		return
 	}
+	app.audit(r, data.AuditDelete, data.AuditEntityChat, chatID, chat, nil)
 
 	// Prepare the notification for broadcasting
 	notification := map[string]interface{}{
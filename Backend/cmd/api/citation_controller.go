@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"project/internal/data"
+	"project/utils/citation"
+	"project/utils/markdown"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const maxCitationBooks = 100
+
+// bookURL is the stable address of a book, independent of the frontend routes.
+func bookURL(id uuid.UUID) string {
+	return fmt.Sprintf("%s/book/%s", data.Domain, id)
+}
+
+func citationWork(book data.BookWithDetails) citation.Work {
+	work := citation.Work{
+		Key:    fmt.Sprintf("pa%d-%s", book.Year, book.ID.String()[:8]),
+		Title:  book.Name,
+		Year:   book.Year,
+		Season: book.Season,
+		URL:    citableBookURL(book.Book),
+	}
+	if book.Description != nil {
+		work.Abstract = markdown.Text(*book.Description)
+	}
+	for _, student := range book.Students {
+		work.Authors = append(work.Authors, student.Name)
+	}
+	for _, advisor := range book.Advisors {
+		work.Contributors = append(work.Contributors, advisor.Name)
+	}
+	return work
+}
+
+// GetBookCitationHandler returns the citation of a single book.
+func (app *application) GetBookCitationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid book ID"))
+		return
+	}
+
+	format, err := citation.LookupFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	book, err := app.Model.BookDB.GetBook(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	app.writeCitations(w, r, format, "book-"+id.String(), []data.BookWithDetails{*book})
+}
+
+// ListCitationsHandler returns the citations of a selection of books given as
+// ?ids=<id>,<id>,...
+func (app *application) ListCitationsHandler(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+
+	format, err := citation.LookupFormat(queryParams.Get("format"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var ids []uuid.UUID
+	for _, raw := range strings.Split(queryParams.Get("ids"), ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			app.badRequestResponse(w, r, fmt.Errorf("invalid book ID: %s", raw))
+			return
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		app.badRequestResponse(w, r, errors.New("at least one book ID is required"))
+		return
+	}
+	if len(ids) > maxCitationBooks {
+		app.badRequestResponse(w, r, fmt.Errorf("at most %d books can be cited at once", maxCitationBooks))
+		return
+	}
+
+	books, err := app.Model.BookDB.GetBooks(ids)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if len(books) == 0 {
+		app.handleRetrievalError(w, r, data.ErrRecordNotFound)
+		return
+	}
+
+	app.writeCitations(w, r, format, "citations", books)
+}
+
+func (app *application) writeCitations(w http.ResponseWriter, r *http.Request, format citation.Format,
+	name string, books []data.BookWithDetails) {
+
+	works := make([]citation.Work, len(books))
+	for i, book := range books {
+		works[i] = citationWork(book)
+	}
+
+	// Rendered up front so a failure can still be reported as an error response.
+	var buf bytes.Buffer
+	if err := citation.Write(&buf, format, works); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.%s"`, name, format.Extension))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"project/internal/data"
 	"project/utils"
 	"time"
 
@@ -77,11 +78,12 @@ func (app *application) DeleteConversationHandler(w http.ResponseWriter, r *http
 	}
 
 	// Call the DeleteConversation method
-	err = app.Model.ConversationDB.DeleteConversation(conversationID)
+	err = app.Model.ConversationDB.DeleteConversation(conversationID, requestUserID(r))
 	if err != nil {
 		app.handleRetrievalError(w, r, err)
 		return
 	}
+	app.audit(r, data.AuditDelete, data.AuditEntityConversation, conversationID, conversation, nil)
 
 	// Prepare the notification for broadcasting
 	notification := map[string]interface{}{
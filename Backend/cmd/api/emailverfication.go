@@ -41,3 +41,31 @@ func SendVerificationEmail(to, verificationCode string) error {
 	log.Printf("Verification email sent to: %s", to)
 	return nil
 }
+
+// SendAcknowledgementReminder reminds a user to read and acknowledge a post.
+func SendAcknowledgementReminder(to, name, excerpt string) error {
+	m := gomail.NewMessage()
+
+	m.SetHeader("From", os.Getenv("GMAIL_USER"))
+	m.SetHeader("To", to)
+	m.SetHeader("Subject", "تذكير بقراءة إعلان مهم")
+
+	body := fmt.Sprintf(
+		"مرحبًا %s،\n\n"+
+			"نذكرك بقراءة الإعلان التالي وتأكيد الاطلاع عليه في موقع القسم:\n\n"+
+			"%s\n\n"+
+			"مع تحياتنا،\n"+
+			"المبرمج.",
+		name, excerpt,
+	)
+
+	m.SetBody("text/plain", body)
+
+	d := gomail.NewDialer("smtp.gmail.com", 587, os.Getenv("GMAIL_USER"), os.Getenv("GMAIL_PASSWORD"))
+	if err := d.DialAndSend(m); err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+
+	log.Printf("Acknowledgement reminder sent to: %s", to)
+	return nil
+}
@@ -19,6 +19,10 @@ func (app *application) handleRetrievalError(w http.ResponseWriter, r *http.Requ
 		app.errorResponse(w, r, http.StatusConflict, data.ErrEmailAlreadyInserted.Error())
 	case errors.Is(err, data.ErrHasRole):
 		app.errorResponse(w, r, http.StatusConflict, data.ErrHasRole.Error())
+	case errors.Is(err, utils.ErrInvalidCursor):
+		app.errorResponse(w, r, http.StatusBadRequest, utils.ErrInvalidCursor.Error())
+	case errors.Is(err, utils.ErrInvalidSort):
+		app.errorResponse(w, r, http.StatusBadRequest, utils.ErrInvalidSort.Error())
 
 	default:
 		app.serverErrorResponse(w, r, err)
@@ -97,6 +101,8 @@ func (app *application) jwtErrorResponse(w http.ResponseWriter, r *http.Request,
 		message = "missing authorization token"
 	case errors.Is(err, utils.ErrInvalidClaims):
 		message = "invalid token claims"
+	case errors.Is(err, utils.ErrRevokedToken):
+		message = "session has been revoked"
 	default:
 		app.errorResponse(w, r, http.StatusUnauthorized, "You don't have a premission")
 		return
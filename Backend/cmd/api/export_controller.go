@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"project/internal/data"
+	"project/utils/export"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var bookExportHeaders = []string{
+	"id", "identifier", "name", "description",
+	"title_ar", "title_en", "abstract_ar", "abstract_en", "keywords", "language", "year", "season", "degree", "program", "file",
+	"students", "advisors", "discussants", "created_at", "updated_at",
+}
+
+var preProjectExportHeaders = []string{
+	"id", "name", "description",
+	"title_ar", "title_en", "abstract_ar", "abstract_en", "keywords", "language", "file_description", "year", "season", "degree", "file",
+	"students", "advisors", "discussants", "created_at", "updated_at",
+}
+
+type bookExportRow struct {
+	data.BookWithDetails
+}
+
+func (b bookExportRow) Values() []string {
+	values := []string{
+		b.ID.String(),
+		b.Identifier,
+		b.Name,
+		stringOrEmpty(b.Description),
+	}
+	values = append(values, metadataValues(b.ProjectMetadata)...)
+	return append(values,
+		strconv.Itoa(b.Year),
+		b.Season,
+		intOrEmpty(b.Degree),
+		stringOrEmpty(b.Program),
+		stringOrEmpty(b.File),
+		joinUserDetails(b.Students),
+		joinUserDetails(b.Advisors),
+		joinUserDetails(b.Discussants),
+		b.CreatedAt.Format(time.RFC3339),
+		b.UpdatedAt.Format(time.RFC3339),
+	)
+}
+
+type preProjectExportRow struct {
+	data.PreProjectWithAdvisorDetails
+}
+
+func (p preProjectExportRow) Values() []string {
+	students := make([]string, len(p.Students))
+	for i, student := range p.Students {
+		students[i] = fmt.Sprintf("%s <%s>", student.StudentName, student.StudentEmail)
+	}
+	advisors := make([]string, len(p.Advisors))
+	for i, advisor := range p.Advisors {
+		advisors[i] = fmt.Sprintf("%s <%s> (%s)", advisor.AdvisorName, advisor.AdvisorEmail, advisor.Status)
+	}
+	discussants := make([]string, len(p.Discussants))
+	for i, discussant := range p.Discussants {
+		discussants[i] = fmt.Sprintf("%s <%s>", discussant.DiscussantName, discussant.DiscussantEmail)
+	}
+
+	pp := p.PreProject
+	values := []string{
+		pp.ID.String(),
+		pp.Name,
+		stringOrEmpty(pp.Description),
+	}
+	values = append(values, metadataValues(pp.ProjectMetadata)...)
+	return append(values,
+		stringOrEmpty(pp.FileDescription),
+		strconv.Itoa(pp.Year),
+		pp.Season,
+		intOrEmpty(pp.Degree),
+		stringOrEmpty(pp.File),
+		strings.Join(students, "; "),
+		strings.Join(advisors, "; "),
+		strings.Join(discussants, "; "),
+		pp.CreatedAt.Format(time.RFC3339),
+		pp.UpdatedAt.Format(time.RFC3339),
+	)
+}
+
+// metadataValues fills the title_ar to language columns.
+func metadataValues(m data.ProjectMetadata) []string {
+	return []string{
+		stringOrEmpty(m.TitleAr),
+		stringOrEmpty(m.TitleEn),
+		stringOrEmpty(m.AbstractAr),
+		stringOrEmpty(m.AbstractEn),
+		strings.Join(m.Keywords, "; "),
+		m.Language,
+	}
+}
+
+func joinUserDetails(users []data.UserDetails) string {
+	parts := make([]string, len(users))
+	for i, user := range users {
+		parts[i] = fmt.Sprintf("%s <%s>", user.Name, user.Email)
+	}
+	return strings.Join(parts, "; ")
+}
+
+func stringOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func intOrEmpty(i *int) string {
+	if i == nil {
+		return ""
+	}
+	return strconv.Itoa(*i)
+}
+
+// ExportBooksHandler streams the archive as CSV, XLSX or JSON Lines. It accepts
+// the same q, filters, sort and id parameters as GET book.
+func (app *application) ExportBooksHandler(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	addIDFilter(queryParams)
+
+	format, err := export.LookupFormat(queryParams.Get("format"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	app.streamExport(w, r, format, "books", bookExportHeaders, func(writer export.Writer) error {
+		return app.Model.BookDB.StreamBooks(queryParams, func(book data.BookWithDetails) error {
+			return writer.WriteRow(bookExportRow{book})
+		})
+	})
+}
+
+func (app *application) ExportPreProjectsHandler(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	addIDFilter(queryParams)
+
+	format, err := export.LookupFormat(queryParams.Get("format"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	app.streamExport(w, r, format, "pre_projects", preProjectExportHeaders, func(writer export.Writer) error {
+		return app.Model.PreProjectDB.StreamPreProjects(queryParams, func(preProject data.PreProjectWithAdvisorDetails) error {
+			return writer.WriteRow(preProjectExportRow{preProject})
+		})
+	})
+}
+
+// streamExport sets the download headers and lets produce write rows straight
+// to the response. Once the first bytes are out the status can no longer change,
+// so failures mid-stream are only logged.
+func (app *application) streamExport(w http.ResponseWriter, r *http.Request, format export.Format,
+	name string, headers []string, produce func(export.Writer) error) {
+
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="%s-%s.%s"`, name, time.Now().Format("2006-01-02"), format.Extension))
+
+	writer, err := export.NewWriter(format, w, headers, name)
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	if err := produce(writer); err != nil {
+		app.logError(r, fmt.Errorf("export %s aborted: %w", name, err))
+		return
+	}
+
+	if err := writer.Close(); err != nil {
+		app.logError(r, err)
+	}
+}
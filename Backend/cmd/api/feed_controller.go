@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/feed"
+	"project/utils/markdown"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultFeedEntries = 50
+	maxFeedEntries     = 200
+	postTitleLength    = 80
+)
+
+func feedLimit(r *http.Request) int {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		return defaultFeedEntries
+	}
+	if limit > maxFeedEntries {
+		return maxFeedEntries
+	}
+	return limit
+}
+
+// feedSelf is the feed's own URL, query included, so every filtered feed has
+// its own identity.
+func feedSelf(r *http.Request) string {
+	self := data.Domain + r.URL.Path
+	if r.URL.RawQuery != "" {
+		self += "?" + r.URL.RawQuery
+	}
+	return self
+}
+
+// fileEnclosure describes a stored upload, or returns nil when the file is
+// missing from disk.
+func fileEnclosure(storedPath, url string) *feed.Enclosure {
+	info, err := os.Stat(utils.LocalFilePath(storedPath))
+	if err != nil {
+		return nil
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(storedPath))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return &feed.Enclosure{URL: url, Type: contentType, Length: info.Size()}
+}
+
+// postTitle uses the first line of the post, shortened, since posts have no
+// title of their own.
+func postTitle(description string) string {
+	title := strings.TrimSpace(strings.SplitN(strings.TrimSpace(description), "\n", 2)[0])
+	if runes := []rune(title); len(runes) > postTitleLength {
+		title = string(runes[:postTitleLength]) + "…"
+	}
+	if title == "" {
+		title = "منشور"
+	}
+	return title
+}
+
+// writeFeed renders the feed unless the client's copy is still current. The
+// ETag covers which entries are in the feed and when each last changed, so
+// removed entries are noticed too; Last-Modified is the newest entry.
+func (app *application) writeFeed(w http.ResponseWriter, r *http.Request, format feed.Format, f feed.Feed) {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n", format.Name, f.Self)
+	for _, entry := range f.Entries {
+		fmt.Fprintf(hash, "%s %d\n", entry.ID, entry.Updated.UnixNano())
+		if entry.Updated.After(f.Updated) {
+			f.Updated = entry.Updated
+		}
+	}
+	etag := `W/"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+	if f.Updated.IsZero() {
+		f.Updated = time.Now()
+	}
+	lastModified := f.Updated.UTC().Truncate(time.Second)
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == etag || candidate == "*" {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := feed.Write(&buf, format, f); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", format.ContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// BooksFeedHandler publishes the newest books as Atom or RSS, optionally
+// filtered by ?year=, ?degree= and ?advisor= (a user ID). Files are enclosed
+// only while their book is public.
+func (app *application) BooksFeedHandler(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+
+	format, err := feed.LookupFormat(queryParams.Get("format"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	filter := data.BookFeedFilter{Limit: feedLimit(r)}
+	if value := queryParams.Get("year"); value != "" {
+		year, err := strconv.Atoi(value)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid year"))
+			return
+		}
+		filter.Year = &year
+	}
+	if value := queryParams.Get("degree"); value != "" {
+		degree, err := strconv.Atoi(value)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid degree"))
+			return
+		}
+		filter.Degree = &degree
+	}
+	if value := queryParams.Get("advisor"); value != "" {
+		advisorID, err := uuid.Parse(value)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid advisor ID"))
+			return
+		}
+		filter.AdvisorID = &advisorID
+	}
+
+	books, err := app.Model.BookDB.FeedBooks(filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	f := feed.Feed{
+		ID:          feedSelf(r),
+		Title:       "Project Archive: new books",
+		Description: "Newest graduation projects in the archive",
+		Link:        data.Domain + "/book",
+		Self:        feedSelf(r),
+	}
+	for _, book := range books {
+		entry := feed.Entry{
+			ID:        "urn:uuid:" + book.ID.String(),
+			Title:     book.Name,
+			Link:      bookURL(book.ID),
+			Published: book.CreatedAt,
+			Updated:   book.UpdatedAt,
+		}
+		if book.Description != nil {
+			entry.Summary = markdown.Text(*book.Description)
+		}
+		for _, student := range book.Students {
+			entry.Authors = append(entry.Authors, student.Name)
+		}
+		if book.File != nil && book.EffectiveAccessLevel(now) == data.BookAccessPublic {
+			entry.Enclosure = fileEnclosure(*book.File, bookURL(book.ID)+"/file")
+		}
+		f.Entries = append(f.Entries, entry)
+	}
+
+	app.writeFeed(w, r, format, f)
+}
+
+// PostsFeedHandler publishes the newest posts as Atom or RSS.
+func (app *application) PostsFeedHandler(w http.ResponseWriter, r *http.Request) {
+	format, err := feed.LookupFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	posts, err := app.Model.PostDB.FeedPosts(feedLimit(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	f := feed.Feed{
+		ID:          feedSelf(r),
+		Title:       "Project Archive: announcements",
+		Description: "Announcements from the project archive",
+		Link:        data.Domain + "/post",
+		Self:        feedSelf(r),
+	}
+	for _, post := range posts {
+		entry := feed.Entry{
+			ID:        "urn:uuid:" + post.ID.String(),
+			Title:     postTitle(markdown.Text(post.Description)),
+			Summary:   markdown.Text(post.Description),
+			Link:      fmt.Sprintf("%s/post/%s", data.Domain, post.ID),
+			Published: post.PublishAt,
+			Updated:   post.UpdatedAt,
+		}
+		if post.AuthorName != nil {
+			entry.Authors = []string{*post.AuthorName}
+		}
+		if post.File != nil {
+			entry.Enclosure = fileEnclosure(*post.File, data.Domain+*post.File)
+		}
+		f.Entries = append(f.Entries, entry)
+	}
+
+	app.writeFeed(w, r, format, f)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/export"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxImportFileSize = 32 << 20
+	maxImportRows     = 5000
+)
+
+// importColumns maps accepted header names to the book field they fill. The
+// export headers are accepted as is, so an export can be edited and re-imported.
+var importColumns = map[string]string{
+	"name":        "name",
+	"title":       "name",
+	"description": "description",
+	"abstract":    "description",
+	"year":        "year",
+	"season":      "season",
+	"degree":      "degree",
+	"program":     "program",
+	"students":    "students",
+	"advisors":    "advisors",
+	"discussants": "discussants",
+	"discutants":  "discussants",
+}
+
+var importSeasons = map[string]string{
+	"spring": "spring",
+	"fall":   "fall",
+	"ربيع":   "spring",
+	"خريف":   "fall",
+}
+
+// ImportBooksHandler imports historical books from a CSV or XLSX upload. With
+// dry_run=true nothing is written and the per-row report shows what would be
+// imported.
+func (app *application) ImportBooksHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportFileSize)
+
+	file, fileHeader, err := r.FormFile("file")
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("a CSV or XLSX file is required"))
+		return
+	}
+	defer file.Close()
+
+	dryRun, err := utils.ParseBoolOrDefault(r.FormValue("dry_run"), false)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid dry_run value"))
+		return
+	}
+
+	formatName := r.FormValue("format")
+	if formatName == "" {
+		formatName = strings.TrimPrefix(strings.ToLower(filepath.Ext(fileHeader.Filename)), ".")
+	}
+	format, err := export.LookupFormat(formatName)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	records, err := export.ReadAll(format, file)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if len(records) < 2 {
+		app.badRequestResponse(w, r, errors.New("the file has no rows to import"))
+		return
+	}
+	if len(records)-1 > maxImportRows {
+		app.badRequestResponse(w, r, fmt.Errorf("a single import is limited to %d rows", maxImportRows))
+		return
+	}
+
+	columns := make(map[string]int)
+	for i, header := range records[0] {
+		if field, ok := importColumns[strings.ToLower(strings.TrimSpace(header))]; ok {
+			if _, seen := columns[field]; !seen {
+				columns[field] = i
+			}
+		}
+	}
+	var missing []string
+	for _, field := range []string{"name", "year", "season"} {
+		if _, ok := columns[field]; !ok {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		app.badRequestResponse(w, r, fmt.Errorf("missing required columns: %s", strings.Join(missing, ", ")))
+		return
+	}
+
+	var rows []data.BookImportRow
+	for i, record := range records[1:] {
+		if isBlankRecord(record) {
+			continue
+		}
+		// Line numbers match the spreadsheet, where the header is line 1.
+		rows = append(rows, parseImportRow(i+2, record, columns))
+	}
+
+	results, err := app.Model.BookDB.ImportBooks(rows, dryRun)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	imported := 0
+	for _, result := range results {
+		if len(result.Errors) == 0 {
+			imported++
+		}
+	}
+	if !dryRun {
+		app.audit(r, data.AuditImport, data.AuditEntityBook, uuid.Nil, nil, utils.Envelope{"imported": imported, "rows": results})
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"dry_run":  dryRun,
+		"total":    len(results),
+		"imported": imported,
+		"failed":   len(results) - imported,
+		"rows":     results,
+	})
+}
+
+func parseImportRow(line int, record []string, columns map[string]int) data.BookImportRow {
+	cell := func(field string) string {
+		i, ok := columns[field]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	row := data.BookImportRow{Line: line, Errors: make(map[string]string)}
+
+	description := cell("description")
+	row.Book.Name = cell("name")
+	row.Book.Description = &description
+
+	if year, err := strconv.Atoi(cell("year")); err == nil {
+		row.Book.Year = year
+	} else {
+		row.Errors["year"] = "السنة يجب أن تكون رقماً"
+	}
+
+	season := strings.ToLower(cell("season"))
+	if mapped, ok := importSeasons[season]; ok {
+		season = mapped
+	}
+	row.Book.Season = season
+
+	if degreeStr := cell("degree"); degreeStr != "" {
+		if degree, err := strconv.Atoi(degreeStr); err == nil {
+			row.Book.Degree = &degree
+		} else {
+			row.Errors["degree"] = "الدرجة يجب أن تكون رقماً"
+		}
+	}
+
+	if program := strings.ToLower(cell("program")); program != "" {
+		row.Book.Program = &program
+	}
+
+	row.Students = parseImportParticipants(cell("students"))
+	row.Advisors = parseImportParticipants(cell("advisors"))
+	row.Discussants = parseImportParticipants(cell("discussants"))
+
+	if len(row.Errors) == 0 {
+		row.Errors = nil
+	}
+	return row
+}
+
+// parseImportParticipants splits a cell such as "Ali <ali@uob.edu.ly>; Sara"
+// into people identified by email, name, or both.
+func parseImportParticipants(value string) []data.ImportParticipant {
+	var people []data.ImportParticipant
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ';' || r == ',' || r == '\n'
+	}) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		var p data.ImportParticipant
+		if open := strings.Index(entry, "<"); open >= 0 && strings.HasSuffix(entry, ">") {
+			p.Name = strings.TrimSpace(entry[:open])
+			p.Email = strings.TrimSpace(entry[open+1 : len(entry)-1])
+		} else if strings.Contains(entry, "@") {
+			p.Email = entry
+		} else {
+			p.Name = entry
+		}
+		people = append(people, p)
+	}
+	return people
+}
+
+func isBlankRecord(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"time"
+)
+
+// bookEventRetention is how long deduplication rows of views and downloads are
+// kept. Only today's rows are needed; a little slack covers time zones.
+const bookEventRetention = 2 * 24 * time.Hour
+
+// runPeriodically runs job right away and then every interval for the
+// lifetime of the process.
+func (app *application) runPeriodically(name string, interval time.Duration, job func() error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := job(); err != nil {
+				app.log.Printf("Job %s failed: %v", name, err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// startJobs schedules the background maintenance tasks.
+func (app *application) startJobs() {
+	app.runPeriodically("prune book events", 6*time.Hour, func() error {
+		pruned, err := app.Model.AnalyticsDB.PruneBookEvents(time.Now().Add(-bookEventRetention))
+		if err != nil {
+			return err
+		}
+		if pruned > 0 {
+			app.infoLog.Printf("Pruned %d book events", pruned)
+		}
+		return nil
+	})
+	app.runPeriodically("purge trash", 24*time.Hour, app.purgeTrash)
+	app.runPeriodically("prune sessions", 24*time.Hour, func() error {
+		_, err := app.Model.SessionDB.PruneSessions(time.Now().Add(-sessionPruneAge))
+		return err
+	})
+	app.runPeriodically("prune login challenges", time.Hour, func() error {
+		_, err := app.Model.TwoFactorDB.PruneChallenges()
+		return err
+	})
+	app.runPeriodically("prune login failures", 6*time.Hour, func() error {
+		_, err := app.Model.LoginThrottleDB.PruneFailures(time.Now().Add(-loginFailurePruneAge))
+		return err
+	})
+}
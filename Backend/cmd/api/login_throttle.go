@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// loginFailurePruneAge is how long failed sign-ins are kept after the last
+// one. It outlasts every throttle window and lockout.
+const loginFailurePruneAge = 24 * time.Hour
+
+// throttleKey is a key failed attempts are counted against.
+type throttleKey struct {
+	scope  string
+	key    string
+	policy data.ThrottlePolicy
+}
+
+// signinKeys are the keys a password sign-in for email is counted against:
+// the client's address, so one address cannot spray many accounts, and the
+// account, so many addresses cannot hammer one.
+func signinKeys(r *http.Request, email string) []throttleKey {
+	return []throttleKey{
+		{data.ThrottleIP, clientIP(r), data.IPThrottle},
+		accountKey(email),
+	}
+}
+
+func accountKey(email string) throttleKey {
+	return throttleKey{data.ThrottleAccount, email, data.AccountThrottle}
+}
+
+// twoFactorKey counts wrong second-factor codes of the user across login
+// challenges, which only cap the attempts of one challenge each.
+func twoFactorKey(userID uuid.UUID) throttleKey {
+	return throttleKey{data.ThrottleTwoFactor, userID.String(), data.AccountThrottle}
+}
+
+// throttled responds 429 and reports true when one of the keys has to wait
+// before its next attempt.
+func (app *application) throttled(w http.ResponseWriter, r *http.Request, keys []throttleKey) bool {
+	for _, k := range keys {
+		wait, locked, err := app.Model.LoginThrottleDB.RetryAfter(k.scope, k.key, k.policy)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return true
+		}
+		if wait <= 0 {
+			continue
+		}
+
+		seconds := int(math.Ceil(wait.Seconds()))
+		message := fmt.Sprintf("يرجى انتظار %d دقيقة و %d ثانية قبل المحاولة مرة أخرى", seconds/60, seconds%60)
+		if locked {
+			message = fmt.Sprintf("تم إيقاف تسجيل الدخول مؤقتاً بسبب كثرة المحاولات الفاشلة، يرجى المحاولة بعد %d دقيقة و %d ثانية", seconds/60, seconds%60)
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		app.errorResponse(w, r, http.StatusTooManyRequests, utils.Envelope{
+			"message":     message,
+			"retry_after": seconds,
+		})
+		return true
+	}
+	return false
+}
+
+// recordFailure counts a failed attempt against each of the keys. userID is
+// the account attempted, or uuid.Nil when there is none; locking it out is
+// audited. Throttling must never fail the response, so errors are only
+// logged.
+func (app *application) recordFailure(r *http.Request, userID uuid.UUID, keys []throttleKey) {
+	for _, k := range keys {
+		lockedUntil, err := app.Model.LoginThrottleDB.RecordFailure(k.scope, k.key, k.policy)
+		if err != nil {
+			app.logError(r, err)
+			continue
+		}
+		if lockedUntil != nil && userID != uuid.Nil && k.scope != data.ThrottleIP {
+			app.auditAs(r, uuid.Nil, data.AuditLockAccount, data.AuditEntityUser, userID, nil,
+				map[string]interface{}{"scope": k.scope, "locked_until": lockedUntil})
+		}
+	}
+}
+
+// resetFailures forgets the failures of the keys after a successful attempt.
+func (app *application) resetFailures(r *http.Request, keys ...throttleKey) {
+	for _, k := range keys {
+		if err := app.Model.LoginThrottleDB.Reset(k.scope, k.key); err != nil {
+			app.logError(r, err)
+		}
+	}
+}
+
+// verificationCodeError responds to a rejected verification or reset code.
+func (app *application) verificationCodeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrVerificationCodeAttempts):
+		app.errorResponse(w, r, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, data.ErrInvalidVerificationCode), errors.Is(err, data.ErrVerificationCodeExpired):
+		app.errorResponse(w, r, http.StatusForbidden, err.Error())
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// UnlockUserHandler lifts the lockout of the {id} user and forgets their
+// failed sign-ins and second-factor codes.
+func (app *application) UnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid user ID"))
+		return
+	}
+
+	user, err := app.Model.UserDB.GetUser(userID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	account := strings.ToLower(user.Email)
+	before, err := app.Model.LoginThrottleDB.GetFailures(data.ThrottleAccount, account)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, k := range []throttleKey{accountKey(account), twoFactorKey(userID)} {
+		if err := app.Model.LoginThrottleDB.Reset(k.scope, k.key); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	app.audit(r, data.AuditUnlockAccount, data.AuditEntityUser, userID, before, nil)
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "تم إلغاء قفل الحساب"})
+}
@@ -20,9 +20,10 @@ import (
 )
 
 type config struct {
-	port int
-	env  string
-	db   struct {
+	port               int
+	env                string
+	trashRetentionDays int
+	db                 struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
@@ -36,6 +37,8 @@ type application struct {
 	Model     data.Model
 	infoLog   *log.Logger
 	wsManager *WebSocketManager
+
+	permissions *permissionCache
 }
 
 func main() {
@@ -52,6 +55,7 @@ func main() {
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	flag.IntVar(&cfg.trashRetentionDays, "trash-retention-days", 30, "Days deleted records stay in the trash before they are purged")
 	flag.Parse()
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
@@ -70,8 +74,11 @@ func main() {
 		Model:     model,
 		infoLog:   infoLog,
 		wsManager: NewWebSocketManager(),
+
+		permissions: newPermissionCache(),
 	}
 	utils.SetDB(db)
+	app.startJobs()
 
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.port),
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"project/utils"
@@ -17,98 +18,138 @@ type contextKey string
 
 const UserIDKey contextKey = "userID"
 const UserRoleKey contextKey = "userRole"
+const SessionIDKey contextKey = "sessionID"
 
-func (app *application) AuthMiddleware(next http.Handler) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Header.Get("Upgrade") == "websocket" {
-			tokenString := r.URL.Query().Get("token")
-			if tokenString == "" {
-				app.jwtErrorResponse(w, r, utils.ErrMissingToken)
-				return
-			}
-			token, err := utils.ValidateToken(tokenString)
-			if err != nil {
-				app.jwtErrorResponse(w, r, utils.ErrInvalidToken)
-				return
-			}
+// tokenUser is who an access token was issued to.
+type tokenUser struct {
+	ID        string
+	Roles     []string
+	SessionID uuid.UUID
 
-			claims, ok := token.Claims.(jwt.MapClaims)
-			if !ok || !token.Valid {
-				app.jwtErrorResponse(w, r, utils.ErrInvalidClaims)
-				return
-			}
+	// RolesChanged is set when the token's roles were out of date and Roles
+	// was read from the database instead.
+	RolesChanged bool
+}
 
-			userID, okID := claims["id"].(string)
-			if !okID {
-				app.jwtErrorResponse(w, r, utils.ErrInvalidClaims)
-				return
-			}
-			ctx := context.WithValue(r.Context(), UserIDKey, userID)
-			r = r.WithContext(ctx)
-		} else {
-			var tokenString string
-			cookie, err := r.Cookie("accessToken")
-			if err != nil {
-				authHeader := r.Header.Get("Authorization")
-				if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
-					tokenString = strings.TrimPrefix(authHeader, "Bearer ")
-				} else {
-					app.jwtErrorResponse(w, r, utils.ErrMissingToken)
-					return
-				}
-			} else {
-				tokenString = cookie.Value
-			}
+// requestToken finds the access token of the request: the token query value
+// of a WebSocket upgrade, else the accessToken cookie or a Bearer header.
+func requestToken(r *http.Request) string {
+	if r.Header.Get("Upgrade") == "websocket" {
+		return r.URL.Query().Get("token")
+	}
+	if cookie, err := r.Cookie("accessToken"); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	if authHeader := r.Header.Get("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimPrefix(authHeader, "Bearer ")
+	}
+	return ""
+}
 
-			token, err := utils.ValidateToken(tokenString)
-			if err != nil {
-				app.jwtErrorResponse(w, r, utils.ErrInvalidToken)
-				return
-			}
+// authenticate validates an access token and checks that its session has not
+// been revoked. Roles granted or revoked since the token was issued apply
+// right away. Errors other than the utils token errors come from the
+// database.
+func (app *application) authenticate(tokenString string) (*tokenUser, error) {
+	token, err := utils.ValidateToken(tokenString)
+	if err != nil {
+		if ve, ok := err.(*jwt.ValidationError); ok && ve.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, utils.ErrExpiredToken
+		}
+		return nil, utils.ErrInvalidToken
+	}
 
-			claims, ok := token.Claims.(jwt.MapClaims)
-			if !ok || !token.Valid {
-				app.jwtErrorResponse(w, r, utils.ErrInvalidClaims)
-				return
-			}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, utils.ErrInvalidClaims
+	}
+	if exp, ok := claims["exp"].(float64); !ok {
+		return nil, utils.ErrInvalidClaims
+	} else if time.Unix(int64(exp), 0).Before(time.Now()) {
+		return nil, utils.ErrExpiredToken
+	}
 
-			if exp, ok := claims["exp"].(float64); ok {
-				expTime := time.Unix(int64(exp), 0)
-				if expTime.Before(time.Now()) {
-					app.jwtErrorResponse(w, r, utils.ErrExpiredToken)
-					return
-				}
-			} else {
-				app.jwtErrorResponse(w, r, utils.ErrInvalidClaims)
-				return
-			}
+	user := &tokenUser{}
+	user.ID, ok = claims["id"].(string)
+	if !ok {
+		return nil, utils.ErrInvalidClaims
+	}
+	userID, err := uuid.Parse(user.ID)
+	if err != nil {
+		return nil, utils.ErrInvalidClaims
+	}
+	sessionID, _ := claims["sid"].(string)
+	if user.SessionID, err = uuid.Parse(sessionID); err != nil {
+		return nil, utils.ErrInvalidClaims
+	}
 
-			userID, okID := claims["id"].(string)
-			if !okID {
-				app.jwtErrorResponse(w, r, utils.ErrInvalidClaims)
-				return
+	if rolesInterface, ok := claims["user_role"].([]interface{}); ok {
+		user.Roles = make([]string, 0, len(rolesInterface))
+		for _, role := range rolesInterface {
+			if roleStr, ok := role.(string); ok && roleStr != "NULL" && roleStr != "" {
+				user.Roles = append(user.Roles, roleStr)
 			}
+		}
+	}
+
+	rolesVersion, active, err := app.Model.SessionDB.TouchSession(user.SessionID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, utils.ErrRevokedToken
+	}
+	if version, ok := claims["rv"].(float64); !ok || int(version) != rolesVersion {
+		if user.Roles, err = app.Model.UserRoleDB.GetUserRoles(userID); err != nil {
+			return nil, err
+		}
+		user.RolesChanged = true
+	}
+	return user, nil
+}
+
+// withTokenUser stores the authenticated user in the request's context.
+func withTokenUser(r *http.Request, user *tokenUser) *http.Request {
+	ctx := context.WithValue(r.Context(), UserIDKey, user.ID)
+	ctx = context.WithValue(ctx, UserRoleKey, user.Roles)
+	ctx = context.WithValue(ctx, SessionIDKey, user.SessionID)
+	return r.WithContext(ctx)
+}
 
-			var userRoles []string
-			userRolesInterface, okRoles := claims["user_role"].([]interface{})
+func (app *application) AuthMiddleware(next http.Handler) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString := requestToken(r)
+		if tokenString == "" {
+			app.jwtErrorResponse(w, r, utils.ErrMissingToken)
+			return
+		}
 
-			if okRoles && len(userRolesInterface) > 0 {
-				userRoles = make([]string, 0, len(userRolesInterface))
-				for _, role := range userRolesInterface {
-					if roleStr, ok := role.(string); ok && roleStr != "NULL" && roleStr != "" {
-						userRoles = append(userRoles, roleStr)
-					}
-				}
+		user, err := app.authenticate(tokenString)
+		if err != nil {
+			if isTokenError(err) {
+				app.jwtErrorResponse(w, r, err)
+			} else {
+				app.serverErrorResponse(w, r, err)
 			}
-			ctx := context.WithValue(r.Context(), UserIDKey, userID)
-			ctx = context.WithValue(ctx, UserRoleKey, userRoles)
-			r = r.WithContext(ctx)
+			return
 		}
 
-		next.ServeHTTP(w, r)
+		if user.RolesChanged {
+			w.Header().Set("X-Roles-Changed", "true")
+		}
+		next.ServeHTTP(w, withTokenUser(r, user))
 	})
 }
 
+func isTokenError(err error) bool {
+	for _, tokenErr := range []error{utils.ErrInvalidToken, utils.ErrExpiredToken, utils.ErrInvalidClaims, utils.ErrRevokedToken} {
+		if errors.Is(err, tokenErr) {
+			return true
+		}
+	}
+	return false
+}
+
 func secureHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com")
@@ -149,57 +190,22 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
-func (app *application) AdminOnlyMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userRoles, ok := r.Context().Value(UserRoleKey).([]string)
-		if !ok {
-			app.unauthorizedResponse(w, r)
-			return
-		}
-
-		isAdmin := false
-		for _, role := range userRoles {
-			if role == "admin" {
-				isAdmin = true
-				break
-			}
-		}
-
-		if !isAdmin {
-			app.forbiddenResponse(w, r)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
 
-func (app *application) AdminOrSelfMiddleware(next http.Handler) http.Handler {
+// Require lets the request through when one of the caller's roles holds the
+// permission.
+func (app *application) Require(permission string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userRoles, ok := r.Context().Value(UserRoleKey).([]string)
-		if !ok {
+		if _, ok := r.Context().Value(UserRoleKey).([]string); !ok {
 			app.unauthorizedResponse(w, r)
 			return
 		}
 
-		currentUserID := uuid.MustParse(r.Context().Value(UserIDKey).(string))
-
-		vars := r.PathValue("id")
-		requestedUserID, err := uuid.Parse(vars)
+		allowed, err := app.hasPermission(r, permission)
 		if err != nil {
-			app.badRequestResponse(w, r, err)
+			app.serverErrorResponse(w, r, err)
 			return
 		}
-
-		isAdmin := false
-		for _, role := range userRoles {
-			if role == "admin" {
-				isAdmin = true
-				break
-			}
-		}
-
-		if !isAdmin && currentUserID != requestedUserID {
+		if !allowed {
 			app.forbiddenResponse(w, r)
 			return
 		}
@@ -208,71 +214,28 @@ func (app *application) AdminOrSelfMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) AdminOrTeacherMiddleware(next http.Handler) http.Handler {
+// RequireOrOwner is Require, except that the owner of the resource the
+// request is about does not need the permission.
+func (app *application) RequireOrOwner(permission string, owns ownership, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userRoles, ok := r.Context().Value(UserRoleKey).([]string)
-		if !ok {
-			app.unauthorizedResponse(w, r)
-			return
-		}
-
-		isAuthorized := false
-		for _, role := range userRoles {
-			if role == "admin" || role == "teacher" {
-				isAuthorized = true
-				break
-			}
-		}
-
-		if !isAuthorized {
-			app.forbiddenResponse(w, r)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
-func (app *application) AdminOrStudentMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userRoles, ok := r.Context().Value(UserRoleKey).([]string)
-		if !ok {
+		if _, ok := r.Context().Value(UserRoleKey).([]string); !ok {
 			app.unauthorizedResponse(w, r)
 			return
 		}
 
-		isAuthorized := false
-		for _, role := range userRoles {
-			if role == "admin" || role == "graduation_student" {
-				isAuthorized = true
-				break
-			}
-		}
-
-		if !isAuthorized {
-			app.forbiddenResponse(w, r)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
-func (app *application) TeacherOnlyMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userRoles, ok := r.Context().Value(UserRoleKey).([]string)
-		if !ok {
-			app.unauthorizedResponse(w, r)
+		allowed, err := app.hasPermission(r, permission)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
 			return
 		}
-
-		isAdmin := false
-		for _, role := range userRoles {
-			if role == "teacher" {
-				isAdmin = true
-				break
+		if !allowed {
+			allowed, err = owns(r)
+			if err != nil {
+				app.handleRetrievalError(w, r, err)
+				return
 			}
 		}
-
-		if !isAdmin {
+		if !allowed {
 			app.forbiddenResponse(w, r)
 			return
 		}
@@ -280,30 +243,7 @@ func (app *application) TeacherOnlyMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
-func (app *application) GradStudentOnlyMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userRoles, ok := r.Context().Value(UserRoleKey).([]string)
-		if !ok {
-			app.unauthorizedResponse(w, r)
-			return
-		}
-
-		isStudent := false
-		for _, role := range userRoles {
-			if role == "graduation_student" || role == "admin" || role == "teacher" {
-				isStudent = true
-				break
-			}
-		}
-
-		if !isStudent {
-			app.forbiddenResponse(w, r)
-			return
-		}
 
-		next.ServeHTTP(w, r)
-	})
-}
 func (app *application) AdvisorsOnlyMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		userIDStr, ok := r.Context().Value(UserIDKey).(string)
@@ -345,78 +285,6 @@ func (app *application) AdvisorsOnlyMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
-func (app *application) AdminOrProjectOwnerOnlyMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userIDStr, ok := r.Context().Value(UserIDKey).(string)
-		if !ok {
-			app.unauthorizedResponse(w, r)
-			return
-		}
-
-		userID, err := uuid.Parse(userIDStr)
-		if err != nil {
-			app.badRequestResponse(w, r, err)
-			return
-		}
-
-		userRoles, ok := r.Context().Value(UserRoleKey).([]string)
-		if !ok {
-			app.unauthorizedResponse(w, r)
-			return
-		}
-
-		isAdmin := false
-		for _, role := range userRoles {
-			if role == "admin" {
-				isAdmin = true
-				break
-			}
-		}
-
-		if isAdmin {
-			next.ServeHTTP(w, r)
-			return
-		}
-
-		preProjectID, err := uuid.Parse(r.PathValue("id"))
-		if err != nil {
-			app.badRequestResponse(w, r, err)
-			return
-		}
-
-		projectDetails, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(preProjectID)
-		if err != nil {
-			app.handleRetrievalError(w, r, err)
-			return
-		}
-
-		if projectDetails.PreProject.ProjectOwner != userID {
-			app.forbiddenResponse(w, r)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
-func (app *application) BlockConversationsForRoleGraduatedMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userRoles, ok := r.Context().Value(UserRoleKey).([]string)
-		if !ok {
-			app.unauthorizedResponse(w, r)
-			return
-		}
-
-		for _, role := range userRoles {
-			if role == "graduated_student" {
-				app.forbiddenResponse(w, r)
-				return
-			}
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
-
 func (app *application) ChatParticipantMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		userIDStr, ok := r.Context().Value(UserIDKey).(string)
@@ -473,82 +341,27 @@ func (app *application) ChatParticipantMiddleware(next http.Handler) http.Handle
 		next.ServeHTTP(w, r)
 	})
 }
+
+// PassTokenMiddleware authenticates the request when it carries a valid
+// token and lets it through anonymously otherwise.
 func (app *application) PassTokenMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Try to get token from multiple sources
-		var tokenString string
-
-		// Check cookie
-		cookie, err := r.Cookie("accessToken")
-		if err == nil {
-			tokenString = cookie.Value
-		}
-
-		// If no cookie, check Authorization header
-		if tokenString == "" {
-			authHeader := r.Header.Get("Authorization")
-			if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
-				tokenString = strings.TrimPrefix(authHeader, "Bearer ")
-			}
-		}
-
-		// If no token found, proceed without context
+		tokenString := requestToken(r)
 		if tokenString == "" {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Validate the token
-		token, err := utils.ValidateToken(tokenString)
+		user, err := app.authenticate(tokenString)
 		if err != nil {
-			// Log the token validation error (optional)
-			app.infoLog.Printf("Token validation error: %v", err)
-			next.ServeHTTP(w, r)
-			return
-		}
-
-		// Extract claims
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok || !token.Valid {
-			next.ServeHTTP(w, r)
-			return
-		}
-
-		// Safely extract user ID
-		userID, ok := claims["id"].(string)
-		if !ok {
+			if !isTokenError(err) {
+				app.logError(r, err)
+			}
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Extract user roles with robust type checking
-		var userRoles []string
-		if rolesInterface, ok := claims["user_role"].([]interface{}); ok {
-			userRoles = make([]string, 0, len(rolesInterface))
-			for _, role := range rolesInterface {
-				if roleStr, ok := role.(string); ok {
-					// Additional filtering for valid roles
-					if roleStr != "" && roleStr != "NULL" {
-						userRoles = append(userRoles, roleStr)
-					}
-				}
-			}
-		} else if roleSingleInterface, ok := claims["user_role"].(string); ok {
-			// Handle case where user_role might be a single string
-			if roleSingleInterface != "" && roleSingleInterface != "NULL" {
-				userRoles = []string{roleSingleInterface}
-			}
-		}
-
-		// Create a new context with user ID and roles
-		ctx := context.WithValue(r.Context(), UserIDKey, userID)
-		ctx = context.WithValue(ctx, UserRoleKey, userRoles)
-
-		// Create a new request with the updated context
-		r = r.WithContext(ctx)
-
-		// Call the next handler
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, withTokenUser(r, user))
 	}
 }
 
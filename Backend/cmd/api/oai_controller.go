@@ -0,0 +1,664 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"project/internal/data"
+	"project/utils/markdown"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OAI-PMH 2.0 (https://www.openarchives.org/OAI/openarchivesprotocol.html)
+// exposes the book archive to institutional repository harvesters.
+//
+// Sets follow the academic calendar: "year:2021" holds every book of 2021 and
+// "year:2021:fall" only those of its fall term. "program:cs" holds the books
+// of a degree program.
+//
+// Deleted books are kept as deleted headers, through the trash and after it
+// is purged, so the repository declares persistent deletion support.
+
+const (
+	oaiPageSize    = 100
+	oaiGranularity = "2006-01-02T15:04:05Z"
+	oaiDayLayout   = "2006-01-02"
+	oaiDCPrefix    = "oai_dc"
+)
+
+type oaiError struct {
+	Code    string `xml:"code,attr"`
+	Message string `xml:",chardata"`
+}
+
+func (e *oaiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func oaiErr(code, format string, args ...interface{}) *oaiError {
+	return &oaiError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+type oaiRequest struct {
+	Verb           string `xml:"verb,attr,omitempty"`
+	Identifier     string `xml:"identifier,attr,omitempty"`
+	MetadataPrefix string `xml:"metadataPrefix,attr,omitempty"`
+	From           string `xml:"from,attr,omitempty"`
+	Until          string `xml:"until,attr,omitempty"`
+	Set            string `xml:"set,attr,omitempty"`
+	Token          string `xml:"resumptionToken,attr,omitempty"`
+	BaseURL        string `xml:",chardata"`
+}
+
+type oaiResponse struct {
+	XMLName        xml.Name    `xml:"OAI-PMH"`
+	Xmlns          string      `xml:"xmlns,attr"`
+	XmlnsXsi       string      `xml:"xmlns:xsi,attr"`
+	SchemaLocation string      `xml:"xsi:schemaLocation,attr"`
+	ResponseDate   string      `xml:"responseDate"`
+	Request        oaiRequest  `xml:"request"`
+	Errors         []*oaiError `xml:"error,omitempty"`
+	Body           interface{}
+}
+
+type oaiIdentify struct {
+	XMLName           xml.Name `xml:"Identify"`
+	RepositoryName    string   `xml:"repositoryName"`
+	BaseURL           string   `xml:"baseURL"`
+	ProtocolVersion   string   `xml:"protocolVersion"`
+	AdminEmail        []string `xml:"adminEmail"`
+	EarliestDatestamp string   `xml:"earliestDatestamp"`
+	DeletedRecord     string   `xml:"deletedRecord"`
+	Granularity       string   `xml:"granularity"`
+}
+
+type oaiMetadataFormat struct {
+	Prefix    string `xml:"metadataPrefix"`
+	Schema    string `xml:"schema"`
+	Namespace string `xml:"metadataNamespace"`
+}
+
+type oaiListMetadataFormats struct {
+	XMLName xml.Name            `xml:"ListMetadataFormats"`
+	Formats []oaiMetadataFormat `xml:"metadataFormat"`
+}
+
+type oaiSet struct {
+	Spec string `xml:"setSpec"`
+	Name string `xml:"setName"`
+}
+
+type oaiListSets struct {
+	XMLName xml.Name `xml:"ListSets"`
+	Sets    []oaiSet `xml:"set"`
+}
+
+type oaiHeader struct {
+	Status     string   `xml:"status,attr,omitempty"`
+	Identifier string   `xml:"identifier"`
+	Datestamp  string   `xml:"datestamp"`
+	SetSpecs   []string `xml:"setSpec"`
+}
+
+type oaiDC struct {
+	XMLName        xml.Name `xml:"oai_dc:dc"`
+	XmlnsOAIDC     string   `xml:"xmlns:oai_dc,attr"`
+	XmlnsDC        string   `xml:"xmlns:dc,attr"`
+	XmlnsXsi       string   `xml:"xmlns:xsi,attr"`
+	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+	Title          []string `xml:"dc:title"`
+	Creator        []string `xml:"dc:creator"`
+	Contributor    []string `xml:"dc:contributor"`
+	Description    []string `xml:"dc:description"`
+	Date           []string `xml:"dc:date"`
+	Type           []string `xml:"dc:type"`
+	Identifier     []string `xml:"dc:identifier"`
+	Subject        []string `xml:"dc:subject"`
+	Language       []string `xml:"dc:language"`
+}
+
+type oaiMetadata struct {
+	DC oaiDC `xml:"oai_dc:dc"`
+}
+
+// oaiRecord has no metadata when the book was deleted.
+type oaiRecord struct {
+	Header   oaiHeader    `xml:"header"`
+	Metadata *oaiMetadata `xml:"metadata,omitempty"`
+}
+
+type oaiResumptionToken struct {
+	CompleteListSize int    `xml:"completeListSize,attr"`
+	Cursor           int    `xml:"cursor,attr"`
+	Value            string `xml:",chardata"`
+}
+
+type oaiListIdentifiers struct {
+	XMLName xml.Name            `xml:"ListIdentifiers"`
+	Headers []oaiHeader         `xml:"header"`
+	Token   *oaiResumptionToken `xml:"resumptionToken,omitempty"`
+}
+
+type oaiListRecords struct {
+	XMLName xml.Name            `xml:"ListRecords"`
+	Records []oaiRecord         `xml:"record"`
+	Token   *oaiResumptionToken `xml:"resumptionToken,omitempty"`
+}
+
+type oaiGetRecord struct {
+	XMLName xml.Name  `xml:"GetRecord"`
+	Record  oaiRecord `xml:"record"`
+}
+
+// oaiToken is the decoded resumption token. It carries the original request
+// arguments and the keyset position, so no server-side state is needed.
+type oaiToken struct {
+	Prefix  string    `json:"p"`
+	Set     string    `json:"s,omitempty"`
+	From    string    `json:"f,omitempty"`
+	Until   string    `json:"u,omitempty"`
+	Updated time.Time `json:"t"`
+	ID      uuid.UUID `json:"i"`
+	Cursor  int       `json:"c"`
+}
+
+func encodeOAIToken(token oaiToken) string {
+	raw, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeOAIToken(value string) (*oaiToken, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var token oaiToken
+	if err := json.Unmarshal(raw, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func oaiBaseURL() string {
+	return data.Domain + "/oai"
+}
+
+// oaiRepositoryID is the namespace part of the oai identifiers.
+func oaiRepositoryID() string {
+	if u, err := url.Parse(data.Domain); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return "project-archive"
+}
+
+func oaiIdentifier(id uuid.UUID) string {
+	return fmt.Sprintf("oai:%s:book/%s", oaiRepositoryID(), id)
+}
+
+func parseOAIIdentifier(identifier string) (uuid.UUID, bool) {
+	prefix := fmt.Sprintf("oai:%s:book/", oaiRepositoryID())
+	if !strings.HasPrefix(identifier, prefix) {
+		return uuid.Nil, false
+	}
+	id, err := uuid.Parse(strings.TrimPrefix(identifier, prefix))
+	return id, err == nil
+}
+
+// parseOAIDate accepts both granularities. Until is inclusive in OAI-PMH, so
+// it is turned into an exclusive bound one granule later.
+func parseOAIDate(value string, until bool) (*time.Time, string, error) {
+	if value == "" {
+		return nil, "", nil
+	}
+	if t, err := time.Parse(oaiGranularity, value); err == nil {
+		if until {
+			t = t.Add(time.Second)
+		}
+		return &t, "second", nil
+	}
+	if t, err := time.Parse(oaiDayLayout, value); err == nil {
+		if until {
+			t = t.AddDate(0, 0, 1)
+		}
+		return &t, "day", nil
+	}
+	return nil, "", oaiErr("badArgument", "invalid date %q", value)
+}
+
+// parseOAISet reads "year:2021", "year:2021:fall" or "program:cs" into the
+// harvest filter.
+func parseOAISet(spec string, f *data.HarvestFilter) error {
+	if spec == "" {
+		return nil
+	}
+	parts := strings.Split(spec, ":")
+	if len(parts) == 2 && parts[0] == "program" && parts[1] != "" {
+		f.Program = parts[1]
+		return nil
+	}
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != "year" {
+		return oaiErr("noRecordsMatch", "unknown set %q", spec)
+	}
+	year, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return oaiErr("noRecordsMatch", "unknown set %q", spec)
+	}
+	f.Year = &year
+	if len(parts) == 3 {
+		if parts[2] != "spring" && parts[2] != "fall" {
+			return oaiErr("noRecordsMatch", "unknown set %q", spec)
+		}
+		f.Season = parts[2]
+	}
+	return nil
+}
+
+func bookSetSpecs(item data.HarvestItem) []string {
+	specs := []string{
+		fmt.Sprintf("year:%d", item.Year),
+		fmt.Sprintf("year:%d:%s", item.Year, item.Season),
+	}
+	if item.Program != nil {
+		specs = append(specs, "program:"+*item.Program)
+	}
+	return specs
+}
+
+func oaiBookHeader(item data.HarvestItem) oaiHeader {
+	header := oaiHeader{
+		Identifier: oaiIdentifier(item.ID),
+		Datestamp:  item.UpdatedAt.UTC().Format(oaiGranularity),
+		SetSpecs:   bookSetSpecs(item),
+	}
+	if item.Deleted {
+		header.Status = "deleted"
+	}
+	return header
+}
+
+// oaiBookRecord is the record of the item. book is nil when it was deleted.
+func oaiBookRecord(item data.HarvestItem, book *data.BookWithDetails) oaiRecord {
+	if book == nil {
+		item.Deleted = true
+		return oaiRecord{Header: oaiBookHeader(item)}
+	}
+
+	dc := oaiDC{
+		XmlnsOAIDC:     "http://www.openarchives.org/OAI/2.0/oai_dc/",
+		XmlnsDC:        "http://purl.org/dc/elements/1.1/",
+		XmlnsXsi:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "http://www.openarchives.org/OAI/2.0/oai_dc/ http://www.openarchives.org/OAI/2.0/oai_dc.xsd",
+		Title:          []string{book.Name},
+		Date:           []string{strconv.Itoa(book.Year)},
+		Type:           []string{"Text", "Graduation project"},
+		Identifier:     []string{bookURL(book.ID)},
+	}
+	if book.Identifier != "" {
+		dc.Identifier = append(dc.Identifier, book.Identifier, bookPermalink(book.Identifier))
+	}
+	if book.Description != nil && *book.Description != "" {
+		dc.Description = []string{markdown.Text(*book.Description)}
+	}
+	for _, lang := range data.ProjectLanguages {
+		if title := book.Title(lang); title != nil && *title != book.Name {
+			dc.Title = append(dc.Title, *title)
+		}
+		if abstract := book.Abstract(lang); abstract != nil && (book.Description == nil || *abstract != *book.Description) {
+			dc.Description = append(dc.Description, markdown.Text(*abstract))
+		}
+	}
+	dc.Subject = append(dc.Subject, book.Keywords...)
+	if book.Language != "" {
+		dc.Language = []string{book.Language}
+	}
+	for _, student := range book.Students {
+		dc.Creator = append(dc.Creator, student.Name)
+	}
+	for _, advisor := range book.Advisors {
+		dc.Contributor = append(dc.Contributor, advisor.Name)
+	}
+	for _, discussant := range book.Discussants {
+		dc.Contributor = append(dc.Contributor, discussant.Name)
+	}
+	if book.File != nil && *book.File != "" {
+		dc.Identifier = append(dc.Identifier, *book.File)
+	}
+
+	return oaiRecord{Header: oaiBookHeader(item), Metadata: &oaiMetadata{DC: dc}}
+}
+
+// oaiAllowedArgs lists the required and optional arguments of every verb.
+var oaiAllowedArgs = map[string]struct{ required, optional []string }{
+	"Identify":            {},
+	"ListMetadataFormats": {optional: []string{"identifier"}},
+	"ListSets":            {optional: []string{"resumptionToken"}},
+	"ListIdentifiers":     {required: []string{"metadataPrefix"}, optional: []string{"from", "until", "set", "resumptionToken"}},
+	"ListRecords":         {required: []string{"metadataPrefix"}, optional: []string{"from", "until", "set", "resumptionToken"}},
+	"GetRecord":           {required: []string{"identifier", "metadataPrefix"}},
+}
+
+func checkOAIArgs(verb string, args url.Values) *oaiError {
+	spec, ok := oaiAllowedArgs[verb]
+	if !ok {
+		return oaiErr("badVerb", "illegal OAI verb %q", verb)
+	}
+
+	allowed := map[string]bool{"verb": true}
+	for _, name := range append(spec.required, spec.optional...) {
+		allowed[name] = true
+	}
+	for name, values := range args {
+		if !allowed[name] {
+			return oaiErr("badArgument", "illegal argument %q", name)
+		}
+		if len(values) > 1 {
+			return oaiErr("badArgument", "repeated argument %q", name)
+		}
+	}
+
+	// The resumption token is an exclusive argument.
+	if args.Get("resumptionToken") != "" {
+		if len(args) > 2 {
+			return oaiErr("badArgument", "resumptionToken must be the only argument")
+		}
+		return nil
+	}
+	for _, name := range spec.required {
+		if args.Get(name) == "" {
+			return oaiErr("badArgument", "missing required argument %q", name)
+		}
+	}
+	return nil
+}
+
+// OAIHandler serves every OAI-PMH verb on a single endpoint, over GET or
+// form-encoded POST as the protocol requires.
+func (app *application) OAIHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	args := r.Form
+	verb := args.Get("verb")
+
+	resp := oaiResponse{
+		Xmlns:          "http://www.openarchives.org/OAI/2.0/",
+		XmlnsXsi:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "http://www.openarchives.org/OAI/2.0/ http://www.openarchives.org/OAI/2.0/OAI-PMH.xsd",
+		ResponseDate:   time.Now().UTC().Format(oaiGranularity),
+		Request:        oaiRequest{BaseURL: oaiBaseURL()},
+	}
+
+	body, err := app.oaiDispatch(verb, args)
+	if err != nil {
+		var oaiE *oaiError
+		if !errors.As(err, &oaiE) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		resp.Errors = []*oaiError{oaiE}
+	} else {
+		// The request element echoes the arguments only when they were valid.
+		resp.Request = oaiRequest{
+			Verb:           verb,
+			Identifier:     args.Get("identifier"),
+			MetadataPrefix: args.Get("metadataPrefix"),
+			From:           args.Get("from"),
+			Until:          args.Get("until"),
+			Set:            args.Get("set"),
+			Token:          args.Get("resumptionToken"),
+			BaseURL:        oaiBaseURL(),
+		}
+		resp.Body = body
+	}
+
+	output, err := xml.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(output)
+}
+
+func (app *application) oaiDispatch(verb string, args url.Values) (interface{}, error) {
+	if oaiE := checkOAIArgs(verb, args); oaiE != nil {
+		return nil, oaiE
+	}
+
+	switch verb {
+	case "Identify":
+		return app.oaiIdentify()
+	case "ListMetadataFormats":
+		if identifier := args.Get("identifier"); identifier != "" {
+			if _, _, err := app.oaiLookupBook(identifier); err != nil {
+				return nil, err
+			}
+		}
+		return oaiListMetadataFormats{Formats: []oaiMetadataFormat{{
+			Prefix:    oaiDCPrefix,
+			Schema:    "http://www.openarchives.org/OAI/2.0/oai_dc.xsd",
+			Namespace: "http://www.openarchives.org/OAI/2.0/oai_dc/",
+		}}}, nil
+	case "ListSets":
+		if args.Get("resumptionToken") != "" {
+			return nil, oaiErr("badResumptionToken", "ListSets is never split")
+		}
+		return app.oaiListSets()
+	case "GetRecord":
+		if args.Get("metadataPrefix") != oaiDCPrefix {
+			return nil, oaiErr("cannotDisseminateFormat", "only %s is supported", oaiDCPrefix)
+		}
+		item, book, err := app.oaiLookupBook(args.Get("identifier"))
+		if err != nil {
+			return nil, err
+		}
+		return oaiGetRecord{Record: oaiBookRecord(*item, book)}, nil
+	default:
+		return app.oaiList(verb, args)
+	}
+}
+
+func (app *application) oaiIdentify() (interface{}, error) {
+	earliest, err := app.Model.BookDB.EarliestBookUpdate()
+	if err != nil {
+		return nil, err
+	}
+	if earliest.IsZero() {
+		earliest = time.Now()
+	}
+
+	adminEmail := os.Getenv("OAI_ADMIN_EMAIL")
+	if adminEmail == "" {
+		adminEmail = os.Getenv("GMAIL_USER")
+	}
+
+	return oaiIdentify{
+		RepositoryName:    "Project Archive",
+		BaseURL:           oaiBaseURL(),
+		ProtocolVersion:   "2.0",
+		AdminEmail:        []string{adminEmail},
+		EarliestDatestamp: earliest.UTC().Format(oaiGranularity),
+		DeletedRecord:     "persistent",
+		Granularity:       "YYYY-MM-DDThh:mm:ssZ",
+	}, nil
+}
+
+func (app *application) oaiListSets() (interface{}, error) {
+	terms, err := app.Model.BookDB.ListBookTerms()
+	if err != nil {
+		return nil, err
+	}
+	programs, err := app.Model.BookDB.ListBookPrograms()
+	if err != nil {
+		return nil, err
+	}
+
+	var sets []oaiSet
+	lastYear := 0
+	for _, term := range terms {
+		if term.Year != lastYear {
+			sets = append(sets, oaiSet{Spec: fmt.Sprintf("year:%d", term.Year), Name: fmt.Sprintf("Projects of %d", term.Year)})
+			lastYear = term.Year
+		}
+		sets = append(sets, oaiSet{
+			Spec: fmt.Sprintf("year:%d:%s", term.Year, term.Season),
+			Name: fmt.Sprintf("Projects of %s %d", term.Season, term.Year),
+		})
+	}
+	for _, program := range programs {
+		sets = append(sets, oaiSet{Spec: "program:" + program, Name: fmt.Sprintf("Projects of the %s program", program)})
+	}
+	if len(sets) == 0 {
+		return nil, oaiErr("noSetHierarchy", "the archive is empty")
+	}
+	return oaiListSets{Sets: sets}, nil
+}
+
+// oaiLookupBook returns the header of the identified book and, unless it was
+// deleted, the book.
+func (app *application) oaiLookupBook(identifier string) (*data.HarvestItem, *data.BookWithDetails, error) {
+	id, ok := parseOAIIdentifier(identifier)
+	if !ok {
+		return nil, nil, oaiErr("idDoesNotExist", "unknown identifier %q", identifier)
+	}
+	item, err := app.Model.BookDB.GetHarvestItem(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil, oaiErr("idDoesNotExist", "unknown identifier %q", identifier)
+		}
+		return nil, nil, err
+	}
+	if item.Deleted {
+		return item, nil, nil
+	}
+	books, err := app.Model.BookDB.GetBooks([]uuid.UUID{id})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(books) == 0 {
+		return item, nil, nil
+	}
+	return item, &books[0], nil
+}
+
+// oaiList implements ListIdentifiers and ListRecords, which only differ in how
+// much of each record they return.
+func (app *application) oaiList(verb string, args url.Values) (interface{}, error) {
+	token := oaiToken{
+		Prefix: args.Get("metadataPrefix"),
+		Set:    args.Get("set"),
+		From:   args.Get("from"),
+		Until:  args.Get("until"),
+	}
+	resuming := args.Get("resumptionToken") != ""
+	if resuming {
+		decoded, err := decodeOAIToken(args.Get("resumptionToken"))
+		if err != nil {
+			return nil, oaiErr("badResumptionToken", "the resumption token is invalid")
+		}
+		token = *decoded
+	}
+
+	if token.Prefix != oaiDCPrefix {
+		return nil, oaiErr("cannotDisseminateFormat", "only %s is supported", oaiDCPrefix)
+	}
+
+	filter := data.HarvestFilter{Limit: oaiPageSize + 1}
+	from, fromGranularity, err := parseOAIDate(token.From, false)
+	if err != nil {
+		return nil, err
+	}
+	until, untilGranularity, err := parseOAIDate(token.Until, true)
+	if err != nil {
+		return nil, err
+	}
+	if from != nil && until != nil && fromGranularity != untilGranularity {
+		return nil, oaiErr("badArgument", "from and until must have the same granularity")
+	}
+	if from != nil && until != nil && !from.Before(*until) {
+		return nil, oaiErr("badArgument", "from must not be later than until")
+	}
+	filter.From, filter.Until = from, until
+	if err := parseOAISet(token.Set, &filter); err != nil {
+		return nil, err
+	}
+	if resuming {
+		filter.AfterUpdate = &token.Updated
+		filter.AfterID = token.ID
+	}
+
+	items, err := app.Model.BookDB.HarvestBooks(filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 && !resuming {
+		return nil, oaiErr("noRecordsMatch", "no records match the request")
+	}
+
+	hasMore := len(items) > oaiPageSize
+	if hasMore {
+		items = items[:oaiPageSize]
+	}
+
+	// A resumption token is returned on every page of a split list; the last
+	// page carries an empty one.
+	var resumption *oaiResumptionToken
+	if hasMore || resuming {
+		total, err := app.Model.BookDB.CountHarvestBooks(filter)
+		if err != nil {
+			return nil, err
+		}
+		resumption = &oaiResumptionToken{CompleteListSize: total, Cursor: token.Cursor}
+		if hasMore {
+			last := items[len(items)-1]
+			next := token
+			next.Updated = last.UpdatedAt
+			next.ID = last.ID
+			next.Cursor = token.Cursor + len(items)
+			resumption.Value = encodeOAIToken(next)
+		}
+	}
+
+	if verb == "ListIdentifiers" {
+		list := oaiListIdentifiers{Token: resumption}
+		for _, item := range items {
+			list.Headers = append(list.Headers, oaiBookHeader(item))
+		}
+		return list, nil
+	}
+
+	var ids []uuid.UUID
+	for _, item := range items {
+		if !item.Deleted {
+			ids = append(ids, item.ID)
+		}
+	}
+	books, err := app.Model.BookDB.GetBooks(ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uuid.UUID]*data.BookWithDetails, len(books))
+	for i := range books {
+		byID[books[i].ID] = &books[i]
+	}
+
+	// A book deleted since the headers were read is reported as deleted.
+	list := oaiListRecords{Token: resumption}
+	for _, item := range items {
+		list.Records = append(list.Records, oaiBookRecord(item, byID[item.ID]))
+	}
+	return list, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"project/internal/data"
+)
+
+// bookPermalink is the address to cite a book by. Unlike bookURL it carries
+// the persistent identifier, which outlives changes to the API's routes.
+func bookPermalink(identifier string) string {
+	return fmt.Sprintf("%s/pid/%s", data.Domain, identifier)
+}
+
+// citableBookURL prefers the permalink, for books that have an identifier.
+func citableBookURL(book data.Book) string {
+	if book.Identifier == "" {
+		return bookURL(book.ID)
+	}
+	return bookPermalink(book.Identifier)
+}
+
+// ResolvePermalinkHandler redirects a persistent identifier to the book it
+// names. A superseded identifier is permanently redirected to the book's
+// current permalink, so clients learn the identifier to cite from now on.
+func (app *application) ResolvePermalinkHandler(w http.ResponseWriter, r *http.Request) {
+	identifier := data.NormalizeBookIdentifier(r.PathValue("identifier"))
+	if identifier == "" {
+		app.badRequestResponse(w, r, errors.New("identifier is required"))
+		return
+	}
+
+	bookID, current, err := app.Model.BookDB.ResolveBookIdentifier(identifier)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	if current != identifier {
+		http.Redirect(w, r, bookPermalink(current), http.StatusMovedPermanently)
+		return
+	}
+	http.Redirect(w, r, bookURL(bookID), http.StatusFound)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// parsePermissions reads the comma-separated permissions form value.
+func parsePermissions(r *http.Request) []string {
+	permissions := []string{}
+	for _, permission := range strings.Split(r.FormValue("permissions"), ",") {
+		if permission = strings.TrimSpace(permission); permission != "" && !slices.Contains(permissions, permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions
+}
+
+func (app *application) ListPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.Model.PermissionDB.ListPermissions()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"permissions": permissions})
+}
+
+// ListRolesHandler lists every role with the permissions it holds.
+func (app *application) ListRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.Model.PermissionDB.ListRoles()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"roles": roles})
+}
+
+// CreateRoleHandler adds a role, such as a department head or an external
+// examiner, with the comma-separated permissions given.
+func (app *application) CreateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	known, err := app.Model.PermissionDB.ListPermissions()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	role := &data.Role{
+		Name:        strings.ToLower(strings.TrimSpace(r.FormValue("name"))),
+		Permissions: parsePermissions(r),
+	}
+	v := validator.New()
+	data.ValidateRole(v, role, known)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.PermissionDB.InsertRole(role); err != nil {
+		if errors.Is(err, data.ErrDuplicatedRoleName) {
+			v.AddError("name", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.permissions.invalidate()
+	app.audit(r, data.AuditCreate, data.AuditEntityRole, uuid.Nil, nil, role)
+
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"role": role})
+}
+
+// SetRolePermissionsHandler replaces the permissions of the {id} role with
+// the comma-separated permissions given. Holders of the role get the change
+// on their next request.
+func (app *application) SetRolePermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	roleID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid role ID"))
+		return
+	}
+
+	before, err := app.Model.PermissionDB.GetRole(roleID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	known, err := app.Model.PermissionDB.ListPermissions()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions := parsePermissions(r)
+	v := validator.New()
+	data.ValidateRolePermissions(v, permissions, known)
+
+	// Someone has to be left who can hand the permissions out again.
+	if !slices.Contains(permissions, data.PermRolesManage) {
+		roles, err := app.Model.PermissionDB.ListRoles()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		kept := false
+		for _, role := range roles {
+			if role.ID != roleID && slices.Contains(role.Permissions, data.PermRolesManage) {
+				kept = true
+				break
+			}
+		}
+		v.Check(kept, "permissions", "يجب أن يبقى دور واحد على الأقل يملك صلاحية إدارة الأدوار")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.PermissionDB.SetRolePermissions(roleID, permissions); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	app.permissions.invalidate()
+
+	after := *before
+	after.Permissions = permissions
+	app.audit(r, data.AuditUpdate, data.AuditEntityRole, uuid.Nil, before, after)
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"role": after})
+}
+
+// MyPermissionsHandler lists the permissions the caller's roles hold, for
+// clients to decide what to show.
+func (app *application) MyPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	roles, _ := r.Context().Value(UserRoleKey).([]string)
+	permissions, err := app.permissionsOf(roles)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	slices.Sort(permissions)
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"roles": roles, "permissions": permissions})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// permissionCacheTTL bounds how long another instance's edits to role
+// permissions take to apply here. Edits made through this instance apply
+// at once.
+const permissionCacheTTL = time.Minute
+
+// permissionCache holds which permissions each role has, so access checks do
+// not query the database on every request.
+type permissionCache struct {
+	mu       sync.RWMutex
+	byRole   map[string]map[string]bool
+	loadedAt time.Time
+}
+
+func newPermissionCache() *permissionCache {
+	return &permissionCache{}
+}
+
+// invalidate makes the next check reload the role permissions.
+func (c *permissionCache) invalidate() {
+	c.mu.Lock()
+	c.byRole = nil
+	c.mu.Unlock()
+}
+
+// rolePermissions returns the cached role permissions, reloading them when
+// they are stale.
+func (app *application) rolePermissions() (map[string]map[string]bool, error) {
+	c := app.permissions
+	c.mu.RLock()
+	byRole, loadedAt := c.byRole, c.loadedAt
+	c.mu.RUnlock()
+	if byRole != nil && time.Since(loadedAt) < permissionCacheTTL {
+		return byRole, nil
+	}
+
+	roles, err := app.Model.PermissionDB.RolePermissions()
+	if err != nil {
+		return nil, err
+	}
+	byRole = make(map[string]map[string]bool, len(roles))
+	for role, permissions := range roles {
+		byRole[role] = make(map[string]bool, len(permissions))
+		for _, permission := range permissions {
+			byRole[role][permission] = true
+		}
+	}
+
+	c.mu.Lock()
+	c.byRole, c.loadedAt = byRole, time.Now()
+	c.mu.Unlock()
+	return byRole, nil
+}
+
+// permissionsOf returns the permissions held through any of the roles.
+func (app *application) permissionsOf(roles []string) ([]string, error) {
+	byRole, err := app.rolePermissions()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	permissions := []string{}
+	for _, role := range roles {
+		for permission := range byRole[role] {
+			if !seen[permission] {
+				seen[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	return permissions, nil
+}
+
+// hasPermission reports whether one of the caller's roles holds the
+// permission. Anonymous callers hold none.
+func (app *application) hasPermission(r *http.Request, permission string) (bool, error) {
+	roles, _ := r.Context().Value(UserRoleKey).([]string)
+	if len(roles) == 0 {
+		return false, nil
+	}
+	byRole, err := app.rolePermissions()
+	if err != nil {
+		return false, err
+	}
+	for _, role := range roles {
+		if byRole[role][permission] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// can is hasPermission for checks inside handlers. Should the permissions
+// fail to load, the error is logged and the permission denied.
+func (app *application) can(r *http.Request, permission string) bool {
+	ok, err := app.hasPermission(r, permission)
+	if err != nil {
+		app.logError(r, err)
+	}
+	return ok
+}
+
+// ownership reports whether the caller owns the resource a request is about.
+type ownership func(r *http.Request) (bool, error)
+
+// ownsUser is true when the {id} path value is the caller.
+func ownsUser(r *http.Request) (bool, error) {
+	userID, err := uuid.Parse(r.PathValue("id"))
+	return err == nil && userID == requestUserID(r), nil
+}
+
+// ownsPreProject is true when the caller owns the pre-project of the {id}
+// path value.
+func (app *application) ownsPreProject(r *http.Request) (bool, error) {
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return false, nil
+	}
+	project, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(preProjectID)
+	if err != nil {
+		return false, err
+	}
+	return project.PreProject.ProjectOwner == requestUserID(r), nil
+}
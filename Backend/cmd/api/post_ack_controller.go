@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/markdown"
+	"time"
+)
+
+// ackReminderInterval is how long the author must wait between two
+// reminders of the same post.
+const ackReminderInterval = time.Hour
+
+// AcknowledgePostHandler records that the caller has read a post that asks
+// for acknowledgement.
+func (app *application) AcknowledgePostHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := app.visiblePost(w, r)
+	if !ok {
+		return
+	}
+	if !post.RequiresAck {
+		app.errorResponse(w, r, http.StatusConflict, "هذا المنشور لا يتطلب تأكيد الاطلاع")
+		return
+	}
+
+	acknowledgedAt, err := app.Model.PostDB.Acknowledge(post.ID, requestUserID(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"acknowledged_at": acknowledgedAt})
+}
+
+// PostAckReportHandler shows whoever manages the post which of the users it
+// reaches have acknowledged it and which have not.
+func (app *application) PostAckReportHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := app.visiblePost(w, r)
+	if !ok {
+		return
+	}
+	if !app.canManagePost(r, post) {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	report, err := app.Model.PostDB.AckReport(post.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"report": report})
+}
+
+// RemindPostAckHandler notifies the users who have not acknowledged the post
+// yet, over their socket when connected and by email. Emails are sent in the
+// background.
+func (app *application) RemindPostAckHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := app.visiblePost(w, r)
+	if !ok {
+		return
+	}
+	if !app.canManagePost(r, post) {
+		app.forbiddenResponse(w, r)
+		return
+	}
+	if !post.RequiresAck {
+		app.errorResponse(w, r, http.StatusConflict, "هذا المنشور لا يتطلب تأكيد الاطلاع")
+		return
+	}
+	if !post.IsLive(time.Now()) {
+		app.errorResponse(w, r, http.StatusConflict, "لا يمكن التذكير بمنشور غير منشور حاليا")
+		return
+	}
+
+	report, err := app.Model.PostDB.AckReport(post.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if report.RemindedAt != nil {
+		if wait := time.Until(report.RemindedAt.Add(ackReminderInterval)); wait > 0 {
+			app.errorResponse(w, r, http.StatusTooManyRequests, fmt.Sprintf("يرجى انتظار %d دقيقة قبل إرسال تذكير جديد", int(wait.Minutes())+1))
+			return
+		}
+	}
+
+	now := time.Now()
+	if err := app.Model.PostDB.MarkAckReminded(post.ID, now); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.audit(r, data.AuditRemindAck, data.AuditEntityPost, post.ID, nil, map[string]int{"reminded": len(report.Pending)})
+
+	notification := map[string]interface{}{
+		"type": "acknowledgement_reminder",
+		"post": post,
+	}
+	excerpt := postTitle(markdown.Text(post.Description))
+	for _, user := range report.Pending {
+		app.wsManager.BroadcastMessage(user.ID, notification)
+	}
+	go func(users []data.PostAckUser) {
+		for _, user := range users {
+			if err := SendAcknowledgementReminder(user.Email, user.Name, excerpt); err != nil {
+				app.log.Printf("Failed to send acknowledgement reminder to %s: %v", user.Email, err)
+			}
+		}
+	}(report.Pending)
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"reminded": len(report.Pending), "reminded_at": now})
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// visiblePost loads the post of the {id} path value, answering 404 unless the
+// request's user may read it.
+func (app *application) visiblePost(w http.ResponseWriter, r *http.Request) (*data.Post, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid post ID"))
+		return nil, false
+	}
+
+	post, err := app.Model.PostDB.GetPost(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.errorResponse(w, r, http.StatusNotFound, "Post not found")
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	visible, err := app.postVisible(r, post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	if !visible {
+		app.errorResponse(w, r, http.StatusNotFound, "Post not found")
+		return nil, false
+	}
+	return post, true
+}
+
+// postComment loads the {comment_id} comment of the post.
+func (app *application) postComment(w http.ResponseWriter, r *http.Request, post *data.Post) (*data.PostComment, bool) {
+	id, err := uuid.Parse(r.PathValue("comment_id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid comment ID"))
+		return nil, false
+	}
+
+	comment, err := app.Model.PostCommentDB.GetComment(id)
+	if err == nil && comment.PostID != post.ID {
+		err = data.ErrRecordNotFound
+	}
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.errorResponse(w, r, http.StatusNotFound, "Comment not found")
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return comment, true
+}
+
+// ListPostCommentsHandler pages through the post's comments, oldest first,
+// each with its replies.
+func (app *application) ListPostCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := app.visiblePost(w, r)
+	if !ok {
+		return
+	}
+
+	comments, meta, err := app.Model.PostCommentDB.ListComments(post.ID, r.URL.Query())
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"comments": comments, "meta": meta})
+}
+
+// CreatePostCommentHandler comments on the post, or replies to the top-level
+// comment given as parent_id. The comment is pushed to the post's subscribers,
+// its author and the author of the comment replied to.
+func (app *application) CreatePostCommentHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := app.visiblePost(w, r)
+	if !ok {
+		return
+	}
+
+	authorID := requestUserID(r)
+	comment := data.PostComment{
+		PostID:   post.ID,
+		AuthorID: &authorID,
+		Body:     strings.TrimSpace(r.FormValue("body")),
+	}
+
+	v := validator.New()
+	data.ValidatePostComment(v, &comment)
+
+	var parent *data.PostComment
+	if value := r.FormValue("parent_id"); value != "" {
+		parentID, err := uuid.Parse(value)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid parent ID"))
+			return
+		}
+		parent, err = app.Model.PostCommentDB.GetComment(parentID)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		v.Check(parent != nil && parent.PostID == post.ID, "parent_id", "التعليق المراد الرد عليه غير موجود")
+		v.Check(parent == nil || parent.ParentID == nil, "parent_id", "لا يمكن الرد على رد")
+		comment.ParentID = &parentID
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.PostCommentDB.InsertComment(&comment); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if saved, err := app.Model.PostCommentDB.GetComment(comment.ID); err == nil {
+		comment = *saved
+	}
+	app.audit(r, data.AuditCreate, data.AuditEntityComment, comment.ID, nil, comment)
+
+	var notify []uuid.UUID
+	if post.AuthorID != nil {
+		notify = append(notify, *post.AuthorID)
+	}
+	if parent != nil && parent.AuthorID != nil {
+		notify = append(notify, *parent.AuthorID)
+	}
+	app.wsManager.BroadcastToPost(post.ID, map[string]interface{}{
+		"type":    "new_comment",
+		"post_id": post.ID,
+		"comment": comment,
+	}, notify...)
+
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"comment": comment})
+}
+
+// UpdatePostCommentHandler lets authors edit their own comments.
+func (app *application) UpdatePostCommentHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := app.visiblePost(w, r)
+	if !ok {
+		return
+	}
+	comment, ok := app.postComment(w, r, post)
+	if !ok {
+		return
+	}
+	if comment.AuthorID == nil || *comment.AuthorID != requestUserID(r) {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	before := *comment
+	comment.Body = strings.TrimSpace(r.FormValue("body"))
+
+	v := validator.New()
+	data.ValidatePostComment(v, comment)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.PostCommentDB.UpdateComment(comment); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.audit(r, data.AuditUpdate, data.AuditEntityComment, comment.ID, before, comment)
+
+	app.wsManager.BroadcastToPost(post.ID, map[string]interface{}{
+		"type":    "comment_updated",
+		"post_id": post.ID,
+		"comment": comment,
+	})
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"comment": comment})
+}
+
+// DeletePostCommentHandler removes a comment with its replies. Besides the
+// comment's author, whoever manages the post may moderate its comments.
+func (app *application) DeletePostCommentHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := app.visiblePost(w, r)
+	if !ok {
+		return
+	}
+	comment, ok := app.postComment(w, r, post)
+	if !ok {
+		return
+	}
+	isAuthor := comment.AuthorID != nil && *comment.AuthorID == requestUserID(r)
+	if !isAuthor && !app.canManagePost(r, post) {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	if err := app.Model.PostCommentDB.DeleteComment(comment.ID); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	app.audit(r, data.AuditDelete, data.AuditEntityComment, comment.ID, comment, nil)
+
+	app.wsManager.BroadcastToPost(post.ID, map[string]interface{}{
+		"type":       "comment_deleted",
+		"post_id":    post.ID,
+		"comment_id": comment.ID,
+	})
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "comment deleted"})
+}
+
+func (app *application) AddPostReactionHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := app.visiblePost(w, r)
+	if !ok {
+		return
+	}
+
+	emoji := strings.TrimSpace(r.FormValue("emoji"))
+	v := validator.New()
+	v.Check(validator.In(emoji, data.PostEmojis...), "emoji", "التفاعل غير مدعوم")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.PostCommentDB.AddReaction(post.ID, requestUserID(r), emoji); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "reaction added"})
+}
+
+// RemovePostReactionHandler takes back the caller's ?emoji= reaction.
+func (app *application) RemovePostReactionHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := app.visiblePost(w, r)
+	if !ok {
+		return
+	}
+
+	emoji := strings.TrimSpace(r.URL.Query().Get("emoji"))
+	if err := app.Model.PostCommentDB.RemoveReaction(post.ID, requestUserID(r), emoji); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "reaction removed"})
+}
@@ -6,21 +6,180 @@ import (
 	"project/internal/data"
 	"project/utils"
 	"project/utils/validator"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// parsePostOptions reads the category, pinned, requires_ack, publish_at and
+// expires_at form values into post. Missing values keep whatever the post
+// already has; an empty expires_at clears it.
+func parsePostOptions(r *http.Request, post *data.Post) error {
+	if category, ok := formField(r, "category"); ok && category != "" {
+		post.Category = strings.ToLower(category)
+	}
+	if value, ok := formField(r, "pinned"); ok && value != "" {
+		pinned, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("invalid pinned value")
+		}
+		post.Pinned = pinned
+	}
+	if value, ok := formField(r, "requires_ack"); ok && value != "" {
+		requiresAck, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("invalid requires_ack value")
+		}
+		post.RequiresAck = requiresAck
+	}
+	if value, ok := formField(r, "publish_at"); ok && value != "" {
+		publishAt, err := parseTimeParam(value)
+		if err != nil {
+			return errors.New("invalid publish_at, expected YYYY-MM-DD or RFC 3339")
+		}
+		post.PublishAt = publishAt
+	}
+	if err := parsePostAudience(r, post); err != nil {
+		return err
+	}
+	if value, ok := formField(r, "expires_at"); ok {
+		if value == "" {
+			post.ExpiresAt = nil
+		} else {
+			expiresAt, err := parseTimeParam(value)
+			if err != nil {
+				return errors.New("invalid expires_at, expected YYYY-MM-DD or RFC 3339")
+			}
+			post.ExpiresAt = &expiresAt
+		}
+	}
+	return nil
+}
+
+// parsePostAudience reads the audience_roles, audience_year, audience_season
+// and audience_pre_projects form values into post. Missing values keep the
+// post's audience; empty ones clear that part of it.
+func parsePostAudience(r *http.Request, post *data.Post) error {
+	if value, ok := formField(r, "audience_roles"); ok {
+		post.AudienceRoles = nil
+		for _, role := range strings.Split(value, ",") {
+			if role = strings.ToLower(strings.TrimSpace(role)); role != "" {
+				post.AudienceRoles = append(post.AudienceRoles, role)
+			}
+		}
+	}
+	if value, ok := formField(r, "audience_year"); ok {
+		post.AudienceYear = nil
+		if value != "" {
+			year, err := strconv.Atoi(value)
+			if err != nil {
+				return errors.New("invalid audience_year")
+			}
+			post.AudienceYear = &year
+		}
+	}
+	if value, ok := formField(r, "audience_season"); ok {
+		post.AudienceSeason = nil
+		if value != "" {
+			season := strings.ToLower(value)
+			post.AudienceSeason = &season
+		}
+	}
+	if value, ok := formField(r, "audience_pre_projects"); ok {
+		post.AudiencePreProjects = nil
+		for _, idStr := range strings.Split(value, ",") {
+			if idStr = strings.TrimSpace(idStr); idStr == "" {
+				continue
+			}
+			id, err := uuid.Parse(idStr)
+			if err != nil {
+				return errors.New("invalid pre-project ID in audience_pre_projects")
+			}
+			post.AudiencePreProjects = append(post.AudiencePreProjects, id)
+		}
+	}
+	return nil
+}
+
+// validatePost runs data.ValidatePost and checks that the roles and
+// pre-projects the post is aimed at exist.
+func (app *application) validatePost(post *data.Post) (*validator.Validator, error) {
+	v := validator.New()
+	data.ValidatePost(v, post, "description")
+
+	if len(post.AudienceRoles) > 0 {
+		roles, err := app.Model.PermissionDB.ListRoles()
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, len(roles))
+		for i, role := range roles {
+			names[i] = role.Name
+		}
+		for _, role := range post.AudienceRoles {
+			v.Check(validator.In(role, names...), "audience_roles", "دور غير معروف في جمهور المنشور")
+		}
+	}
+
+	unknown, err := app.Model.PostDB.UnknownPreProjects(post.AudiencePreProjects)
+	if err != nil {
+		return nil, err
+	}
+	v.Check(len(unknown) == 0, "audience_pre_projects", "بعض المشاريع المحددة في جمهور المنشور غير موجودة")
+	return v, nil
+}
+
+// postViewer is the request's user as a post reader, or nil when anonymous.
+func postViewer(r *http.Request) *data.PostViewer {
+	userID := requestUserID(r)
+	if userID == uuid.Nil {
+		return nil
+	}
+	roles, _ := r.Context().Value(UserRoleKey).([]string)
+	return &data.PostViewer{ID: userID, Roles: roles}
+}
+
+// canManagePost reports whether the request's user may edit or delete the
+// post: its author or a moderator. Posts from before authors were recorded
+// are left to the moderators.
+func (app *application) canManagePost(r *http.Request, post *data.Post) bool {
+	if app.can(r, data.PermPostsModerate) {
+		return true
+	}
+	return post.AuthorID != nil && *post.AuthorID == requestUserID(r)
+}
+
+// postVisible reports whether the request's user may read the post.
+// Scheduled and expired posts are only shown to whoever manages them, and
+// posts with an audience only to its members.
+func (app *application) postVisible(r *http.Request, post *data.Post) (bool, error) {
+	if app.canManagePost(r, post) {
+		return true, nil
+	}
+	if !post.IsLive(time.Now()) {
+		return false, nil
+	}
+	return app.Model.PostDB.CanViewPost(post.ID, postViewer(r))
+}
+
 func (app *application) CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 	description := r.FormValue("description")
+	authorID := requestUserID(r)
 
 	post := data.Post{
 		ID:          uuid.New(),
 		Description: description,
+		AuthorID:    &authorID,
+		Category:    data.PostAnnouncement,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
+	if err := parsePostOptions(r, &post); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
 
 	if file, fileHeader, err := r.FormFile("file"); err == nil {
 		defer file.Close()
@@ -35,14 +194,17 @@ func (app *application) CreatePostHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	v := validator.New()
-	data.ValidatePost(v, &post, "description")
+	v, err := app.validatePost(&post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err := app.Model.PostDB.InsertPost(&post)
+	err = app.Model.PostDB.InsertPost(&post)
 	if err != nil {
 		if post.File != nil {
 			utils.DeleteFile(*post.File)
@@ -50,6 +212,7 @@ func (app *application) CreatePostHandler(w http.ResponseWriter, r *http.Request
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.audit(r, data.AuditCreate, data.AuditEntityPost, post.ID, nil, post)
 
 	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"post": post})
 }
@@ -71,8 +234,27 @@ func (app *application) GetPostHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	visible, err := app.postVisible(r, post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !visible {
+		app.errorResponse(w, r, http.StatusNotFound, "Post not found")
+		return
+	}
 
-	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"post": post})
+	env := utils.Envelope{"post": post}
+	if userID := requestUserID(r); post.RequiresAck && userID != uuid.Nil {
+		acknowledgedAt, err := app.Model.PostDB.AcknowledgedAt(post.ID, userID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["acknowledged_at"] = acknowledgedAt
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, env)
 }
 
 func (app *application) UpdatePostHandler(w http.ResponseWriter, r *http.Request) {
@@ -93,12 +275,23 @@ func (app *application) UpdatePostHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if !app.canManagePost(r, post) {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	before := *post
+
 	if description := r.FormValue("description"); description != "" {
 		post.Description = description
 	}
+	if err := parsePostOptions(r, post); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
 	if post.File != nil {
-		*post.File = strings.TrimPrefix(*post.File, data.Domain+"/")
-
+		file := strings.TrimPrefix(*post.File, data.Domain+"/")
+		post.File = &file
 	}
 	if file, fileHeader, err := r.FormFile("file"); err == nil {
 		defer file.Close()
@@ -114,8 +307,11 @@ func (app *application) UpdatePostHandler(w http.ResponseWriter, r *http.Request
 		post.File = &newFileName
 	}
 
-	v := validator.New()
-	data.ValidatePost(v, post, "description")
+	v, err := app.validatePost(post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -126,6 +322,7 @@ func (app *application) UpdatePostHandler(w http.ResponseWriter, r *http.Request
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.audit(r, data.AuditUpdate, data.AuditEntityPost, post.ID, before, post)
 
 	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"post": post})
 }
@@ -147,31 +344,87 @@ func (app *application) DeletePostHandler(w http.ResponseWriter, r *http.Request
 		}
 		return
 	}
-
-	err = app.Model.PostDB.DeletePost(id)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	if !app.canManagePost(r, post) {
+		app.forbiddenResponse(w, r)
 		return
 	}
 
-	if post.File != nil {
-		utils.DeleteFile(*post.File)
+	err = app.Model.PostDB.DeletePost(id, requestUserID(r))
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
 	}
+	app.audit(r, data.AuditDelete, data.AuditEntityPost, id, post, nil)
 
-	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "post deleted successfully"})
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "post moved to trash"})
 }
 
+// ListPostsHandler pages through the live posts the caller is in the
+// audience of, optionally of one ?category=. Anonymous callers only get public
+// posts and moderators get every audience. Moderators and publishers may ask
+// for ?status=scheduled, expired or all; publishers then only see their own
+// posts. Pinned posts are left out of the pages; the first page carries them
+// apart, to be shown above it.
 func (app *application) ListPostsHandler(w http.ResponseWriter, r *http.Request) {
 	queryParams := r.URL.Query()
 
-	posts, meta, err := app.Model.PostDB.ListPosts(queryParams)
+	filter := data.PostFilter{
+		Status:       queryParams.Get("status"),
+		Category:     queryParams.Get("category"),
+		Viewer:       postViewer(r),
+		AllAudiences: app.can(r, data.PermPostsModerate),
+		// On every page, not just the first, so the offsets of the later
+		// pages line up with it.
+		Unpinned: true,
+	}
+	v := validator.New()
+	v.Check(filter.Status == "" || validator.In(filter.Status, data.PostStatuses...), "status", "unknown status")
+	v.Check(filter.Category == "" || validator.In(filter.Category, data.PostCategories...), "category", "unknown category")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if filter.Status != "" && filter.Status != data.PostStatusLive {
+		switch {
+		case filter.AllAudiences:
+		case app.can(r, data.PermPostsPublish):
+			authorID := requestUserID(r)
+			filter.AuthorID = &authorID
+		default:
+			app.forbiddenResponse(w, r)
+			return
+		}
+	}
+
+	var posts []data.Post
+	var meta interface{}
+	var err error
+	var firstPage bool
+	if utils.UseCursor(queryParams) {
+		firstPage = queryParams.Get("cursor") == ""
+		posts, meta, err = app.Model.PostDB.ListPostsCursor(filter, queryParams)
+	} else {
+		page, _ := strconv.Atoi(queryParams.Get("page"))
+		firstPage = page <= 1
+		posts, meta, err = app.Model.PostDB.ListPosts(filter, queryParams)
+	}
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.handleRetrievalError(w, r, err)
 		return
 	}
 
-	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+	env := utils.Envelope{
 		"posts": posts,
 		"meta":  meta,
-	})
+	}
+	if firstPage {
+		pinned, err := app.Model.PostDB.ListPinnedPosts(filter)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["pinned"] = pinned
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, env)
 }
@@ -8,7 +8,6 @@ import (
 	"project/internal/data"
 	"project/utils"
 	"project/utils/validator"
-	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -23,21 +22,13 @@ func (app *application) CreatePreProjectHandler(w http.ResponseWriter, r *http.R
 		app.badRequestResponse(w, r, err)
 		return
 	}
-	const AdminRole = "admin"
-
-	userRoles, ok := r.Context().Value(UserRoleKey).([]string)
-	if !ok {
-		app.badRequestResponse(w, r, fmt.Errorf("invalid user roles"))
-		return
-	}
 	user, err := app.Model.UserDB.GetUser(usersID)
 	if err != nil {
 		app.handleRetrievalError(w, r, err)
 		return
 	}
-	isAdmin := slices.Contains(userRoles, AdminRole)
-
-	if !isAdmin {
+	// Only those who manage pre-projects may have more than one.
+	if !app.can(r, data.PermPreProjectsManage) {
 		existingPreProject, err := app.Model.PreProjectDB.CheckExistingPreProject(user.ID)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
@@ -128,6 +119,8 @@ func (app *application) CreatePreProjectHandler(w http.ResponseWriter, r *http.R
 		CreatedAt:       time.Now(),
 		UpdatedAt:       time.Now(),
 	}
+	parseProjectMetadata(r, &preProject.ProjectMetadata)
+	preProject.CompleteMetadata()
 	v := validator.New()
 	data.ValidatePreProject(v, &preProject, studentIDs, advisorIDs)
 	if !v.Valid() {
@@ -135,20 +128,10 @@ func (app *application) CreatePreProjectHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	skipSimilarityCheck := false
-	if userRoles, ok := r.Context().Value(UserRoleKey).([]string); ok {
-		for _, role := range userRoles {
-			if role == "admin" || role == "teacher" {
-				if r.FormValue("confirm") == "true" {
-					skipSimilarityCheck = true
-				}
-				break
-			}
-		}
-	}
+	skipSimilarityCheck := r.FormValue("confirm") == "true" && app.can(r, data.PermPreProjectsSkipSimilarity)
 
 	if !skipSimilarityCheck {
-		similarityCheckResp, err := utils.CheckProjectSimilarity(name, description)
+		similarityCheckResp, err := utils.CheckProjectSimilarity(preProject.Name, *preProject.Description)
 		if err != nil {
 			if err.Error() == "server is offline or unreachable" {
 				app.errorResponse(w, r, http.StatusServiceUnavailable, "يتم تشغيل السيرفر, يرجى المحاوله بعد قليل")
@@ -197,26 +180,24 @@ func (app *application) CreatePreProjectHandler(w http.ResponseWriter, r *http.R
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.audit(r, data.AuditCreate, data.AuditEntityPreProject, preProject.ID, nil, preProject)
 	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"pre_project": preProject})
 }
 
 func (app *application) GetPreProjectsHandler(w http.ResponseWriter, r *http.Request) {
 	queryParams := r.URL.Query()
-	id := queryParams.Get("id")
-	if id != "" {
-		filters := queryParams.Get("filters")
-		if filters == "" {
-			queryParams.Set("filters", fmt.Sprintf("id:%s", id))
-		} else {
-			queryParams.Set("filters", fmt.Sprintf("%s,id:%s", filters, id))
-		}
-	}
+	addIDFilter(queryParams)
 
 	preProjects, meta, err := app.Model.PreProjectDB.ListPreProjects(queryParams)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	if lang := negotiateLanguage(w, r); lang != "" {
+		for i := range preProjects {
+			preProjects[i].PreProject.Localize(lang)
+		}
+	}
 
 	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"pre_projects": preProjects, "meta": meta})
 }
@@ -256,24 +237,16 @@ func (app *application) GetPreProjectsHandlerByID(w http.ResponseWriter, r *http
 		app.handleRetrievalError(w, r, err)
 		return
 	}
+	if lang := negotiateLanguage(w, r); lang != "" {
+		preProject.PreProject.Localize(lang)
+	}
 
 	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
 		"pre_project": preProject,
 	})
 }
 func (app *application) UpdatePreProjectHandler(w http.ResponseWriter, r *http.Request) {
-	userRoles, ok := r.Context().Value(UserRoleKey).([]string)
-	if !ok {
-		app.unauthorizedResponse(w, r)
-		return
-	}
-	isAdmin := false
-	for _, role := range userRoles {
-		if role == "admin" {
-			isAdmin = true
-			break
-		}
-	}
+	isAdmin := app.can(r, data.PermPreProjectsManage)
 
 	preProjectID := uuid.MustParse(r.PathValue("id"))
 
@@ -284,9 +257,10 @@ func (app *application) UpdatePreProjectHandler(w http.ResponseWriter, r *http.R
 	}
 
 	preProject := &data.PreProject{
-		ID:           preProjectID,
-		ProjectOwner: existingPreProject.PreProject.ProjectOwner,
-		UpdatedAt:    time.Now(),
+		ID:              preProjectID,
+		ProjectOwner:    existingPreProject.PreProject.ProjectOwner,
+		UpdatedAt:       time.Now(),
+		ProjectMetadata: existingPreProject.PreProject.ProjectMetadata,
 	}
 	nameChanged := false
 	descriptionChanged := false
@@ -505,6 +479,9 @@ func (app *application) UpdatePreProjectHandler(w http.ResponseWriter, r *http.R
 		discutants = []uuid.UUID{}
 	}
 
+	parseProjectMetadata(r, &preProject.ProjectMetadata)
+	preProject.CompleteMetadata()
+
 	v := validator.New()
 	data.ValidatePreProject(v, preProject, students, advisors)
 	if !v.Valid() {
@@ -589,6 +566,7 @@ func (app *application) UpdatePreProjectHandler(w http.ResponseWriter, r *http.R
 		app.handleRetrievalError(w, r, err)
 		return
 	}
+	app.audit(r, data.AuditUpdate, data.AuditEntityPreProject, preProjectID, existingPreProject, updatedPreProject)
 
 	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
 		"pre_project": updatedPreProject,
@@ -596,30 +574,27 @@ func (app *application) UpdatePreProjectHandler(w http.ResponseWriter, r *http.R
 }
 
 func (app *application) DeletePreProjectHandler(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value(UserIDKey).(string)
-	studentUUID, err := uuid.Parse(userID)
+	preProjectID := uuid.MustParse(r.PathValue("id"))
+
+	preProject, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(preProjectID)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.handleRetrievalError(w, r, err)
 		return
 	}
 
-	preProjectID := uuid.MustParse(r.PathValue("id"))
-
-	err = app.Model.PreProjectDB.DeletePreProject(preProjectID, studentUUID)
+	err = app.Model.PreProjectDB.DeletePreProject(preProjectID, requestUserID(r))
 	if err != nil {
-		switch {
-		case strings.Contains(err.Error(), "pre-project not found"):
+		if errors.Is(err, data.ErrRecordNotFound) {
 			app.errorResponse(w, r, http.StatusNotFound, "Pre-project not found")
-		case strings.Contains(err.Error(), "unauthorized to delete"):
-			app.errorResponse(w, r, http.StatusForbidden, "Unauthorized to delete this pre-project")
-		default:
+		} else {
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
+	app.audit(r, data.AuditDelete, data.AuditEntityPreProject, preProjectID, preProject, nil)
 
 	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
-		"message": "Pre-project deleted successfully",
+		"message": "Pre-project moved to trash",
 	})
 }
 func (app *application) RespondToPreProjectHandler(w http.ResponseWriter, r *http.Request) {
@@ -694,6 +669,8 @@ func (app *application) RespondToPreProjectHandler(w http.ResponseWriter, r *htt
 		}
 		return
 	}
+	app.audit(r, data.AuditAdvisorResponse, data.AuditEntityPreProject, preProjectUUID,
+		utils.Envelope{"advisors": preProject.Advisors}, utils.Envelope{"advisor_id": advisorUUID, "status": status})
 	message := "Advisor response recorded successfully"
 	if status == "accepted" {
 		message = "Pre-project accepted successfully"
@@ -707,7 +684,13 @@ func (app *application) RespondToPreProjectHandler(w http.ResponseWriter, r *htt
 func (app *application) ResetPreProjectAdvisorsHandler(w http.ResponseWriter, r *http.Request) {
 
 	preProjectID := uuid.MustParse(r.PathValue("id"))
-	err := app.Model.PreProjectDB.ResetPreProjectAdvisors(preProjectID)
+	preProject, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(preProjectID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	err = app.Model.PreProjectDB.ResetPreProjectAdvisors(preProjectID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -718,6 +701,7 @@ func (app *application) ResetPreProjectAdvisorsHandler(w http.ResponseWriter, r
 		app.handleRetrievalError(w, r, err)
 		return
 	}
+	app.audit(r, data.AuditResetAdvisors, data.AuditEntityPreProject, preProjectID, preProject, updatedPreProject)
 
 	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
 		"pre_project": updatedPreProject,
@@ -758,7 +742,14 @@ func (app *application) MovePreProjectToBookHandler(w http.ResponseWriter, r *ht
 		Degree:      preProject.PreProject.Degree,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
+
+		ProjectMetadata: preProject.PreProject.ProjectMetadata,
+	}
+	if err := parseBookAccess(r, book); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
 	}
+	parseBookProgram(r, book)
 	studentIDs := make([]uuid.UUID, len(preProject.Students))
 	for i, student := range preProject.Students {
 		studentIDs[i] = student.StudentID
@@ -782,22 +773,44 @@ func (app *application) MovePreProjectToBookHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
+	// Book files are not served publicly, so the file leaves the pre-projects
+	// folder along with the project.
+	movedFile := false
+	if cleanedFilePath != "" {
+		if movedFilePath, err := utils.MoveFile(cleanedFilePath, "books"); err != nil {
+			log.Printf("Failed to move file %s to books: %v", cleanedFilePath, err)
+		} else {
+			book.File = &movedFilePath
+			movedFile = true
+		}
+	}
+
 	err = app.Model.BookDB.InsertBook(book, Discussants, advisorIDs, studentIDs)
 	if err != nil {
+		if movedFile {
+			if _, err := utils.MoveFile(*book.File, "pre_projects"); err != nil {
+				log.Printf("Failed to move file %s back to pre_projects: %v", *book.File, err)
+			}
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Must happen before the pre-project is deleted, which would cascade to them.
+	if err = app.Model.AttachmentDB.MoveAttachmentsToBook(preProjectID, book.ID); err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 	for _, student := range preProject.Students {
-		if err = app.Model.UserRoleDB.RevokeRole(student.StudentID, 4); err != nil {
+		if err = app.Model.UserRoleDB.RevokeRoleByName(student.StudentID, data.RoleGraduationStudent); err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
-		if err = app.Model.UserRoleDB.GrantRole(student.StudentID, 5); err != nil {
+		if err = app.Model.UserRoleDB.GrantRoleByName(student.StudentID, data.RoleGraduatedStudent); err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 	}
-	err = app.Model.PreProjectDB.DeletePreProject(preProjectID, preProject.PreProject.ProjectOwner)
+	err = app.Model.PreProjectDB.RemovePreProject(preProjectID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -808,6 +821,11 @@ func (app *application) MovePreProjectToBookHandler(w http.ResponseWriter, r *ht
 		app.handleRetrievalError(w, r, err)
 		return
 	}
+	app.audit(r, data.AuditTransferToBook, data.AuditEntityPreProject, preProjectID, preProject, createdBook)
+	app.audit(r, data.AuditCreate, data.AuditEntityBook, book.ID, nil, createdBook)
+	for _, student := range preProject.Students {
+		app.notifyRolesChanged(r, student.StudentID)
+	}
 
 	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{
 		"book":    createdBook,
@@ -826,11 +844,19 @@ func (app *application) CanUpdate(w http.ResponseWriter, r *http.Request) {
 
 	id := uuid.MustParse(r.PathValue("id"))
 
+	preProject, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
 	err = app.Model.PreProjectDB.UpdateCanUpdate(canUpdate, id)
 	if err != nil {
 		app.errorResponse(w, r, http.StatusBadRequest, "Error while updating the pre project canUpdate!")
 		return
 	}
+	app.audit(r, data.AuditSetCanUpdate, data.AuditEntityPreProject, id,
+		utils.Envelope{"can_update": preProject.PreProject.CanUpdate}, utils.Envelope{"can_update": canUpdate})
 
 	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
 		"message": "Pre-project successfully updated!",
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"project/internal/data"
+	"project/utils/validator"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// formField returns the trimmed form value of key and whether the form sent
+// it at all, so an empty value can clear a field while a missing one keeps it.
+func formField(r *http.Request, key string) (string, bool) {
+	value := r.FormValue(key)
+	_, ok := r.Form[key]
+	return strings.TrimSpace(value), ok
+}
+
+// parseProjectMetadata reads the title_ar, title_en, abstract_ar,
+// abstract_en, keywords and language form values into m. Missing values keep
+// whatever m already has.
+func parseProjectMetadata(r *http.Request, m *data.ProjectMetadata) {
+	texts := map[string]**string{
+		"title_ar":    &m.TitleAr,
+		"title_en":    &m.TitleEn,
+		"abstract_ar": &m.AbstractAr,
+		"abstract_en": &m.AbstractEn,
+	}
+	for key, field := range texts {
+		value, ok := formField(r, key)
+		if !ok {
+			continue
+		}
+		if value == "" {
+			*field = nil
+		} else {
+			*field = &value
+		}
+	}
+
+	if value, ok := formField(r, "keywords"); ok {
+		m.Keywords = data.ParseKeywords(value)
+	}
+	if value, ok := formField(r, "language"); ok && value != "" {
+		m.Language = strings.ToLower(value)
+	}
+}
+
+// negotiateLanguage picks the project language the client prefers by its
+// Accept-Language header, or "" when it accepts none of them.
+func negotiateLanguage(w http.ResponseWriter, r *http.Request) string {
+	w.Header().Add("Vary", "Accept-Language")
+
+	tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil {
+		return ""
+	}
+	for _, tag := range tags {
+		base, confidence := tag.Base()
+		if confidence == language.No {
+			continue
+		}
+		if lang := base.String(); validator.In(lang, data.ProjectLanguages...) {
+			w.Header().Set("Content-Language", lang)
+			return lang
+		}
+	}
+	return ""
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"net/http"
+	"project/internal/data"
+	"project/utils"
 	"time"
 
 	"github.com/go-michi/michi"
@@ -33,27 +35,73 @@ func (app *application) Router() *michi.Router {
 
 	r.Use(rateLimiter.Limit)
 
-	r.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir("/app/cmd/api/uploads"))))
+	r.Handle("/uploads/", http.StripPrefix("/uploads/", protectUploads(http.FileServer(http.Dir(utils.UploadsDir)))))
 	// r.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir("uploads"))))
 
 	r.Route("/", func(sub *michi.Router) {
 		sub.HandleFunc("GET book", http.HandlerFunc(app.ListBooksHandler))
-		sub.HandleFunc("GET book/{id}", http.HandlerFunc(app.GetBookWithDetailsHandler))
-		sub.HandleFunc("POST book", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.CreateBookHandler))))
-		sub.HandleFunc("DELETE book/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.DeleteBookHandler))))
-		sub.HandleFunc("PUT book/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.UpdateBookHandler))))
-		sub.HandleFunc("GET post", http.HandlerFunc(app.ListPostsHandler))
-		sub.HandleFunc("GET post/{id}", http.HandlerFunc(app.GetPostHandler))
-		sub.HandleFunc("POST post", app.AuthMiddleware(app.AdminOrTeacherMiddleware(http.HandlerFunc(app.CreatePostHandler))))
-		sub.HandleFunc("DELETE post/{id}", app.AuthMiddleware(app.AdminOrTeacherMiddleware(http.HandlerFunc(app.DeletePostHandler))))
-		sub.HandleFunc("PUT post/{id}", app.AuthMiddleware(app.AdminOrTeacherMiddleware(http.HandlerFunc(app.UpdatePostHandler))))
+		sub.HandleFunc("GET book/{id}", app.PassTokenMiddleware(app.GetBookWithDetailsHandler))
+		sub.HandleFunc("GET book/{id}/file", app.PassTokenMiddleware(app.DownloadBookFileHandler))
+		sub.HandleFunc("GET book/{id}/analytics", app.AuthMiddleware(app.Require(data.PermAnalyticsView, http.HandlerFunc(app.BookAnalyticsHandler))))
+		sub.HandleFunc("GET analytics/books", app.AuthMiddleware(app.Require(data.PermAnalyticsView, http.HandlerFunc(app.TopBooksHandler))))
+		sub.HandleFunc("GET book/{id}/citation", http.HandlerFunc(app.GetBookCitationHandler))
+		sub.HandleFunc("GET pid/{identifier}", http.HandlerFunc(app.ResolvePermalinkHandler))
+		sub.HandleFunc("GET citations", http.HandlerFunc(app.ListCitationsHandler))
+		sub.HandleFunc("GET feeds/books", http.HandlerFunc(app.BooksFeedHandler))
+		sub.HandleFunc("GET feeds/posts", http.HandlerFunc(app.PostsFeedHandler))
+		sub.HandleFunc("GET oai", http.HandlerFunc(app.OAIHandler))
+		sub.HandleFunc("POST oai", http.HandlerFunc(app.OAIHandler))
+		sub.HandleFunc("POST book", app.AuthMiddleware(app.Require(data.PermBooksManage, http.HandlerFunc(app.CreateBookHandler))))
+		sub.HandleFunc("DELETE book/{id}", app.AuthMiddleware(app.Require(data.PermBooksManage, http.HandlerFunc(app.DeleteBookHandler))))
+		sub.HandleFunc("PUT book/{id}", app.AuthMiddleware(app.Require(data.PermBooksManage, http.HandlerFunc(app.UpdateBookHandler))))
+		sub.HandleFunc("GET book/{id}/attachments", http.HandlerFunc(app.ListBookAttachmentsHandler))
+		sub.HandleFunc("POST book/{id}/attachments", app.AuthMiddleware(app.Require(data.PermBooksManage, http.HandlerFunc(app.CreateBookAttachmentHandler))))
+		sub.HandleFunc("PUT book/{id}/attachments/order", app.AuthMiddleware(app.Require(data.PermBooksManage, http.HandlerFunc(app.ReorderBookAttachmentsHandler))))
+		sub.HandleFunc("GET book/{id}/attachments/{attachment_id}/file", app.PassTokenMiddleware(app.DownloadBookAttachmentHandler))
+		sub.HandleFunc("DELETE book/{id}/attachments/{attachment_id}", app.AuthMiddleware(app.Require(data.PermBooksManage, http.HandlerFunc(app.DeleteBookAttachmentHandler))))
+		sub.HandleFunc("GET export/books", app.AuthMiddleware(app.Require(data.PermBooksExport, http.HandlerFunc(app.ExportBooksHandler))))
+		sub.HandleFunc("GET export/preprojects", app.AuthMiddleware(app.Require(data.PermBooksExport, http.HandlerFunc(app.ExportPreProjectsHandler))))
+		sub.HandleFunc("POST import/books", app.AuthMiddleware(app.Require(data.PermBooksManage, http.HandlerFunc(app.ImportBooksHandler))))
+		sub.HandleFunc("GET post", app.PassTokenMiddleware(app.ListPostsHandler))
+		sub.HandleFunc("GET post/{id}", app.PassTokenMiddleware(app.GetPostHandler))
+		sub.HandleFunc("POST post", app.AuthMiddleware(app.Require(data.PermPostsPublish, http.HandlerFunc(app.CreatePostHandler))))
+		sub.HandleFunc("DELETE post/{id}", app.AuthMiddleware(app.Require(data.PermPostsPublish, http.HandlerFunc(app.DeletePostHandler))))
+		sub.HandleFunc("PUT post/{id}", app.AuthMiddleware(app.Require(data.PermPostsPublish, http.HandlerFunc(app.UpdatePostHandler))))
+		sub.HandleFunc("GET post/{id}/comments", app.PassTokenMiddleware(app.ListPostCommentsHandler))
+		sub.HandleFunc("POST post/{id}/comments", app.AuthMiddleware(http.HandlerFunc(app.CreatePostCommentHandler)))
+		sub.HandleFunc("PUT post/{id}/comments/{comment_id}", app.AuthMiddleware(http.HandlerFunc(app.UpdatePostCommentHandler)))
+		sub.HandleFunc("DELETE post/{id}/comments/{comment_id}", app.AuthMiddleware(http.HandlerFunc(app.DeletePostCommentHandler)))
+		sub.HandleFunc("POST post/{id}/reactions", app.AuthMiddleware(http.HandlerFunc(app.AddPostReactionHandler)))
+		sub.HandleFunc("DELETE post/{id}/reactions", app.AuthMiddleware(http.HandlerFunc(app.RemovePostReactionHandler)))
+		sub.HandleFunc("POST post/{id}/acknowledge", app.AuthMiddleware(http.HandlerFunc(app.AcknowledgePostHandler)))
+		sub.HandleFunc("GET post/{id}/acknowledgements", app.AuthMiddleware(app.Require(data.PermPostsPublish, http.HandlerFunc(app.PostAckReportHandler))))
+		sub.HandleFunc("POST post/{id}/acknowledgements/remind", app.AuthMiddleware(app.Require(data.PermPostsPublish, http.HandlerFunc(app.RemindPostAckHandler))))
 
-		sub.HandleFunc("GET users", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.ListUsersHandler))))
+		sub.HandleFunc("GET users", app.AuthMiddleware(app.Require(data.PermUsersManage, http.HandlerFunc(app.ListUsersHandler))))
 
-		sub.HandleFunc("GET users/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.GetUserHandler))))
-		sub.HandleFunc("PUT users/{id}", app.AuthMiddleware(app.AdminOrSelfMiddleware(http.HandlerFunc(app.UpdateUserHandler))))
-		sub.HandleFunc("DELETE users/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.DeleteUserHandler))))
+		sub.HandleFunc("GET users/{id}", app.AuthMiddleware(app.Require(data.PermUsersManage, http.HandlerFunc(app.GetUserHandler))))
+		sub.HandleFunc("PUT users/{id}", app.AuthMiddleware(app.RequireOrOwner(data.PermUsersManage, ownsUser, http.HandlerFunc(app.UpdateUserHandler))))
+		sub.HandleFunc("DELETE users/{id}", app.AuthMiddleware(app.Require(data.PermUsersManage, http.HandlerFunc(app.DeleteUserHandler))))
+		sub.HandleFunc("GET users/{id}/sessions", app.AuthMiddleware(app.Require(data.PermUsersManage, http.HandlerFunc(app.ListUserSessionsHandler))))
+		sub.HandleFunc("DELETE users/{id}/sessions", app.AuthMiddleware(app.Require(data.PermUsersManage, http.HandlerFunc(app.RevokeUserSessionsHandler))))
+		sub.HandleFunc("DELETE users/{id}/2fa", app.AuthMiddleware(app.Require(data.PermUsersManage, http.HandlerFunc(app.ResetUserTwoFactorHandler))))
+		sub.HandleFunc("DELETE users/{id}/lockout", app.AuthMiddleware(app.Require(data.PermUsersManage, http.HandlerFunc(app.UnlockUserHandler))))
+		sub.HandleFunc("GET audit", app.AuthMiddleware(app.Require(data.PermAuditView, http.HandlerFunc(app.ListAuditLogHandler))))
+		sub.HandleFunc("GET trash", app.AuthMiddleware(app.Require(data.PermTrashManage, http.HandlerFunc(app.ListTrashHandler))))
+		sub.HandleFunc("POST trash/{type}/{id}/restore", app.AuthMiddleware(app.Require(data.PermTrashManage, http.HandlerFunc(app.RestoreTrashHandler))))
 		sub.HandleFunc("POST login", http.HandlerFunc((app.SigninHandler)))
+		sub.HandleFunc("POST login/2fa", app.LoginTwoFactorHandler)
+		sub.HandleFunc("POST login/2fa/setup", app.LoginTwoFactorSetupHandler)
+		sub.HandleFunc("POST token/refresh", app.RefreshTokenHandler)
+		sub.HandleFunc("POST logout", app.AuthMiddleware(http.HandlerFunc(app.SignoutHandler)))
+		sub.HandleFunc("GET me/sessions", app.AuthMiddleware(http.HandlerFunc(app.ListSessionsHandler)))
+		sub.HandleFunc("DELETE me/sessions", app.AuthMiddleware(http.HandlerFunc(app.RevokeSessionsHandler)))
+		sub.HandleFunc("DELETE me/sessions/{id}", app.AuthMiddleware(http.HandlerFunc(app.RevokeSessionHandler)))
+		sub.HandleFunc("GET me/2fa", app.AuthMiddleware(http.HandlerFunc(app.TwoFactorStatusHandler)))
+		sub.HandleFunc("POST me/2fa/setup", app.AuthMiddleware(http.HandlerFunc(app.SetupTwoFactorHandler)))
+		sub.HandleFunc("POST me/2fa/enable", app.AuthMiddleware(http.HandlerFunc(app.EnableTwoFactorHandler)))
+		sub.HandleFunc("POST me/2fa/disable", app.AuthMiddleware(http.HandlerFunc(app.DisableTwoFactorHandler)))
+		sub.HandleFunc("POST me/2fa/recovery-codes", app.AuthMiddleware(http.HandlerFunc(app.RegenerateRecoveryCodesHandler)))
 		sub.HandleFunc("POST signup", app.PassTokenMiddleware(app.SignupHandler))
 		sub.HandleFunc("POST verifyemail", app.VerifyEmailHandler)
 		sub.HandleFunc("POST resendverification", app.ResendVerificationCodeHandler)
@@ -61,35 +109,47 @@ func (app *application) Router() *michi.Router {
 		sub.HandleFunc("POST /password-reset/verify", app.VerifyPasswordResetCodeHandler)
 		sub.HandleFunc("POST /password-reset", app.ResetPasswordHandler)
 
-		sub.HandleFunc("POST roles/grant", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.GrantRoleHandler))))
-		sub.HandleFunc("DELETE roles/revoke", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.RevokeRoleHandler))))
+		sub.HandleFunc("POST roles/grant", app.AuthMiddleware(app.Require(data.PermRolesManage, http.HandlerFunc(app.GrantRoleHandler))))
+		sub.HandleFunc("DELETE roles/revoke", app.AuthMiddleware(app.Require(data.PermRolesManage, http.HandlerFunc(app.RevokeRoleHandler))))
 		sub.HandleFunc("GET roles/{id}", app.GetUserRolesHandler)
+		sub.HandleFunc("GET roles", app.AuthMiddleware(app.Require(data.PermRolesManage, http.HandlerFunc(app.ListRolesHandler))))
+		sub.HandleFunc("POST roles", app.AuthMiddleware(app.Require(data.PermRolesManage, http.HandlerFunc(app.CreateRoleHandler))))
+		sub.HandleFunc("PUT roles/{id}/permissions", app.AuthMiddleware(app.Require(data.PermRolesManage, http.HandlerFunc(app.SetRolePermissionsHandler))))
+		sub.HandleFunc("PUT roles/{id}/two-factor", app.AuthMiddleware(app.Require(data.PermRolesManage, http.HandlerFunc(app.SetRoleTwoFactorHandler))))
+		sub.HandleFunc("GET permissions", app.AuthMiddleware(app.Require(data.PermRolesManage, http.HandlerFunc(app.ListPermissionsHandler))))
 		sub.HandleFunc("GET teachers", app.GetTeachersHandler)
-		sub.HandleFunc("GET student", app.AuthMiddleware(app.AdminOrStudentMiddleware(http.HandlerFunc(app.GetStudentHandler))))
-		sub.HandleFunc("GET graduationstudents", app.AuthMiddleware(app.AdminOrStudentMiddleware(http.HandlerFunc(app.GetGraduationStudentsHandler))))
+		sub.HandleFunc("GET teachers/{id}", app.GetTeacherProfileHandler)
+		sub.HandleFunc("GET student", app.AuthMiddleware(app.Require(data.PermUsersListStudents, http.HandlerFunc(app.GetStudentHandler))))
+		sub.HandleFunc("GET graduationstudents", app.AuthMiddleware(app.Require(data.PermUsersListStudents, http.HandlerFunc(app.GetGraduationStudentsHandler))))
 		sub.HandleFunc("GET statistics", app.GetNumderOfStudents)
 
 		sub.HandleFunc("GET students", app.GetNumderOfStudents)
 
 		sub.HandleFunc("GET me", app.AuthMiddleware(http.HandlerFunc((app.MeHandler))))
-		sub.HandleFunc("POST preproject", app.AuthMiddleware(app.GradStudentOnlyMiddleware(http.HandlerFunc(app.CreatePreProjectHandler))))
+		sub.HandleFunc("GET me/permissions", app.AuthMiddleware(http.HandlerFunc(app.MyPermissionsHandler)))
+		sub.HandleFunc("POST preproject", app.AuthMiddleware(app.Require(data.PermPreProjectsCreate, http.HandlerFunc(app.CreatePreProjectHandler))))
 		sub.HandleFunc("GET preproject", http.HandlerFunc(app.GetPreProjectsHandler))
 		sub.HandleFunc("GET preproject/associated", http.HandlerFunc(app.GetAssociatedPreProjectsHandler))
 
 		sub.HandleFunc("GET preproject/{id}", http.HandlerFunc(app.GetPreProjectsHandlerByID))
-		sub.HandleFunc("PUT preproject/{id}", app.AuthMiddleware(app.AdminOrProjectOwnerOnlyMiddleware(http.HandlerFunc(app.UpdatePreProjectHandler))))
-		sub.HandleFunc("DELETE preproject/{id}", app.AuthMiddleware(app.AdminOrProjectOwnerOnlyMiddleware(http.HandlerFunc(app.DeletePreProjectHandler))))
-		sub.HandleFunc("POST transferbook/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.MovePreProjectToBookHandler))))
+		sub.HandleFunc("PUT preproject/{id}", app.AuthMiddleware(app.RequireOrOwner(data.PermPreProjectsManage, app.ownsPreProject, http.HandlerFunc(app.UpdatePreProjectHandler))))
+		sub.HandleFunc("DELETE preproject/{id}", app.AuthMiddleware(app.RequireOrOwner(data.PermPreProjectsManage, app.ownsPreProject, http.HandlerFunc(app.DeletePreProjectHandler))))
+		sub.HandleFunc("GET preproject/{id}/attachments", http.HandlerFunc(app.ListPreProjectAttachmentsHandler))
+		sub.HandleFunc("POST preproject/{id}/attachments", app.AuthMiddleware(app.RequireOrOwner(data.PermPreProjectsManage, app.ownsPreProject, http.HandlerFunc(app.CreatePreProjectAttachmentHandler))))
+		sub.HandleFunc("PUT preproject/{id}/attachments/order", app.AuthMiddleware(app.RequireOrOwner(data.PermPreProjectsManage, app.ownsPreProject, http.HandlerFunc(app.ReorderPreProjectAttachmentsHandler))))
+		sub.HandleFunc("GET preproject/{id}/attachments/{attachment_id}/file", http.HandlerFunc(app.DownloadPreProjectAttachmentHandler))
+		sub.HandleFunc("DELETE preproject/{id}/attachments/{attachment_id}", app.AuthMiddleware(app.RequireOrOwner(data.PermPreProjectsManage, app.ownsPreProject, http.HandlerFunc(app.DeletePreProjectAttachmentHandler))))
+		sub.HandleFunc("POST transferbook/{id}", app.AuthMiddleware(app.Require(data.PermPreProjectsManage, http.HandlerFunc(app.MovePreProjectToBookHandler))))
 		sub.HandleFunc("POST advisorresponse/{id}", app.AuthMiddleware(app.AdvisorsOnlyMiddleware(http.HandlerFunc(app.RespondToPreProjectHandler))))
-		sub.HandleFunc("DELETE preproject/{id}/reset-advisors", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.ResetPreProjectAdvisorsHandler))))
-		sub.HandleFunc("PUT canupdate/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.CanUpdate))))
+		sub.HandleFunc("DELETE preproject/{id}/reset-advisors", app.AuthMiddleware(app.Require(data.PermPreProjectsManage, http.HandlerFunc(app.ResetPreProjectAdvisorsHandler))))
+		sub.HandleFunc("PUT canupdate/{id}", app.AuthMiddleware(app.Require(data.PermPreProjectsManage, http.HandlerFunc(app.CanUpdate))))
 
-		sub.HandleFunc("POST chats", app.AuthMiddleware(app.ChatParticipantMiddleware(http.HandlerFunc(app.CreateChatHandler))))                                                                         // Create a new chat
-		sub.HandleFunc("DELETE chats/{chat_id}", app.AuthMiddleware(app.ChatParticipantMiddleware(http.HandlerFunc(app.DeleteChatHandler))))                                                             // Delete a specific chat message
-		sub.HandleFunc("GET conversation/{conversation_id}", app.AuthMiddleware(app.BlockConversationsForRoleGraduatedMiddleware(app.ChatParticipantMiddleware(http.HandlerFunc(app.GetChatsHandler))))) // Get conversation between two users
-		sub.HandleFunc("DELETE conversation/{id}", app.AuthMiddleware(app.ChatParticipantMiddleware(http.HandlerFunc(app.DeleteConversationHandler))))                                                   // Delete a specific conversation
-		sub.HandleFunc("GET conversations", app.AuthMiddleware(app.BlockConversationsForRoleGraduatedMiddleware(http.HandlerFunc(app.GetConversationsHandler))))                                         // Get all chats
-		sub.HandleFunc("GET ws", app.AuthMiddleware(http.HandlerFunc(app.HandleWebSocket)))                                                                                                              // WebSocket connection
+		sub.HandleFunc("POST chats", app.AuthMiddleware(app.ChatParticipantMiddleware(http.HandlerFunc(app.CreateChatHandler))))                                                               // Create a new chat
+		sub.HandleFunc("DELETE chats/{chat_id}", app.AuthMiddleware(app.ChatParticipantMiddleware(http.HandlerFunc(app.DeleteChatHandler))))                                                   // Delete a specific chat message
+		sub.HandleFunc("GET conversation/{conversation_id}", app.AuthMiddleware(app.Require(data.PermConversationsUse, app.ChatParticipantMiddleware(http.HandlerFunc(app.GetChatsHandler))))) // Get conversation between two users
+		sub.HandleFunc("DELETE conversation/{id}", app.AuthMiddleware(app.ChatParticipantMiddleware(http.HandlerFunc(app.DeleteConversationHandler))))                                         // Delete a specific conversation
+		sub.HandleFunc("GET conversations", app.AuthMiddleware(app.Require(data.PermConversationsUse, http.HandlerFunc(app.GetConversationsHandler))))                                         // Get all chats
+		sub.HandleFunc("GET ws", app.AuthMiddleware(http.HandlerFunc(app.HandleWebSocket)))                                                                                                    // WebSocket connection
 	})
 
 	return r
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// sessionPruneAge is how long expired and revoked sessions are kept, so the
+// sessions list of a compromised account can still be looked into.
+const sessionPruneAge = 30 * 24 * time.Hour
+
+// requestSessionID is the session of the request's access token, or uuid.Nil.
+func requestSessionID(r *http.Request) uuid.UUID {
+	sessionID, _ := r.Context().Value(SessionIDKey).(uuid.UUID)
+	return sessionID
+}
+
+// issueTokens returns an access token for the session, with the user's
+// current roles, and sets it and the refresh token as cookies. The envelope
+// also carries both tokens for clients that do not keep cookies.
+func (app *application) issueTokens(w http.ResponseWriter, userID, sessionID uuid.UUID, refreshToken string, refreshExpires time.Time) (utils.Envelope, error) {
+	roles, rolesVersion, err := app.Model.UserRoleDB.GetRolesVersion(userID)
+	if err != nil {
+		return nil, err
+	}
+	token, err := utils.GenerateToken(userID.String(), roles, sessionID.String(), rolesVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	utils.SetTokenCookie(w, token)
+	utils.SetRefreshTokenCookie(w, refreshToken)
+
+	return utils.Envelope{
+		"expires":            "15 minutes",
+		"token":              token,
+		"refresh_token":      refreshToken,
+		"refresh_expires_at": refreshExpires,
+		"session_id":         sessionID,
+	}, nil
+}
+
+// startSession signs the user in on the requesting device.
+func (app *application) startSession(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (utils.Envelope, error) {
+	refreshToken, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session := &data.Session{
+		UserID:    userID,
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+		ExpiresAt: time.Now().Add(utils.RefreshTokenTTL),
+	}
+	if err := app.Model.SessionDB.CreateSession(session, utils.HashToken(refreshToken)); err != nil {
+		return nil, err
+	}
+
+	return app.issueTokens(w, userID, session.ID, refreshToken, session.ExpiresAt)
+}
+
+// RefreshTokenHandler exchanges a refresh token, sent as the refresh_token
+// form value or cookie, for a new access token and a new refresh token. The
+// new access token carries the user's current roles.
+func (app *application) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	refreshToken := r.FormValue("refresh_token")
+	if refreshToken == "" {
+		if cookie, err := r.Cookie("refreshToken"); err == nil {
+			refreshToken = cookie.Value
+		}
+	}
+	if refreshToken == "" {
+		app.jwtErrorResponse(w, r, utils.ErrMissingToken)
+		return
+	}
+
+	newToken, err := utils.GenerateRefreshToken()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	session, err := app.Model.SessionDB.RotateSession(utils.HashToken(refreshToken), utils.HashToken(newToken), time.Now().Add(utils.RefreshTokenTTL))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRefreshTokenReused):
+			utils.ClearTokenCookies(w)
+			app.errorResponse(w, r, http.StatusUnauthorized, "refresh token already used, the session has been revoked")
+		case errors.Is(err, data.ErrRecordNotFound):
+			utils.ClearTokenCookies(w)
+			app.jwtErrorResponse(w, r, utils.ErrRevokedToken)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env, err := app.issueTokens(w, session.UserID, session.ID, newToken, session.ExpiresAt)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, env)
+}
+
+// SignoutHandler ends the session of the request's token.
+func (app *application) SignoutHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.Model.SessionDB.RevokeSession(requestSessionID(r), requestUserID(r))
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.ClearTokenCookies(w)
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "signed out"})
+}
+
+// ListSessionsHandler lists the caller's active sessions.
+func (app *application) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	sessions, err := app.Model.SessionDB.ListSessions(requestUserID(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	current := requestSessionID(r)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"sessions": sessions})
+}
+
+// RevokeSessionHandler ends one of the caller's sessions.
+func (app *application) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid session ID"))
+		return
+	}
+
+	userID := requestUserID(r)
+	if err := app.Model.SessionDB.RevokeSession(sessionID, userID); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	app.audit(r, data.AuditRevokeSession, data.AuditEntityUser, userID, nil, map[string]uuid.UUID{"session_id": sessionID})
+	if sessionID == requestSessionID(r) {
+		utils.ClearTokenCookies(w)
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "session revoked"})
+}
+
+// RevokeSessionsHandler ends all of the caller's sessions, or all but the
+// current one with ?keep_current=true.
+func (app *application) RevokeSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	keepCurrent, err := utils.ParseBoolOrDefault(r.URL.Query().Get("keep_current"), false)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid keep_current value"))
+		return
+	}
+
+	except := uuid.Nil
+	if keepCurrent {
+		except = requestSessionID(r)
+	}
+	userID := requestUserID(r)
+	revoked, err := app.Model.SessionDB.RevokeUserSessions(userID, except)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.audit(r, data.AuditRevokeSession, data.AuditEntityUser, userID, nil, map[string]int64{"revoked": revoked})
+	if !keepCurrent {
+		utils.ClearTokenCookies(w)
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"revoked": revoked})
+}
+
+// ListUserSessionsHandler lists the active sessions of the {id} user.
+func (app *application) ListUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid user ID"))
+		return
+	}
+
+	sessions, err := app.Model.SessionDB.ListSessions(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"sessions": sessions})
+}
+
+// RevokeUserSessionsHandler signs the {id} user out everywhere.
+func (app *application) RevokeUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid user ID"))
+		return
+	}
+
+	revoked, err := app.Model.SessionDB.RevokeUserSessions(userID, uuid.Nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.audit(r, data.AuditRevokeSession, data.AuditEntityUser, userID, nil, map[string]int64{"revoked": revoked})
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"revoked": revoked})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultProfilePageSize = 10
+	maxProfilePageSize     = 50
+)
+
+// GetTeacherProfileHandler aggregates a teacher's supervision history. Each
+// section pages on its own through ?advised_page=, ?discussed_page= and
+// ?preprojects_page=, sharing ?per_page=. ?section= returns only that section,
+// without the summary, for loading further pages.
+func (app *application) GetTeacherProfileHandler(w http.ResponseWriter, r *http.Request) {
+	teacherID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid teacher ID"))
+		return
+	}
+
+	queryParams := r.URL.Query()
+	section := queryParams.Get("section")
+	if section != "" && !validator.In(section, data.TeacherSections...) {
+		app.badRequestResponse(w, r, errors.New("section must be advised, discussed or preprojects"))
+		return
+	}
+
+	perPage, _ := strconv.Atoi(queryParams.Get("per_page"))
+	if perPage <= 0 {
+		perPage = defaultProfilePageSize
+	}
+	if perPage > maxProfilePageSize {
+		perPage = maxProfilePageSize
+	}
+	page := func(name string) int {
+		value, _ := strconv.Atoi(queryParams.Get(name + "_page"))
+		if value <= 0 {
+			return 1
+		}
+		return value
+	}
+
+	teacher, err := app.Model.UserRoleDB.GetTeacher(teacherID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	response := utils.Envelope{}
+	if section == "" {
+		stats, err := app.Model.UserRoleDB.GetTeacherStats(teacherID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		response["teacher"] = teacher
+		response["stats"] = stats
+	}
+
+	for _, name := range []string{data.TeacherSectionAdvised, data.TeacherSectionDiscussed} {
+		if section != "" && section != name {
+			continue
+		}
+		books, meta, err := app.Model.UserRoleDB.GetTeacherBooks(teacherID, name, page(name), perPage)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		response[name] = utils.Envelope{"books": books, "meta": meta}
+	}
+
+	if section == "" || section == data.TeacherSectionPreProjects {
+		name := data.TeacherSectionPreProjects
+		preProjects, meta, err := app.Model.UserRoleDB.GetTeacherPreProjects(teacherID, page(name), perPage)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		response[name] = utils.Envelope{"pre_projects": preProjects, "meta": meta}
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, response)
+}
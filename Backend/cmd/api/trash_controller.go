@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var errInvalidTrashType = errors.New("type must be book, post, user, preproject or conversation")
+
+// ListTrashHandler pages through the trashed records of ?type=, most recently
+// deleted first.
+func (app *application) ListTrashHandler(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	entityType := queryParams.Get("type")
+	if !validator.In(entityType, data.TrashTypes...) {
+		app.badRequestResponse(w, r, errInvalidTrashType)
+		return
+	}
+
+	items, meta, err := app.Model.TrashDB.ListTrash(entityType, queryParams)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"type": entityType, "items": items, "meta": meta})
+}
+
+func (app *application) RestoreTrashHandler(w http.ResponseWriter, r *http.Request) {
+	entityType := r.PathValue("type")
+	if !validator.In(entityType, data.TrashTypes...) {
+		app.badRequestResponse(w, r, errInvalidTrashType)
+		return
+	}
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid ID"))
+		return
+	}
+
+	if err := app.Model.TrashDB.Restore(entityType, id); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	app.audit(r, data.AuditRestore, entityType, id, nil, nil)
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": entityType + " restored"})
+}
+
+// purgeTrash deletes the records trashed longer than the retention period,
+// then their files.
+func (app *application) purgeTrash() error {
+	before := time.Now().AddDate(0, 0, -app.cfg.trashRetentionDays)
+	purged, files, err := app.Model.TrashDB.Purge(before)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := utils.DeleteFile(file); err != nil {
+			app.log.Printf("Failed to delete file %s: %v", file, err)
+		}
+	}
+	if purged > 0 {
+		app.infoLog.Printf("Purged %d trashed records and %d files", purged, len(files))
+	}
+	return nil
+}
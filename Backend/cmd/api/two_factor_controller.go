@@ -0,0 +1,466 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/totp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// loginChallengeTTL is how long a sign-in waits for the second factor
+	// after the password was accepted.
+	loginChallengeTTL = 5 * time.Minute
+	recoveryCodeCount = 10
+	twoFactorIssuer   = "Project Archive"
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateRecoveryCodes returns new recovery codes, formatted for the user,
+// and the hashes they are stored as.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes the code as typed, ignoring case and separators.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return utils.HashToken(code)
+}
+
+// enrolled returns the user's confirmed enrollment, or nil.
+func (app *application) enrolled(userID uuid.UUID) (*data.TwoFactor, error) {
+	tf, err := app.Model.TwoFactorDB.GetTwoFactor(userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if tf.EnabledAt == nil {
+		return nil, nil
+	}
+	return tf, nil
+}
+
+// checkTwoFactorCode reports whether the code is the user's current one and
+// was not used before.
+func (app *application) checkTwoFactorCode(tf *data.TwoFactor, code string) (bool, error) {
+	step, ok := totp.Validate(tf.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return app.Model.TwoFactorDB.UseStep(tf.UserID, step)
+}
+
+// signIn completes a sign-in whose password was accepted. Users with a second
+// factor, or whose roles require one, get a challenge to complete with
+// POST login/2fa instead of a session.
+func (app *application) signIn(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (utils.Envelope, error) {
+	tf, err := app.enrolled(userID)
+	if err != nil {
+		return nil, err
+	}
+	required, err := app.Model.TwoFactorDB.RequiresTwoFactor(userID)
+	if err != nil {
+		return nil, err
+	}
+	if tf == nil && !required {
+		return app.startSession(w, r, userID)
+	}
+
+	challenge, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(loginChallengeTTL)
+	if err := app.Model.TwoFactorDB.CreateChallenge(userID, utils.HashToken(challenge), expiresAt); err != nil {
+		return nil, err
+	}
+	return utils.Envelope{
+		"two_factor_required":  true,
+		"setup_required":       tf == nil,
+		"challenge":            challenge,
+		"challenge_expires_at": expiresAt,
+	}, nil
+}
+
+// loginChallenge loads the challenge given as the challenge form value.
+func (app *application) loginChallenge(w http.ResponseWriter, r *http.Request) (*data.LoginChallenge, string, bool) {
+	hash := utils.HashToken(r.FormValue("challenge"))
+	challenge, err := app.Model.TwoFactorDB.GetChallenge(hash)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.errorResponse(w, r, http.StatusUnauthorized, "انتهت صلاحية محاولة تسجيل الدخول، يرجى إدخال كلمة المرور مرة أخرى")
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, "", false
+	}
+	return challenge, hash, true
+}
+
+// startEnrollment gives the user a new secret to add to an authenticator app.
+func (app *application) startEnrollment(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	user, err := app.Model.UserDB.GetUser(userID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err := app.Model.TwoFactorDB.StartEnrollment(userID, secret); err != nil {
+		if errors.Is(err, data.ErrTwoFactorEnabled) {
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"secret":           secret,
+		"provisioning_uri": totp.ProvisioningURI(secret, twoFactorIssuer, user.Email),
+	})
+}
+
+// confirmEnrollment enables the user's pending enrollment when the code
+// matches it, and returns the recovery codes. ok is false once a response
+// was written.
+func (app *application) confirmEnrollment(w http.ResponseWriter, r *http.Request, userID uuid.UUID, code string) ([]string, bool, bool) {
+	tf, err := app.Model.TwoFactorDB.GetTwoFactor(userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.errorResponse(w, r, http.StatusBadRequest, "يجب بدء إعداد المصادقة الثنائية أولاً")
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false, false
+	}
+	if tf.EnabledAt != nil {
+		app.errorResponse(w, r, http.StatusConflict, data.ErrTwoFactorEnabled.Error())
+		return nil, false, false
+	}
+
+	step, ok := totp.Validate(tf.Secret, code, time.Now())
+	if !ok {
+		return nil, false, true
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false, false
+	}
+	if err := app.Model.TwoFactorDB.Enable(userID, step, hashes); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false, false
+	}
+	app.auditAs(r, userID, data.AuditEnableTwoFactor, data.AuditEntityUser, userID, nil, nil)
+	return codes, true, true
+}
+
+// LoginTwoFactorSetupHandler starts enrollment for a sign-in whose roles
+// require a second factor the user does not have yet.
+func (app *application) LoginTwoFactorSetupHandler(w http.ResponseWriter, r *http.Request) {
+	challenge, _, ok := app.loginChallenge(w, r)
+	if !ok {
+		return
+	}
+	app.startEnrollment(w, r, challenge.UserID)
+}
+
+// LoginTwoFactorHandler is the second sign-in step. It takes the challenge
+// with a code from the authenticator app or a recovery_code. For a user
+// enrolling during sign-in, the code confirms the enrollment and the
+// response carries the recovery codes.
+func (app *application) LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	challenge, hash, ok := app.loginChallenge(w, r)
+	if !ok {
+		return
+	}
+	userID := challenge.UserID
+	code := r.FormValue("code")
+	recoveryCode := r.FormValue("recovery_code")
+	if app.throttled(w, r, []throttleKey{twoFactorKey(userID)}) {
+		return
+	}
+
+	tf, err := app.enrolled(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var recoveryCodes []string
+	var accepted bool
+	switch {
+	case tf == nil:
+		recoveryCodes, accepted, ok = app.confirmEnrollment(w, r, userID, code)
+		if !ok {
+			return
+		}
+	case recoveryCode != "":
+		accepted, err = app.Model.TwoFactorDB.UseRecoveryCode(userID, hashRecoveryCode(recoveryCode))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if accepted {
+			app.auditAs(r, userID, data.AuditUseRecoveryCode, data.AuditEntityUser, userID, nil, nil)
+		}
+	default:
+		accepted, err = app.checkTwoFactorCode(tf, code)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if !accepted {
+		app.recordFailure(r, userID, []throttleKey{twoFactorKey(userID)})
+		left, err := app.Model.TwoFactorDB.FailChallenge(hash)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.errorResponse(w, r, http.StatusUnauthorized, utils.Envelope{
+			"message":       "رمز التحقق غير صحيح",
+			"attempts_left": left,
+		})
+		return
+	}
+
+	if err := app.Model.TwoFactorDB.DeleteChallenge(hash); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.resetFailures(r, twoFactorKey(userID))
+	env, err := app.startSession(w, r, userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if recoveryCodes != nil {
+		env["recovery_codes"] = recoveryCodes
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, env)
+}
+
+// TwoFactorStatusHandler tells callers whether they have a second factor,
+// whether their roles require one and how many recovery codes they have left.
+func (app *application) TwoFactorStatusHandler(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+	tf, err := app.enrolled(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	required, err := app.Model.TwoFactorDB.RequiresTwoFactor(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	left, err := app.Model.TwoFactorDB.CountRecoveryCodes(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"enabled":             tf != nil,
+		"required":            required,
+		"recovery_codes_left": left,
+	})
+}
+
+// SetupTwoFactorHandler starts the caller's enrollment. It is confirmed with
+// EnableTwoFactorHandler.
+func (app *application) SetupTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	app.startEnrollment(w, r, requestUserID(r))
+}
+
+// EnableTwoFactorHandler confirms the caller's enrollment with a code from
+// the authenticator app and returns the recovery codes, which are not shown
+// again.
+func (app *application) EnableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	codes, accepted, ok := app.confirmEnrollment(w, r, requestUserID(r), r.FormValue("code"))
+	if !ok {
+		return
+	}
+	if !accepted {
+		app.errorResponse(w, r, http.StatusBadRequest, "رمز التحقق غير صحيح")
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"recovery_codes": codes})
+}
+
+// verifyPasswordAndCode checks the password and code form values against the
+// caller, for changes to their second factor.
+func (app *application) verifyPasswordAndCode(w http.ResponseWriter, r *http.Request) bool {
+	userID := requestUserID(r)
+	user, err := app.Model.UserDB.GetUser(userID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return false
+	}
+	if !utils.CheckPassword(user.Password, r.FormValue("password")) {
+		app.errorResponse(w, r, http.StatusUnauthorized, "كلمة المرور غير صحيحة")
+		return false
+	}
+
+	tf, err := app.enrolled(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if tf == nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "المصادقة الثنائية غير مفعلة")
+		return false
+	}
+	accepted, err := app.checkTwoFactorCode(tf, r.FormValue("code"))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !accepted {
+		app.errorResponse(w, r, http.StatusUnauthorized, "رمز التحقق غير صحيح")
+		return false
+	}
+	return true
+}
+
+// DisableTwoFactorHandler removes the caller's second factor, given their
+// password and a current code, unless one of their roles requires it.
+func (app *application) DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+	required, err := app.Model.TwoFactorDB.RequiresTwoFactor(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if required {
+		app.errorResponse(w, r, http.StatusForbidden, "المصادقة الثنائية إلزامية لدورك")
+		return
+	}
+	if !app.verifyPasswordAndCode(w, r) {
+		return
+	}
+
+	if err := app.Model.TwoFactorDB.Disable(userID); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	app.audit(r, data.AuditDisableTwoFactor, data.AuditEntityUser, userID, nil, nil)
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodesHandler replaces the caller's recovery codes, given
+// their password and a current code.
+func (app *application) RegenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.verifyPasswordAndCode(w, r) {
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	userID := requestUserID(r)
+	if err := app.Model.TwoFactorDB.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.audit(r, data.AuditResetRecoveryCodes, data.AuditEntityUser, userID, nil, nil)
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"recovery_codes": codes})
+}
+
+// ResetUserTwoFactorHandler removes the second factor of the {id} user, who
+// lost their authenticator and recovery codes, and signs them out. If their
+// roles require a second factor they enroll again on their next sign-in.
+func (app *application) ResetUserTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid user ID"))
+		return
+	}
+
+	if err := app.Model.TwoFactorDB.Disable(userID); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if _, err := app.Model.SessionDB.RevokeUserSessions(userID, uuid.Nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.audit(r, data.AuditDisableTwoFactor, data.AuditEntityUser, userID, nil, nil)
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "two-factor authentication reset"})
+}
+
+// SetRoleTwoFactorHandler sets whether holders of the {id} role must sign in
+// with a second factor. Turning it on signs out the holders without one, so
+// they enroll on their next sign-in.
+func (app *application) SetRoleTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	roleID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid role ID"))
+		return
+	}
+	required, err := strconv.ParseBool(r.FormValue("required"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid required value"))
+		return
+	}
+
+	before, err := app.Model.PermissionDB.GetRole(roleID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if err := app.Model.PermissionDB.SetRoleTwoFactor(roleID, required); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	after := *before
+	after.RequireTwoFactor = required
+	app.audit(r, data.AuditUpdate, data.AuditEntityRole, uuid.Nil, before, after)
+
+	var revoked int64
+	if required && !before.RequireTwoFactor {
+		revoked, err = app.Model.TwoFactorDB.RevokeUnenrolledSessions(roleID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"role": after, "revoked_sessions": revoked})
+}
@@ -24,10 +24,21 @@ func (app *application) SigninHandler(w http.ResponseWriter, r *http.Request) {
 		app.errorResponse(w, r, http.StatusBadRequest, "Email and password must be provided")
 		return
 	}
+	keys := signinKeys(r, email)
+	if app.throttled(w, r, keys) {
+		return
+	}
 
 	user, err := app.Model.UserDB.GetUserByEmail(email)
 	if err != nil {
-		app.handleRetrievalError(w, r, err)
+		// Unknown emails count too and get the wrong password's answer, so
+		// neither the response nor a lockout tells them apart.
+		if errors.Is(err, data.ErrUserNotFound) {
+			app.recordFailure(r, uuid.Nil, keys)
+			app.errorResponse(w, r, http.StatusNotFound, data.ErrRecordNotFound.Error())
+			return
+		}
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
@@ -58,25 +69,19 @@ func (app *application) SigninHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !utils.CheckPassword(user.Password, password) {
+		app.recordFailure(r, user.ID, keys)
 		app.errorResponse(w, r, http.StatusNotFound, data.ErrRecordNotFound.Error())
 		return
 	}
-	userroles, err := app.Model.UserRoleDB.GetUserRoles(user.ID)
-	if err != nil {
-		app.handleRetrievalError(w, r, err)
-	}
-	token, err := utils.GenerateToken(user.ID.String(), userroles)
+	// The address keeps its count, or one good password would clear a spray.
+	app.resetFailures(r, accountKey(email))
+	env, err := app.signIn(w, r, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	utils.SetTokenCookie(w, token)
-
-	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
-		"expires": "24 hours",
-		"token":   token,
-	})
+	utils.SendJSONResponse(w, http.StatusOK, env)
 }
 func (app *application) GetUserHandler(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
@@ -112,15 +117,8 @@ func (app *application) UpdateUserHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Check if the requester is an admin
-	userRoles, _ := r.Context().Value(UserRoleKey).([]string)
-	isAdmin := false
-	for _, role := range userRoles {
-		if role == "admin" {
-			isAdmin = true
-			break
-		}
-	}
+	// Check if the requester manages users
+	isAdmin := app.can(r, data.PermUsersManage)
 
 	// Update user fields
 	if name := r.FormValue("name"); name != "" {
@@ -130,8 +128,10 @@ func (app *application) UpdateUserHandler(w http.ResponseWriter, r *http.Request
 		user.Email = email
 	}
 
+	before := *user
 	if user.Image != nil {
-		*user.Image = strings.TrimPrefix(*user.Image, data.Domain+"/")
+		image := strings.TrimPrefix(*user.Image, data.Domain+"/")
+		user.Image = &image
 	}
 	if file, fileHeader, err := r.FormFile("image"); err == nil {
 		defer file.Close()
@@ -166,12 +166,42 @@ func (app *application) UpdateUserHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// research_interests is comma separated; sending it empty clears the list.
+	var interests []string
+	_, setInterests := r.Form["research_interests"]
+	if setInterests {
+		interests = []string{}
+		seen := make(map[string]bool)
+		for _, interest := range strings.Split(r.FormValue("research_interests"), ",") {
+			interest = strings.TrimSpace(interest)
+			if interest == "" || seen[strings.ToLower(interest)] {
+				continue
+			}
+			seen[strings.ToLower(interest)] = true
+			interests = append(interests, interest)
+		}
+		data.ValidateResearchInterests(v, interests)
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
 	err = app.Model.UserDB.UpdateUser(user)
 	if err != nil {
 		app.handleRetrievalError(w, r, err)
 		return
 	}
 
+	if setInterests {
+		if err := app.Model.UserDB.SetResearchInterests(user.ID, interests); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		user.ResearchInterests = interests
+	}
+	app.audit(r, data.AuditUpdate, data.AuditEntityUser, user.ID, before, user)
+
 	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"user": user})
 }
 func (app *application) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -188,21 +218,34 @@ func (app *application) DeleteUserHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.Model.UserDB.DeleteUser(id)
+	err = app.Model.UserDB.DeleteUser(id, requestUserID(r))
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.handleRetrievalError(w, r, err)
 		return
 	}
-
-	if user.Image != nil {
-		utils.DeleteFile(*user.Image)
+	app.audit(r, data.AuditDelete, data.AuditEntityUser, id, user, nil)
+	if _, err := app.Model.SessionDB.RevokeUserSessions(id, uuid.Nil); err != nil {
+		app.logError(r, err)
 	}
 
-	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "user deleted successfully"})
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "user moved to trash"})
 }
 func (app *application) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
 	queryParams := r.URL.Query()
 
+	if utils.UseCursor(queryParams) {
+		users, meta, err := app.Model.UserDB.ListUsersCursor(queryParams)
+		if err != nil {
+			app.handleRetrievalError(w, r, err)
+			return
+		}
+		utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+			"users": users,
+			"meta":  meta,
+		})
+		return
+	}
+
 	users, meta, err := app.Model.UserDB.ListUsers(queryParams)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -215,15 +258,7 @@ func (app *application) ListUsersHandler(w http.ResponseWriter, r *http.Request)
 	})
 }
 func (app *application) SignupHandler(w http.ResponseWriter, r *http.Request) {
-	userRoles, _ := r.Context().Value(UserRoleKey).([]string)
-
-	isAdmin := false
-	for _, role := range userRoles {
-		if role == "admin" {
-			isAdmin = true
-			break
-		}
-	}
+	isAdmin := app.can(r, data.PermUsersManage)
 
 	v := validator.New()
 	user := &data.User{
@@ -255,22 +290,24 @@ func (app *application) SignupHandler(w http.ResponseWriter, r *http.Request) {
 		user.Image = &imageName
 	}
 
-	var role int
-	roleStr := r.FormValue("role")
-
-	if !isAdmin {
-		role = 3
-	} else {
-		if roleStr == "" {
-			role = 3
-		} else {
-			var err error
-			role, err = strconv.Atoi(roleStr)
-			if err != nil {
+	// Users sign up as students; whoever manages users may pick the role by ID.
+	role := data.RoleStudent
+	if roleStr := r.FormValue("role"); isAdmin && roleStr != "" {
+		roleID, err := strconv.Atoi(roleStr)
+		if err != nil {
+			app.errorResponse(w, r, http.StatusBadRequest, "invalid role")
+			return
+		}
+		granted, err := app.Model.PermissionDB.GetRole(roleID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
 				app.errorResponse(w, r, http.StatusBadRequest, "invalid role")
-				return
+			} else {
+				app.serverErrorResponse(w, r, err)
 			}
+			return
 		}
+		role = granted.Name
 	}
 
 	// Generate a verification code
@@ -284,6 +321,14 @@ func (app *application) SignupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Imported people already have an account, which they claim by resetting
+	// its password.
+	if existing, err := app.Model.UserDB.GetUserByEmail(user.Email); err == nil && existing.Placeholder {
+		v.AddError("email", "هذا البريد مرتبط بحساب مستورد من الأرشيف، يرجى استخدام استعادة كلمة المرور لتفعيله")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	// Store the user in the database
 	if err := app.Model.UserDB.InsertUser(user); err != nil {
 		app.handleRetrievalError(w, r, err)
@@ -300,10 +345,11 @@ func (app *application) SignupHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	err = app.Model.UserRoleDB.GrantRole(user.ID, role)
+	err = app.Model.UserRoleDB.GrantRoleByName(user.ID, role)
 	if err != nil {
 		app.handleRetrievalError(w, r, err)
 	}
+	app.audit(r, data.AuditCreate, data.AuditEntityUser, user.ID, nil, utils.Envelope{"user": user, "role": role})
 
 	// Respond to the client
 	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{
@@ -327,12 +373,12 @@ func (app *application) MeHandler(w http.ResponseWriter, r *http.Request) {
 
 func (app *application) GetNumderOfStudents(w http.ResponseWriter, r *http.Request) {
 	// Count graduation students
-	count, err := app.Model.UserRoleDB.CountUsersWithRole(3)
+	count, err := app.Model.UserRoleDB.CountUsersWithRole(data.RoleStudent)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	CountGraduationStudents, err := app.Model.UserRoleDB.CountGraduationStudents(4)
+	CountGraduationStudents, err := app.Model.UserRoleDB.CountGraduationStudents(data.RoleGraduationStudent)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -342,7 +388,7 @@ func (app *application) GetNumderOfStudents(w http.ResponseWriter, r *http.Reque
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	CountGraduatedStudents, err := app.Model.UserRoleDB.CountGraduationStudents(5)
+	CountGraduatedStudents, err := app.Model.UserRoleDB.CountGraduationStudents(data.RoleGraduatedStudent)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -390,22 +436,21 @@ func (app *application) VerifyEmailHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	token, err := utils.GenerateToken(user.ID.String(), user.Roles)
+	err = app.Model.UserDB.VerifyUser(user.ID, verificationCode)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.verificationCodeError(w, r, err)
 		return
 	}
+	app.auditAs(r, user.ID, data.AuditVerifyEmail, data.AuditEntityUser, user.ID, nil, nil)
 
-	err = app.Model.UserDB.VerifyUser(user.ID, verificationCode)
+	env, err := app.signIn(w, r, user.ID)
 	if err != nil {
-		app.errorResponse(w, r, http.StatusForbidden, err.Error())
+		app.serverErrorResponse(w, r, err)
 		return
 	}
+	env["message"] = "Email verified"
 
-	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
-		"message": "Email verified",
-		"token":   token,
-	})
+	utils.SendJSONResponse(w, http.StatusOK, env)
 }
 func (app *application) ResendVerificationCodeHandler(w http.ResponseWriter, r *http.Request) {
 	email := r.FormValue("email")
@@ -533,13 +578,8 @@ func (app *application) VerifyPasswordResetCodeHandler(w http.ResponseWriter, r
 	}
 
 	// Check if the verification code matches and hasn't expired
-	if user.VerificationCode != verificationCode {
-		app.errorResponse(w, r, http.StatusForbidden, "رمز التحقق غير صالح")
-		return
-	}
-
-	if time.Now().After(user.VerificationCodeExpiry) {
-		app.errorResponse(w, r, http.StatusForbidden, "رمز التحقق منتهي الصلاحية")
+	if err := app.Model.UserDB.CheckVerificationCode(user.ID, verificationCode); err != nil {
+		app.verificationCodeError(w, r, err)
 		return
 	}
 
@@ -568,13 +608,8 @@ func (app *application) ResetPasswordHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 	// Check if the verification code matches and hasn't expired
-	if user.VerificationCode != verificationCode {
-		app.errorResponse(w, r, http.StatusForbidden, "رمز التحقق غير صالح")
-		return
-	}
-
-	if time.Now().After(user.VerificationCodeExpiry) {
-		app.errorResponse(w, r, http.StatusForbidden, "رمز التحقق منتهي الصلاحية")
+	if err := app.Model.UserDB.CheckVerificationCode(user.ID, verificationCode); err != nil {
+		app.verificationCodeError(w, r, err)
 		return
 	}
 
@@ -585,16 +620,25 @@ func (app *application) ResetPasswordHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// Update user's password
+	// Update user's password. The reset code proves the email, which claims
+	// an imported account.
 	user.Password = hashedPassword
 	user.VerificationCode = ""
 	user.VerificationCodeExpiry = time.Time{}
+	user.Verified = true
+	user.Placeholder = false
 
 	// Save the updated user in the database
 	if err := app.Model.UserDB.UpdateUser(user); err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.auditAs(r, user.ID, data.AuditResetPassword, data.AuditEntityUser, user.ID, nil, nil)
+
+	// Whoever knew the old password is signed out.
+	if _, err := app.Model.SessionDB.RevokeUserSessions(user.ID, uuid.Nil); err != nil {
+		app.logError(r, err)
+	}
 
 	// Respond to the client
 	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
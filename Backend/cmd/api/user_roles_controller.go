@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"project/internal/data"
 	"project/utils"
 	"strconv"
 
@@ -25,7 +26,13 @@ func (app *application) GrantRoleHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Check if the user currently has role 3
+	granted, err := app.Model.PermissionDB.GetRole(roleID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	// Check if the user currently has the student role
 	roles, err := app.Model.UserRoleDB.GetUserRoles(userID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -34,15 +41,15 @@ func (app *application) GrantRoleHandler(w http.ResponseWriter, r *http.Request)
 
 	hasRoleStudent := false
 	for _, role := range roles {
-		if role == "student" {
+		if role == data.RoleStudent {
 			hasRoleStudent = true
 			break
 		}
 	}
 
 	// If the user has role 'student' and is trying to grant role 'admin' or 'teacher', revoke role 'student'
-	if hasRoleStudent && (roleID == 1 || roleID == 2) {
-		err = app.Model.UserRoleDB.RevokeRole(userID, 3)
+	if hasRoleStudent && (granted.Name == data.RoleAdmin || granted.Name == data.RoleTeacher) {
+		err = app.Model.UserRoleDB.RevokeRoleByName(userID, data.RoleStudent)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -55,25 +62,31 @@ func (app *application) GrantRoleHandler(w http.ResponseWriter, r *http.Request)
 		app.handleRetrievalError(w, r, err)
 		return
 	}
+	// Like turning the requirement on for a role, so the grantee enrolls on
+	// their next sign-in instead of refreshing past it.
+	if granted.RequireTwoFactor {
+		if _, err := app.Model.TwoFactorDB.RevokeUnenrolledUserSessions(userID); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	app.auditRoles(r, data.AuditGrantRole, userID, roles)
+	app.notifyRolesChanged(r, userID)
 
 	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "role granted successfully"})
 }
 
-//if u want the granting by email :D
-/*
-
-func (app *application) GrantRoleHandler(w http.ResponseWriter, r *http.Request) {
-	email := r.FormValue("email")
+// RevokeRoleHandler revokes a specific role from a user
+func (app *application) RevokeRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userIDStr := r.FormValue("user_id")
 	roleIDStr := r.FormValue("role_id")
 
-	user, err := app.Model.UserDB.GetUserByEmail(email)
+	userID, err := uuid.Parse(userIDStr)
 	if err != nil {
-		app.errorResponse(w, r, http.StatusBadRequest, "Invalid student email")
+		app.badRequestResponse(w, r, errors.New("invalid user ID"))
 		return
 	}
 
-	userID := user.ID
-
 	roleID, err := strconv.Atoi(roleIDStr)
 	if err != nil {
 		app.badRequestResponse(w, r, errors.New("invalid role ID"))
@@ -86,56 +99,39 @@ func (app *application) GrantRoleHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	hasRoleStudent := false
-	for _, role := range roles {
-		if role == "student" {
-			hasRoleStudent = true
-			break
-		}
-	}
-
-	// If the user has role 'student' and is trying to grant role 'admin' or 'teacher', revoke role 'student'
-	if hasRoleStudent && (roleID == 1 || roleID == 2) {
-		err = app.Model.UserRoleDB.RevokeRole(userID, 3)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
-		}
-	}
-
-	err = app.Model.UserRoleDB.GrantRole(userID, roleID)
+	err = app.Model.UserRoleDB.RevokeRole(userID, roleID)
 	if err != nil {
-		app.handleRetrievalError(w, r, err)
+		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.auditRoles(r, data.AuditRevokeRole, userID, roles)
+	app.notifyRolesChanged(r, userID)
 
-	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "role granted successfully"})
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "role revoked successfully"})
 }
-*/
-// RevokeRoleHandler revokes a specific role from a user
-func (app *application) RevokeRoleHandler(w http.ResponseWriter, r *http.Request) {
-	userIDStr := r.FormValue("user_id")
-	roleIDStr := r.FormValue("role_id")
-
-	userID, err := uuid.Parse(userIDStr)
-	if err != nil {
-		app.badRequestResponse(w, r, errors.New("invalid user ID"))
-		return
-	}
 
-	roleID, err := strconv.Atoi(roleIDStr)
+// auditRoles records a role change as the user's roles before and after it.
+func (app *application) auditRoles(r *http.Request, action string, userID uuid.UUID, before []string) {
+	after, err := app.Model.UserRoleDB.GetUserRoles(userID)
 	if err != nil {
-		app.badRequestResponse(w, r, errors.New("invalid role ID"))
-		return
+		app.logError(r, err)
 	}
+	app.audit(r, action, data.AuditEntityUser, userID, utils.Envelope{"roles": before}, utils.Envelope{"roles": after})
+}
 
-	err = app.Model.UserRoleDB.RevokeRole(userID, roleID)
+// notifyRolesChanged tells the user's connected client that their roles
+// changed, so it can refresh its tokens and what it shows. Requests already
+// use the new roles.
+func (app *application) notifyRolesChanged(r *http.Request, userID uuid.UUID) {
+	roles, err := app.Model.UserRoleDB.GetUserRoles(userID)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.logError(r, err)
 		return
 	}
-
-	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "role revoked successfully"})
+	app.wsManager.BroadcastMessage(userID, map[string]interface{}{
+		"type":  "roles_changed",
+		"roles": roles,
+	})
 }
 
 // GetUserRolesHandler retrieves all roles assigned to a user
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
+	"project/internal/data"
 	"project/utils"
 	"sync"
 
@@ -20,11 +22,15 @@ var upgrader = websocket.Upgrader{
 type WebSocketManager struct {
 	clients      map[uuid.UUID]*websocket.Conn
 	clientsMutex sync.Mutex
+
+	// postSubscribers holds, per post, the users following its comments.
+	postSubscribers map[uuid.UUID]map[uuid.UUID]bool
 }
 
 func NewWebSocketManager() *WebSocketManager {
 	return &WebSocketManager{
-		clients: make(map[uuid.UUID]*websocket.Conn),
+		clients:         make(map[uuid.UUID]*websocket.Conn),
+		postSubscribers: make(map[uuid.UUID]map[uuid.UUID]bool),
 	}
 }
 
@@ -34,12 +40,61 @@ func (wm *WebSocketManager) AddClient(userID uuid.UUID, conn *websocket.Conn) {
 	wm.clients[userID] = conn
 }
 
-func (wm *WebSocketManager) RemoveClient(userID uuid.UUID) {
+// RemoveClient closes the user's conn. The user and their post subscriptions
+// are forgotten unless another socket has replaced conn, so an older socket
+// closing leaves the newer one alone.
+func (wm *WebSocketManager) RemoveClient(userID uuid.UUID, conn *websocket.Conn) {
 	wm.clientsMutex.Lock()
 	defer wm.clientsMutex.Unlock()
-	if conn, ok := wm.clients[userID]; ok {
-		conn.Close()
-		delete(wm.clients, userID)
+	conn.Close()
+	if current, ok := wm.clients[userID]; ok && current != conn {
+		return
+	}
+	delete(wm.clients, userID)
+	for postID := range wm.postSubscribers {
+		wm.unsubscribe(userID, postID)
+	}
+}
+
+// SubscribePost sends the user the comments posted on the post from now on.
+func (wm *WebSocketManager) SubscribePost(userID, postID uuid.UUID) {
+	wm.clientsMutex.Lock()
+	defer wm.clientsMutex.Unlock()
+	if wm.postSubscribers[postID] == nil {
+		wm.postSubscribers[postID] = make(map[uuid.UUID]bool)
+	}
+	wm.postSubscribers[postID][userID] = true
+}
+
+func (wm *WebSocketManager) UnsubscribePost(userID, postID uuid.UUID) {
+	wm.clientsMutex.Lock()
+	defer wm.clientsMutex.Unlock()
+	wm.unsubscribe(userID, postID)
+}
+
+// unsubscribe must be called with clientsMutex held.
+func (wm *WebSocketManager) unsubscribe(userID, postID uuid.UUID) {
+	delete(wm.postSubscribers[postID], userID)
+	if len(wm.postSubscribers[postID]) == 0 {
+		delete(wm.postSubscribers, postID)
+	}
+}
+
+// BroadcastToPost sends the message to the post's subscribers and to the
+// extra users, each once.
+func (wm *WebSocketManager) BroadcastToPost(postID uuid.UUID, message interface{}, extra ...uuid.UUID) {
+	wm.clientsMutex.Lock()
+	recipients := make(map[uuid.UUID]bool, len(wm.postSubscribers[postID])+len(extra))
+	for userID := range wm.postSubscribers[postID] {
+		recipients[userID] = true
+	}
+	wm.clientsMutex.Unlock()
+	for _, userID := range extra {
+		recipients[userID] = true
+	}
+
+	for userID := range recipients {
+		wm.BroadcastMessage(userID, message)
 	}
 }
 
@@ -77,13 +132,58 @@ func (app *application) HandleWebSocket(w http.ResponseWriter, r *http.Request)
 	}
 
 	app.wsManager.AddClient(userIDParsed, conn)
-	defer app.wsManager.RemoveClient(userIDParsed)
+	defer app.wsManager.RemoveClient(userIDParsed, conn)
 
 	for {
-		_, _, err := conn.ReadMessage()
+		_, payload, err := conn.ReadMessage()
 		if err != nil {
 			log.Printf("WebSocket user %s: %v", userIDParsed, err)
 			break
 		}
+		app.handleClientMessage(r, userIDParsed, payload)
 	}
 }
+
+// clientMessage is a request sent by the client over its socket.
+type clientMessage struct {
+	Type   string `json:"type"`
+	PostID string `json:"post_id"`
+}
+
+// handleClientMessage serves "subscribe_post" and "unsubscribe_post"; anything
+// else is ignored. Users may only follow the comments of posts they can read.
+func (app *application) handleClientMessage(r *http.Request, userID uuid.UUID, payload []byte) {
+	var msg clientMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return
+	}
+	if msg.Type != "subscribe_post" && msg.Type != "unsubscribe_post" {
+		return
+	}
+
+	postID, err := uuid.Parse(msg.PostID)
+	if err != nil {
+		app.wsManager.BroadcastMessage(userID, map[string]interface{}{"type": "error", "message": "invalid post ID"})
+		return
+	}
+	if msg.Type == "unsubscribe_post" {
+		app.wsManager.UnsubscribePost(userID, postID)
+		return
+	}
+
+	post, err := app.Model.PostDB.GetPost(postID)
+	visible := err == nil
+	if visible {
+		visible, err = app.postVisible(r, post)
+	}
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.logError(r, err)
+	}
+	if !visible {
+		app.wsManager.BroadcastMessage(userID, map[string]interface{}{"type": "error", "message": "Post not found"})
+		return
+	}
+
+	app.wsManager.SubscribePost(userID, postID)
+	app.wsManager.BroadcastMessage(userID, map[string]interface{}{"type": "subscribed_post", "post_id": postID})
+}
@@ -0,0 +1,135 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	BookEventView     = "view"
+	BookEventDownload = "download"
+)
+
+type AnalyticsDB struct {
+	db *sqlx.DB
+}
+
+// BookDayStats is one day of a book's time series.
+type BookDayStats struct {
+	Day       string `db:"day" json:"day"`
+	Views     int    `db:"views" json:"views"`
+	Downloads int    `db:"downloads" json:"downloads"`
+}
+
+// BookStats is a book with its totals over a period.
+type BookStats struct {
+	ID        uuid.UUID `db:"id" json:"id"`
+	Name      string    `db:"name" json:"name"`
+	Year      int       `db:"year" json:"year"`
+	Season    string    `db:"season" json:"season"`
+	Views     int       `db:"views" json:"views"`
+	Downloads int       `db:"downloads" json:"downloads"`
+}
+
+// VisitorKey identifies a visitor for deduplication without storing the user
+// ID or IP address itself.
+func VisitorKey(userID, ip string) string {
+	key := "ip:" + ip
+	if userID != "" {
+		key = "user:" + userID
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// RecordBookEvent counts a view or download, at most once per visitor, book
+// and day.
+func (a *AnalyticsDB) RecordBookEvent(bookID uuid.UUID, kind, visitor string) error {
+	views, downloads := 0, 0
+	if kind == BookEventView {
+		views = 1
+	} else {
+		downloads = 1
+	}
+
+	_, err := a.db.Exec(`
+		WITH event AS (
+			INSERT INTO book_events (book_id, kind, visitor, day)
+			VALUES ($1, $2, $3, CURRENT_DATE)
+			ON CONFLICT DO NOTHING
+			RETURNING book_id, day
+		)
+		INSERT INTO book_daily_stats (book_id, day, views, downloads)
+		SELECT book_id, day, $4, $5 FROM event
+		ON CONFLICT (book_id, day) DO UPDATE SET
+			views = book_daily_stats.views + EXCLUDED.views,
+			downloads = book_daily_stats.downloads + EXCLUDED.downloads`,
+		bookID, kind, visitor, views, downloads)
+	if err != nil {
+		return fmt.Errorf("failed to record book %s: %w", kind, err)
+	}
+	return nil
+}
+
+// PruneBookEvents drops deduplication rows older than the given day; the daily
+// counters are kept.
+func (a *AnalyticsDB) PruneBookEvents(before time.Time) (int64, error) {
+	result, err := a.db.Exec("DELETE FROM book_events WHERE day < $1", before.Format("2006-01-02"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune book events: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// TopBooks ranks books by views or downloads between from and to, both
+// inclusive. When advisorID is set only books they advise are ranked.
+func (a *AnalyticsDB) TopBooks(metric string, from, to time.Time, limit int, advisorID *uuid.UUID) ([]BookStats, error) {
+	order := "views DESC, downloads DESC"
+	if metric == BookEventDownload {
+		order = "downloads DESC, views DESC"
+	}
+
+	sb := QB.Select("b.id", "b.name", "b.year", "b.season",
+		"SUM(s.views) AS views", "SUM(s.downloads) AS downloads").
+		From("book_daily_stats s").
+		Join("book b ON b.id = s.book_id AND b.deleted_at IS NULL").
+		Where("s.day BETWEEN ? AND ?", from.Format("2006-01-02"), to.Format("2006-01-02")).
+		GroupBy("b.id", "b.name", "b.year", "b.season").
+		OrderBy(order, "b.id").
+		Limit(uint64(limit))
+	if advisorID != nil {
+		sb = sb.Where("EXISTS (SELECT 1 FROM book_advisors ba WHERE ba.book_id = b.id AND ba.advisor_id = ?)", *advisorID)
+	}
+
+	query, args, err := sb.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	books := []BookStats{}
+	if err := a.db.Select(&books, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to query top books: %w", err)
+	}
+	return books, nil
+}
+
+// BookTimeSeries returns one entry per day between from and to, days without
+// activity included as zeros.
+func (a *AnalyticsDB) BookTimeSeries(bookID uuid.UUID, from, to time.Time) ([]BookDayStats, error) {
+	series := []BookDayStats{}
+	err := a.db.Select(&series, `
+		SELECT to_char(d.day, 'YYYY-MM-DD') AS day, COALESCE(s.views, 0) AS views, COALESCE(s.downloads, 0) AS downloads
+		FROM generate_series($2::date, $3::date, interval '1 day') AS d(day)
+		LEFT JOIN book_daily_stats s ON s.book_id = $1 AND s.day = d.day::date
+		ORDER BY d.day`,
+		bookID, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query book time series: %w", err)
+	}
+	return series, nil
+}
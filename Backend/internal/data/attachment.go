@@ -0,0 +1,195 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"project/utils/validator"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var ErrAttachmentOrder = errors.New("يجب أن يحتوي الترتيب على جميع المرفقات مرة واحدة")
+
+// AttachmentOwner is the owning column in the attachments table.
+type AttachmentOwner string
+
+const (
+	AttachmentOwnerBook       AttachmentOwner = "book_id"
+	AttachmentOwnerPreProject AttachmentOwner = "pre_project_id"
+)
+
+// notTrashed restricts attachments to owners that are not in the trash.
+func (owner AttachmentOwner) notTrashed() string {
+	table := "pre_project"
+	if owner == AttachmentOwnerBook {
+		table = "book"
+	}
+	return fmt.Sprintf("%s IN (SELECT id FROM %s WHERE deleted_at IS NULL)", owner, table)
+}
+
+var AttachmentTypes = []string{"report", "source", "presentation", "poster", "dataset", "other"}
+
+type AttachmentDB struct {
+	db *sqlx.DB
+}
+
+type Attachment struct {
+	ID           uuid.UUID  `db:"id" json:"id"`
+	BookID       *uuid.UUID `db:"book_id" json:"book_id,omitempty"`
+	PreProjectID *uuid.UUID `db:"pre_project_id" json:"pre_project_id,omitempty"`
+	Type         string     `db:"type" json:"type"`
+	Title        string     `db:"title" json:"title"`
+	File         string     `db:"file" json:"file"`
+	OriginalName string     `db:"original_name" json:"original_name"`
+	ContentType  string     `db:"content_type" json:"content_type"`
+	Size         int64      `db:"size" json:"size"`
+	Checksum     string     `db:"checksum" json:"checksum"`
+	Position     int        `db:"position" json:"position"`
+	UploadedBy   *uuid.UUID `db:"uploaded_by" json:"uploaded_by,omitempty"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+}
+
+func ValidateAttachment(v *validator.Validator, attachment *Attachment) {
+	v.Check(validator.In(attachment.Type, AttachmentTypes...), "type", "نوع المرفق غير صالح")
+	v.Check(attachment.Title != "", "title", "عنوان المرفق مطلوب")
+	v.Check(len([]rune(attachment.Title)) <= 255, "title", "يجب أن يكون عنوان المرفق أقل من 255 حرف")
+	v.Check(attachment.Size > 0, "file", "الملف فارغ")
+}
+
+// attachmentColumns points the file at the owner's download endpoint, so book
+// attachments follow the access level of their book.
+var attachmentColumns = fmt.Sprintf(`id, book_id, pre_project_id, type, title,
+	CASE WHEN book_id IS NOT NULL
+		THEN FORMAT('%[1]s/book/%%s/attachments/%%s/file', book_id, id)
+		ELSE FORMAT('%[1]s/preproject/%%s/attachments/%%s/file', pre_project_id, id)
+	END AS file, original_name, content_type, size, checksum,
+	position, uploaded_by, created_at`, Domain)
+
+// AttachmentURL is the download URL of an attachment.
+func AttachmentURL(owner AttachmentOwner, ownerID, attachmentID uuid.UUID) string {
+	prefix := "preproject"
+	if owner == AttachmentOwnerBook {
+		prefix = "book"
+	}
+	return fmt.Sprintf("%s/%s/%s/attachments/%s/file", Domain, prefix, ownerID, attachmentID)
+}
+
+// InsertAttachment adds the attachment at the end of its owner's list.
+func (a *AttachmentDB) InsertAttachment(owner AttachmentOwner, ownerID uuid.UUID, attachment *Attachment) error {
+	query := fmt.Sprintf(`
+		INSERT INTO attachments (%[1]s, type, title, file, original_name, content_type, size, checksum, uploaded_by, position)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9,
+			(SELECT COALESCE(MAX(position) + 1, 0) FROM attachments WHERE %[1]s = $1))
+		RETURNING id, book_id, pre_project_id, position, created_at`, owner)
+
+	err := a.db.QueryRowx(query, ownerID, attachment.Type, attachment.Title, attachment.File,
+		attachment.OriginalName, attachment.ContentType, attachment.Size, attachment.Checksum,
+		attachment.UploadedBy).StructScan(attachment)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+			return ErrRecordNotFound
+		}
+		return fmt.Errorf("failed to insert attachment: %w", err)
+	}
+	return nil
+}
+
+func (a *AttachmentDB) ListAttachments(owner AttachmentOwner, ownerID uuid.UUID) ([]Attachment, error) {
+	attachments := []Attachment{}
+	query := fmt.Sprintf("SELECT %s FROM attachments WHERE %s = $1 AND %s ORDER BY position, created_at",
+		attachmentColumns, owner, owner.notTrashed())
+	if err := a.db.Select(&attachments, query, ownerID); err != nil {
+		return nil, fmt.Errorf("failed to list attachments: %w", err)
+	}
+	return attachments, nil
+}
+
+// GetAttachment returns the attachment with the stored path of its file, if it
+// belongs to the owner.
+func (a *AttachmentDB) GetAttachment(owner AttachmentOwner, ownerID, attachmentID uuid.UUID) (*Attachment, error) {
+	var attachment Attachment
+	query := fmt.Sprintf(`SELECT id, book_id, pre_project_id, type, title, file, original_name, content_type,
+		size, checksum, position, uploaded_by, created_at
+		FROM attachments WHERE id = $1 AND %s = $2 AND %s`, owner, owner.notTrashed())
+	if err := a.db.Get(&attachment, query, attachmentID, ownerID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+	return &attachment, nil
+}
+
+// DeleteAttachment removes the attachment if it belongs to the owner and
+// returns its stored file path so the caller can remove the file.
+func (a *AttachmentDB) DeleteAttachment(owner AttachmentOwner, ownerID, attachmentID uuid.UUID) (string, error) {
+	var file string
+	query := fmt.Sprintf("DELETE FROM attachments WHERE id = $1 AND %s = $2 RETURNING file", owner)
+	if err := a.db.Get(&file, query, attachmentID, ownerID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrRecordNotFound
+		}
+		return "", fmt.Errorf("failed to delete attachment: %w", err)
+	}
+	return file, nil
+}
+
+// ReorderAttachments sets the order of the owner's attachments. ids must list
+// every attachment of the owner exactly once.
+func (a *AttachmentDB) ReorderAttachments(owner AttachmentOwner, ownerID uuid.UUID, ids []uuid.UUID) error {
+	tx, err := a.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var existing []uuid.UUID
+	query := fmt.Sprintf("SELECT id FROM attachments WHERE %s = $1 FOR UPDATE", owner)
+	if err := tx.Select(&existing, query, ownerID); err != nil {
+		return fmt.Errorf("failed to lock attachments: %w", err)
+	}
+
+	if len(existing) != len(ids) {
+		return ErrAttachmentOrder
+	}
+	remaining := make(map[uuid.UUID]bool, len(existing))
+	for _, id := range existing {
+		remaining[id] = true
+	}
+	for _, id := range ids {
+		if !remaining[id] {
+			return ErrAttachmentOrder
+		}
+		delete(remaining, id)
+	}
+
+	for position, id := range ids {
+		if _, err := tx.Exec("UPDATE attachments SET position = $1 WHERE id = $2", position, id); err != nil {
+			return fmt.Errorf("failed to update attachment position: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// MoveAttachmentsToBook hands every attachment of a promoted pre-project over
+// to its book, after any attachments the book already has.
+func (a *AttachmentDB) MoveAttachmentsToBook(preProjectID, bookID uuid.UUID) error {
+	_, err := a.db.Exec(`
+		UPDATE attachments SET
+			book_id = $2,
+			pre_project_id = NULL,
+			position = position + (SELECT COALESCE(MAX(position) + 1, 0) FROM attachments WHERE book_id = $2)
+		WHERE pre_project_id = $1`, preProjectID, bookID)
+	if err != nil {
+		return fmt.Errorf("failed to move attachments: %w", err)
+	}
+	return nil
+}
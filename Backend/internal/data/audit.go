@@ -0,0 +1,169 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"project/utils"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// Audited entity types.
+const (
+	AuditEntityBook         = "book"
+	AuditEntityPost         = "post"
+	AuditEntityUser         = "user"
+	AuditEntityPreProject   = "preproject"
+	AuditEntityChat         = "chat"
+	AuditEntityConversation = "conversation"
+	AuditEntityComment      = "post_comment"
+	AuditEntityRole         = "role"
+)
+
+var AuditEntityTypes = []string{
+	AuditEntityBook, AuditEntityPost, AuditEntityUser,
+	AuditEntityPreProject, AuditEntityChat, AuditEntityConversation,
+	AuditEntityComment, AuditEntityRole,
+}
+
+// Audited actions.
+const (
+	AuditCreate             = "create"
+	AuditUpdate             = "update"
+	AuditDelete             = "delete"
+	AuditRestore            = "restore"
+	AuditImport             = "import"
+	AuditGrantRole          = "grant_role"
+	AuditRevokeRole         = "revoke_role"
+	AuditVerifyEmail        = "verify_email"
+	AuditResetPassword      = "reset_password"
+	AuditAddAttachment      = "add_attachment"
+	AuditDeleteAttachment   = "delete_attachment"
+	AuditReorderAttachments = "reorder_attachments"
+	AuditAdvisorResponse    = "advisor_response"
+	AuditResetAdvisors      = "reset_advisors"
+	AuditSetCanUpdate       = "set_can_update"
+	AuditTransferToBook     = "transfer_to_book"
+	AuditRemindAck          = "remind_ack"
+	AuditRevokeSession      = "revoke_session"
+	AuditEnableTwoFactor    = "enable_two_factor"
+	AuditDisableTwoFactor   = "disable_two_factor"
+	AuditUseRecoveryCode    = "use_recovery_code"
+	AuditResetRecoveryCodes = "reset_recovery_codes"
+	AuditLockAccount        = "lock_account"
+	AuditUnlockAccount      = "unlock_account"
+)
+
+var AuditActions = []string{
+	AuditCreate, AuditUpdate, AuditDelete, AuditRestore, AuditImport,
+	AuditGrantRole, AuditRevokeRole, AuditVerifyEmail, AuditResetPassword,
+	AuditAddAttachment, AuditDeleteAttachment, AuditReorderAttachments,
+	AuditAdvisorResponse, AuditResetAdvisors, AuditSetCanUpdate, AuditTransferToBook,
+	AuditRemindAck, AuditRevokeSession, AuditEnableTwoFactor, AuditDisableTwoFactor,
+	AuditUseRecoveryCode, AuditResetRecoveryCodes, AuditLockAccount, AuditUnlockAccount,
+}
+
+type AuditDB struct {
+	db *sqlx.DB
+}
+
+type AuditEntry struct {
+	ID         int64           `db:"id" json:"id"`
+	ActorID    *uuid.UUID      `db:"actor_id" json:"actor_id"`
+	ActorName  *string         `db:"actor_name" json:"actor_name"`
+	Action     string          `db:"action" json:"action"`
+	EntityType string          `db:"entity_type" json:"entity_type"`
+	EntityID   *uuid.UUID      `db:"entity_id" json:"entity_id"`
+	Before     json.RawMessage `db:"before" json:"before"`
+	After      json.RawMessage `db:"after" json:"after"`
+	IP         string          `db:"ip" json:"ip"`
+	UserAgent  string          `db:"user_agent" json:"user_agent"`
+	Method     string          `db:"method" json:"method"`
+	Path       string          `db:"path" json:"path"`
+	CreatedAt  time.Time       `db:"created_at" json:"created_at"`
+}
+
+// AuditFilter narrows the audit log. Values are validated by the caller.
+type AuditFilter struct {
+	ActorID    *uuid.UUID
+	EntityType string
+	EntityID   *uuid.UUID
+	Action     string
+	From       *time.Time
+	To         *time.Time
+}
+
+// jsonbParam passes a JSON document to a JSONB column. lib/pq would send a
+// []byte as bytea, so it goes as text, or NULL when empty.
+func jsonbParam(doc json.RawMessage) interface{} {
+	if len(doc) == 0 {
+		return nil
+	}
+	return string(doc)
+}
+
+// InsertAuditEntry appends an entry to the log.
+func (a *AuditDB) InsertAuditEntry(entry *AuditEntry) error {
+	query, args, err := QB.Insert("audit_log").
+		Columns("actor_id", "action", "entity_type", "entity_id", "before", "after", "ip", "user_agent", "method", "path").
+		Values(entry.ActorID, entry.Action, entry.EntityType, entry.EntityID,
+			jsonbParam(entry.Before), jsonbParam(entry.After),
+			entry.IP, entry.UserAgent, entry.Method, entry.Path).
+		Suffix("RETURNING id, created_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if err := a.db.QueryRowx(query, args...).Scan(&entry.ID, &entry.CreatedAt); err != nil {
+		return fmt.Errorf("failed to insert audit entry: %w", err)
+	}
+	return nil
+}
+
+// ListAuditEntries pages through the log, newest first.
+func (a *AuditDB) ListAuditEntries(f AuditFilter, queryParams url.Values) ([]AuditEntry, *utils.Meta, error) {
+	params := url.Values{"sort": {"-a.id"}}
+	for _, key := range []string{"page", "per_page"} {
+		if value := queryParams.Get(key); value != "" {
+			params.Set(key, value)
+		}
+	}
+
+	filters := []squirrel.Sqlizer{}
+	if f.ActorID != nil {
+		filters = append(filters, squirrel.Eq{"a.actor_id": *f.ActorID})
+	}
+	if f.EntityType != "" {
+		filters = append(filters, squirrel.Eq{"a.entity_type": f.EntityType})
+	}
+	if f.EntityID != nil {
+		filters = append(filters, squirrel.Eq{"a.entity_id": *f.EntityID})
+	}
+	if f.Action != "" {
+		filters = append(filters, squirrel.Eq{"a.action": f.Action})
+	}
+	if f.From != nil {
+		filters = append(filters, squirrel.GtOrEq{"a.created_at": *f.From})
+	}
+	if f.To != nil {
+		filters = append(filters, squirrel.Lt{"a.created_at": *f.To})
+	}
+
+	columns := []string{
+		"a.id", "a.actor_id", "u.name AS actor_name", "a.action", "a.entity_type", "a.entity_id",
+		"COALESCE(a.before, 'null') AS before", "COALESCE(a.after, 'null') AS after",
+		"a.ip", "a.user_agent", "a.method", "a.path", "a.created_at",
+	}
+
+	entries := []AuditEntry{}
+	meta, err := utils.BuildQuery(&entries, "audit_log a", []string{"users u ON u.id = a.actor_id"}, columns, nil, params, filters)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error building query: %w", err)
+	}
+	return entries, meta, nil
+}
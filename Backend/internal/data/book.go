@@ -6,8 +6,12 @@ import (
 	"fmt"
 	"net/url"
 	"project/utils"
+	"project/utils/markdown"
 	"project/utils/validator"
+	"regexp"
+	"sort"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Masterminds/squirrel"
 	"github.com/google/uuid"
@@ -20,29 +24,92 @@ type BookDB struct {
 
 type Book struct {
 	ID          uuid.UUID `db:"id" json:"id"`
+	Identifier  string    `db:"identifier" json:"identifier,omitempty"`
 	Name        string    `db:"name" json:"name"`
 	Description *string   `db:"description" json:"description,omitempty"`
 	File        *string   `db:"file" json:"file,omitempty"`
 	Year        int       `db:"year" json:"year"`
 	Season      string    `db:"season" json:"season"`
 	Degree      *int      `db:"degree" json:"degree,omitempty"`
+	Program     *string   `db:"program" json:"program,omitempty"`
 	CreatedAt   time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt   time.Time `db:"updated_at" json:"updated_at"`
+
+	AccessLevel  string     `db:"access_level" json:"access_level,omitempty"`
+	EmbargoUntil *time.Time `db:"embargo_until" json:"embargo_until,omitempty"`
+
+	ProjectMetadata
+}
+
+// CompleteMetadata derives the name, description and language the client
+// left out from the titles and abstracts it sent.
+func (book *Book) CompleteMetadata() {
+	book.ProjectMetadata.complete(&book.Name, &book.Description)
+}
+
+// Localize shows the book's title and abstract in lang as its name and
+// description, where it has them.
+func (book *Book) Localize(lang string) {
+	book.ProjectMetadata.localize(lang, &book.Name, &book.Description)
+}
+
+// Access levels of a book's files.
+const (
+	BookAccessPublic        = "public"
+	BookAccessAuthenticated = "authenticated"
+	BookAccessStaff         = "staff"
+	BookAccessEmbargoed     = "embargoed"
+)
+
+var BookAccessLevels = []string{BookAccessPublic, BookAccessAuthenticated, BookAccessStaff, BookAccessEmbargoed}
+
+// EffectiveAccessLevel is the access level in force at now: an embargo lifts
+// by itself once its date has passed.
+func (book *Book) EffectiveAccessLevel(now time.Time) string {
+	if book.AccessLevel == "" {
+		return BookAccessPublic
+	}
+	if book.AccessLevel == BookAccessEmbargoed && book.EmbargoUntil != nil && !now.Before(*book.EmbargoUntil) {
+		return BookAccessPublic
+	}
+	return book.AccessLevel
 }
 
+// bookFileColumn points clients at the download endpoint, which enforces the
+// access level, rather than at the stored upload path.
+var bookFileColumn = fmt.Sprintf("CASE WHEN NULLIF(b.file, '') IS NOT NULL THEN FORMAT('%s/book/%%s/file', b.id) ELSE NULL END AS file", Domain)
+
+// programRX is the form of a degree program code, such as
+// "software-engineering".
+var programRX = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
 func ValidateBook(v *validator.Validator, book *Book,
 	studentIDs, advisorIDs, discussantIDs []uuid.UUID, isUpdate bool) {
 	v.Check(book.Name != "", "name", "اسم المشروع مطلوب")
 
-	v.Check(len(book.Name) >= 3, "name", "يجب أن يكون اسم المشروع على الأقل 3 أحرف")
-	v.Check(len(book.Name) <= 600, "name", "يجب أن يكون اسم المشروع أقل من 600 حرف")
+	v.Check(utf8.RuneCountInString(book.Name) >= 3, "name", "يجب أن يكون اسم المشروع على الأقل 3 أحرف")
+	v.Check(utf8.RuneCountInString(book.Name) <= 600, "name", "يجب أن يكون اسم المشروع أقل من 600 حرف")
+
+	// The description is Markdown; its length is that of the rendered text.
+	description := utf8.RuneCountInString(markdown.Text(*book.Description))
+	v.Check(description >= 60, "description", "يجب أن يكون وصف المشروع على الأقل 60 أحرف")
+	v.Check(description <= 3000, "description", "لا يمكن لوصف المشروع أن يكون أكثر من 3000 حرف")
 
-	v.Check(len(*book.Description) >= 60, "description", "يجب أن يكون وصف المشروع على الأقل 60 أحرف")
-	v.Check(len(*book.Description) <= 3000, "description", "لا يمكن لوصف المشروع أن يكون أكثر من 300 حرف")
+	ValidateProjectMetadata(v, book.Name, &book.ProjectMetadata)
 
 	v.Check(book.Year > 0, "year", "السنة مطلوبة")
 	v.Check(book.Season != "", "season", "الموسم مطلوب")
 	v.Check(book.Season == "spring" || book.Season == "fall", "season", "يجب اختيار موسم ربيع أو خريف")
+	if book.Program != nil {
+		v.Check(validator.Matches(*book.Program, programRX), "program", "رمز البرنامج الدراسي يتكون من أحرف إنجليزية صغيرة وأرقام وشرطات")
+		v.Check(len(*book.Program) <= 64, "program", "لا يمكن لرمز البرنامج الدراسي أن يكون أكثر من 64 حرفاً")
+	}
+
+	// An empty level is stored as public.
+	v.Check(book.AccessLevel == "" || validator.In(book.AccessLevel, BookAccessLevels...), "access_level", "مستوى الوصول غير صالح")
+	if book.AccessLevel == BookAccessEmbargoed {
+		v.Check(book.EmbargoUntil != nil, "embargo_until", "تاريخ انتهاء الحظر مطلوب")
+	}
 
 	v.Check(len(studentIDs) > 0, "students", "يجب إضافة طالب واحد على الأقل")
 	v.Check(len(studentIDs) <= 5, "students", "لا يمكن إضافة أكثر من 5 طلاب")
@@ -79,6 +146,23 @@ func (b *BookDB) InsertBook(book *Book, discussantIDs, advisorIDs, studentIDs []
 		return fmt.Errorf("failed to start transaction: %w", err)
 	}
 	defer tx.Rollback()
+
+	if err := insertBook(tx, book, discussantIDs, advisorIDs, studentIDs); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// insertBook inserts the book and its participants inside the caller's
+// transaction.
+func insertBook(tx *sqlx.Tx, book *Book, discussantIDs, advisorIDs, studentIDs []uuid.UUID) error {
+	var err error
 	if book.ID == uuid.Nil {
 		book.ID, err = uuid.NewUUID()
 		if err != nil {
@@ -86,8 +170,18 @@ func (b *BookDB) InsertBook(book *Book, discussantIDs, advisorIDs, studentIDs []
 		}
 	}
 
+	if book.AccessLevel == "" {
+		book.AccessLevel = BookAccessPublic
+	}
+
+	book.Identifier, err = nextBookIdentifier(tx, book.Year, book.Season)
+	if err != nil {
+		return err
+	}
+
 	query, args, err := QB.Insert("book").
-		Columns("id,name, description, file, year, season", "degree").
+		Columns("id,name, description, file, year, season", "degree", "program", "access_level", "embargo_until", "identifier",
+			"title_ar", "title_en", "abstract_ar", "abstract_en", "keywords", "language").
 		Values(
 			book.ID,
 			book.Name,
@@ -96,6 +190,16 @@ func (b *BookDB) InsertBook(book *Book, discussantIDs, advisorIDs, studentIDs []
 			book.Year,
 			book.Season,
 			book.Degree,
+			book.Program,
+			book.AccessLevel,
+			book.EmbargoUntil,
+			book.Identifier,
+			book.TitleAr,
+			book.TitleEn,
+			book.AbstractAr,
+			book.AbstractEn,
+			book.Keywords,
+			book.Language,
 		).
 		Suffix("RETURNING id, created_at, updated_at").
 		ToSql()
@@ -107,6 +211,9 @@ func (b *BookDB) InsertBook(book *Book, discussantIDs, advisorIDs, studentIDs []
 	if err != nil {
 		return fmt.Errorf("failed to insert book: %w", err)
 	}
+	if err := recordBookIdentifier(tx, book.ID, book.Identifier); err != nil {
+		return err
+	}
 
 	for _, discussantID := range discussantIDs {
 		_, err := QB.Insert("book_discussants").
@@ -139,11 +246,6 @@ func (b *BookDB) InsertBook(book *Book, discussantIDs, advisorIDs, studentIDs []
 		}
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
-	}
-
 	return nil
 }
 
@@ -154,14 +256,18 @@ func (b *BookDB) GetBookWithDetails(bookID uuid.UUID) (*BookWithDetails, error)
 
 	query, args, err := QB.Select(
 		"b.id",
+		"b.identifier",
 		"b.name",
 		"b.description",
-		fmt.Sprintf("CASE WHEN NULLIF(b.file, '') IS NOT NULL THEN FORMAT('%s/%%s', b.file) ELSE NULL END AS file", Domain),
+		bookFileColumn,
 		"b.year",
 		"b.season",
 		"b.created_at",
 		"COALESCE(b.degree, NULL) AS degree",
+		"b.program",
 		"b.updated_at",
+		"b.access_level",
+		"b.embargo_until",
 		"discussant.id AS discussant_id",
 		"COALESCE(discussant.name, '') AS discussant_name",
 		"COALESCE(discussant.email, '') AS discussant_email",
@@ -172,6 +278,7 @@ func (b *BookDB) GetBookWithDetails(bookID uuid.UUID) (*BookWithDetails, error)
 		"COALESCE(student.name, '') AS student_name",
 		"COALESCE(student.email, '') AS student_email",
 	).
+		Columns(metadataColumns("b")...).
 		From("book b").
 		LeftJoin("book_discussants bd ON bd.book_id = b.id").
 		LeftJoin("users discussant ON discussant.id = bd.discussant_id").
@@ -179,7 +286,7 @@ func (b *BookDB) GetBookWithDetails(bookID uuid.UUID) (*BookWithDetails, error)
 		LeftJoin("users advisor ON advisor.id = ba.advisor_id").
 		LeftJoin("book_students bs ON bs.book_id = b.id").
 		LeftJoin("users student ON student.id = bs.student_id").
-		Where("b.id = ?", bookID).
+		Where("b.id = ? AND b.deleted_at IS NULL", bookID).
 		ToSql()
 
 	if err != nil {
@@ -273,15 +380,51 @@ func (b *BookDB) UpdateBook(book *Book, discussantIDs, advisorIDs, studentIDs []
 	}
 	defer tx.Rollback()
 
+	if book.AccessLevel == "" {
+		book.AccessLevel = BookAccessPublic
+	}
+
+	// A book moved to another term gets that term's next identifier; the old
+	// one stays in its history.
+	var current Book
+	err = tx.Get(&current, "SELECT identifier, year, season FROM book WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", book.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("no book found to update")
+		}
+		return fmt.Errorf("failed to lock book: %w", err)
+	}
+	book.Identifier = current.Identifier
+	if current.Year != book.Year || current.Season != book.Season {
+		book.Identifier, err = nextBookIdentifier(tx, book.Year, book.Season)
+		if err != nil {
+			return err
+		}
+		if err := recordBookIdentifier(tx, book.ID, book.Identifier); err != nil {
+			return err
+		}
+	}
+
 	updateQuery, updateArgs, err := QB.Update("book").
 		Set("name", book.Name).
 		Set("description", book.Description).
 		Set("file", book.File).
 		Set("year", book.Year).
 		Set("degree", book.Degree).
+		Set("program", book.Program).
 		Set("season", book.Season).
+		Set("access_level", book.AccessLevel).
+		Set("embargo_until", book.EmbargoUntil).
+		Set("identifier", book.Identifier).
+		Set("title_ar", book.TitleAr).
+		Set("title_en", book.TitleEn).
+		Set("abstract_ar", book.AbstractAr).
+		Set("abstract_en", book.AbstractEn).
+		Set("keywords", book.Keywords).
+		Set("language", book.Language).
 		Set("updated_at", time.Now()).
 		Where(squirrel.Eq{"id": book.ID}).
+		Where("deleted_at IS NULL").
 		ToSql()
 	if err != nil {
 		return fmt.Errorf("failed to build update query: %w", err)
@@ -343,11 +486,21 @@ func (b *BookDB) UpdateBook(book *Book, discussantIDs, advisorIDs, studentIDs []
 	return nil
 }
 
-func (b *BookDB) DeleteBook(bookID uuid.UUID) error {
-	_, err := b.db.Exec("DELETE FROM book WHERE id = $1", bookID)
+// DeleteBook moves the book to the trash. Participants, attachments and the
+// file are kept until the book is purged. updated_at moves too, so harvesters
+// learn of the deletion.
+func (b *BookDB) DeleteBook(bookID, deletedBy uuid.UUID) error {
+	result, err := b.db.Exec("UPDATE book SET deleted_at = NOW(), deleted_by = $2, updated_at = NOW() WHERE id = $1 AND deleted_at IS NULL", bookID, deletedBy)
 	if err != nil {
 		return fmt.Errorf("failed to delete book: %w", err)
 	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
 	return nil
 }
 func (b *BookDB) DeleteDiscussantFromBook(bookID uuid.UUID, discussantID uuid.UUID) error {
@@ -442,140 +595,237 @@ type BookJoin struct {
 
 func (b *BookDB) ListBooks(queryParams url.Values) ([]Book, *utils.Meta, error) {
 	var books []Book
-	searchCols := []string{"b.name", "b.description"}
+	searchCols := append([]string{"b.name", "b.description"}, metadataSearchColumns("b")...)
 	table := "book b"
 
 	// Define columns to include in the result
 	bookJoinColumns := []string{
 		"b.id",
+		"b.identifier",
 		"b.name",
 		"COALESCE(b.description, '') AS description",
 		"COALESCE(b.degree, NULL) AS degree",
+		"b.program",
 	}
 
-	meta, err := utils.BuildQuery(&books, table, nil, bookJoinColumns, searchCols, queryParams, nil)
+	meta, err := utils.BuildQuery(&books, table, nil, append(bookJoinColumns, metadataColumns("b")...), searchCols, queryParams, []squirrel.Sqlizer{squirrel.Expr("b.deleted_at IS NULL")})
 	if err != nil {
 		return nil, nil, fmt.Errorf("error building query: %v", err)
 	}
 
 	return books, meta, nil
 }
+
+var bookCursor = utils.CursorConfig{
+	IDColumn: "b.id",
+	IDField:  "id",
+	SortColumns: map[string]string{
+		"created_at": "b.created_at",
+		"name":       "b.name",
+		"year":       "b.year",
+	},
+	DefaultSort: "-created_at",
+}
+
+// ListBooksCursor is ListBooks with keyset pagination.
+func (b *BookDB) ListBooksCursor(queryParams url.Values) ([]Book, *utils.CursorMeta, error) {
+	var books []Book
+	searchCols := append([]string{"b.name", "b.description"}, metadataSearchColumns("b")...)
+	table := "book b"
+
+	bookJoinColumns := []string{
+		"b.id",
+		"b.identifier",
+		"b.name",
+		"COALESCE(b.description, '') AS description",
+		"COALESCE(b.degree, NULL) AS degree",
+		"b.program",
+		"b.year",
+		"b.season",
+		"b.created_at",
+	}
+
+	meta, err := utils.BuildCursorQuery(&books, table, nil, append(bookJoinColumns, metadataColumns("b")...), searchCols, queryParams, []squirrel.Sqlizer{squirrel.Expr("b.deleted_at IS NULL")}, bookCursor)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error building query: %w", err)
+	}
+
+	return books, meta, nil
+}
 func (b *BookDB) GetBook(bookID uuid.UUID) (*BookWithDetails, error) {
 	query, args, err := QB.Select(
-		"b.id", "b.name", "b.description",
-		fmt.Sprintf("CASE WHEN NULLIF(b.file, '') IS NOT NULL THEN FORMAT('%s/%%s', b.file) ELSE NULL END AS file", Domain),
-		"b.year", "b.season", "b.created_at", "b.updated_at",
-		"COALESCE(discussant.id, '00000000-0000-0000-0000-000000000000') AS discussant_id",
-		"COALESCE(discussant.name, '') AS discussant_name",
-		"COALESCE(discussant.email, '') AS discussant_email",
-		"COALESCE(advisor.id, '00000000-0000-0000-0000-000000000000') AS advisor_id",
-		"COALESCE(advisor.name, '') AS advisor_name",
-		"COALESCE(advisor.email, '') AS advisor_email",
-		"COALESCE(student.id, '00000000-0000-0000-0000-000000000000') AS student_id",
-		"COALESCE(student.name, '') AS student_name",
-		"COALESCE(student.email, '') AS student_email",
+		"b.id", "b.identifier", "b.name", "b.description",
+		bookFileColumn,
+		"b.year", "b.season", "b.created_at", "b.updated_at", "b.access_level", "b.embargo_until",
 	).
+		Columns(metadataColumns("b")...).
 		From("book b").
-		LeftJoin("book_discussants bd ON bd.book_id = b.id").
-		LeftJoin("users discussant ON discussant.id = bd.discussant_id").
-		LeftJoin("book_advisors ba ON ba.book_id = b.id").
-		LeftJoin("users advisor ON advisor.id = ba.advisor_id").
-		LeftJoin("book_students bs ON bs.book_id = b.id").
-		LeftJoin("users student ON student.id = bs.student_id").
-		Where("b.id = ?", bookID).
+		Where("b.id = ? AND b.deleted_at IS NULL", bookID).
 		ToSql()
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to build query: %w", err)
 	}
 
-	rows, err := b.db.Queryx(query, args...)
-	if err != nil {
+	var book Book
+	if err := b.db.Get(&book, query, args...); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrRecordNotFound
 		}
 		return nil, fmt.Errorf("failed to query book: %w", err)
 	}
-	defer rows.Close()
 
-	result := &BookWithDetails{
-		Discussants: []UserDetails{},
-		Advisors:    []UserDetails{},
-		Students:    []UserDetails{},
+	result, err := b.LoadBookParticipants([]Book{book})
+	if err != nil {
+		return nil, err
 	}
 
-	bookPopulated := false
-	discussantSet := map[uuid.UUID]bool{}
-	advisorSet := map[uuid.UUID]bool{}
-	studentSet := map[uuid.UUID]bool{}
+	return &result[0], nil
+}
 
-	for rows.Next() {
-		var row struct {
-			Book
-			DiscussantID    uuid.UUID `db:"discussant_id"`
-			DiscussantName  string    `db:"discussant_name"`
-			DiscussantEmail string    `db:"discussant_email"`
-			AdvisorID       uuid.UUID `db:"advisor_id"`
-			AdvisorName     string    `db:"advisor_name"`
-			AdvisorEmail    string    `db:"advisor_email"`
-			StudentID       uuid.UUID `db:"student_id"`
-			StudentName     string    `db:"student_name"`
-			StudentEmail    string    `db:"student_email"`
-		}
+// GetBooks returns the requested books with their participants, in the order
+// of ids. Unknown IDs are skipped.
+func (b *BookDB) GetBooks(ids []uuid.UUID) ([]BookWithDetails, error) {
+	query, args, err := QB.Select(
+		"b.id", "b.identifier", "b.name", "b.description",
+		bookFileColumn,
+		"b.year", "b.season", "b.degree", "b.program", "b.created_at", "b.updated_at", "b.access_level", "b.embargo_until",
+	).
+		Columns(metadataColumns("b")...).
+		From("book b").
+		Where(squirrel.Eq{"b.id": ids}).
+		Where("b.deleted_at IS NULL").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
 
-		if err := rows.StructScan(&row); err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
-		}
+	var books []Book
+	if err := b.db.Select(&books, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to query books: %w", err)
+	}
 
-		// Populate Book details
-		if !bookPopulated {
-			result.Book = row.Book
-			bookPopulated = true
+	position := make(map[uuid.UUID]int, len(ids))
+	for i, id := range ids {
+		if _, ok := position[id]; !ok {
+			position[id] = i
 		}
+	}
+	sort.Slice(books, func(i, j int) bool {
+		return position[books[i].ID] < position[books[j].ID]
+	})
 
-		// Populate discussant details
-		if row.DiscussantID != uuid.Nil && !discussantSet[row.DiscussantID] {
-			result.Discussants = append(result.Discussants, UserDetails{
-				ID:    row.DiscussantID,
-				Name:  row.DiscussantName,
-				Email: row.DiscussantEmail,
-			})
-			discussantSet[row.DiscussantID] = true
-		}
+	return b.LoadBookParticipants(books)
+}
 
-		// Populate advisor details
-		if row.AdvisorID != uuid.Nil && !advisorSet[row.AdvisorID] {
-			result.Advisors = append(result.Advisors, UserDetails{
-				ID:    row.AdvisorID,
-				Name:  row.AdvisorName,
-				Email: row.AdvisorEmail,
-			})
-			advisorSet[row.AdvisorID] = true
+// GetBookFile returns the book with the stored path of its file, rather than
+// the public URL, along with its access level.
+func (b *BookDB) GetBookFile(bookID uuid.UUID) (*Book, error) {
+	var book Book
+	err := b.db.Get(&book, "SELECT id, file, access_level, embargo_until FROM book WHERE id = $1 AND deleted_at IS NULL", bookID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
 		}
+		return nil, fmt.Errorf("failed to query book file: %w", err)
+	}
+	return &book, nil
+}
 
-		// Populate student details
-		if row.StudentID != uuid.Nil && !studentSet[row.StudentID] {
-			result.Students = append(result.Students, UserDetails{
-				ID:    row.StudentID,
-				Name:  row.StudentName,
-				Email: row.StudentEmail,
-			})
-			studentSet[row.StudentID] = true
-		}
+// IsBookParticipant reports whether the user is a student, advisor or
+// discussant of the book.
+func (b *BookDB) IsBookParticipant(bookID, userID uuid.UUID) (bool, error) {
+	var ok bool
+	err := b.db.Get(&ok, `SELECT
+		EXISTS (SELECT 1 FROM book_students WHERE book_id = $1 AND student_id = $2) OR
+		EXISTS (SELECT 1 FROM book_advisors WHERE book_id = $1 AND advisor_id = $2) OR
+		EXISTS (SELECT 1 FROM book_discussants WHERE book_id = $1 AND discussant_id = $2)`, bookID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check book participant: %w", err)
 	}
+	return ok, nil
+}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error processing rows: %w", err)
+// IsBookAdvisor reports whether the user advises the book.
+func (b *BookDB) IsBookAdvisor(bookID, userID uuid.UUID) (bool, error) {
+	var ok bool
+	err := b.db.Get(&ok, "SELECT EXISTS (SELECT 1 FROM book_advisors WHERE book_id = $1 AND advisor_id = $2)", bookID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check book advisor: %w", err)
 	}
+	return ok, nil
+}
+
+// exportBatchSize is how many rows are buffered before their participants are
+// loaded while streaming an export.
+const exportBatchSize = 500
 
-	if !bookPopulated {
-		return nil, ErrRecordNotFound
+// StreamBooks walks every book matching the listing filters and hands them to
+// fn one by one, loading participants a batch at a time so the full archive is
+// never held in memory.
+func (b *BookDB) StreamBooks(queryParams url.Values, fn func(BookWithDetails) error) error {
+	searchCols := append([]string{"b.name", "b.description"}, metadataSearchColumns("b")...)
+	columns := []string{
+		"b.id",
+		"b.identifier",
+		"b.name",
+		"b.description",
+		bookFileColumn,
+		"b.year",
+		"b.season",
+		"b.degree",
+		"b.program",
+		"b.created_at",
+		"b.updated_at",
 	}
 
-	return result, nil
+	query, args, err := utils.BuildListQuery("book b", nil, append(columns, metadataColumns("b")...), searchCols, queryParams, []squirrel.Sqlizer{squirrel.Expr("b.deleted_at IS NULL")})
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := b.db.Queryx(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query books: %w", err)
+	}
+	defer rows.Close()
+
+	batch := make([]Book, 0, exportBatchSize)
+	flush := func() error {
+		books, err := b.LoadBookParticipants(batch)
+		if err != nil {
+			return err
+		}
+		for _, book := range books {
+			if err := fn(book); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for rows.Next() {
+		var book Book
+		if err := rows.StructScan(&book); err != nil {
+			return fmt.Errorf("failed to scan book: %w", err)
+		}
+		batch = append(batch, book)
+		if len(batch) == exportBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error processing rows: %w", err)
+	}
+
+	return flush()
 }
+
 func (p *PreProjectDB) CountBooks() (int, error) {
-	query, args, err := QB.Select("COUNT(*)").From("book").ToSql()
+	query, args, err := QB.Select("COUNT(*)").From("book").Where("deleted_at IS NULL").ToSql()
 	if err != nil {
 		return 0, fmt.Errorf("failed to build count query: %w", err)
 	}
@@ -0,0 +1,159 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+)
+
+// harvestTimeLayout matches the "timestamp without time zone" columns, so the
+// keyset comparison is done on the stored value without time zone conversion.
+const harvestTimeLayout = "2006-01-02 15:04:05.999999"
+
+// harvestSource is every book a harvester has been or will be shown: the
+// archive, trash included, and the tombstones of purged books. Trashed and
+// purged books are reported as deleted.
+const harvestSource = `(
+	SELECT id, updated_at, year, season, program, deleted_at IS NOT NULL AS deleted FROM book
+	UNION ALL
+	SELECT id, deleted_at, year, season, program, TRUE FROM book_tombstones
+) b`
+
+// HarvestFilter selects books for metadata harvesting. From is inclusive and
+// Until exclusive; the resume position is the (updated_at, id) of the last
+// record already returned.
+type HarvestFilter struct {
+	From        *time.Time
+	Until       *time.Time
+	Year        *int
+	Season      string
+	Program     string
+	AfterUpdate *time.Time
+	AfterID     uuid.UUID
+	Limit       int
+}
+
+// HarvestItem is the header of a harvested book. The metadata of the ones not
+// deleted is loaded separately.
+type HarvestItem struct {
+	ID        uuid.UUID `db:"id"`
+	UpdatedAt time.Time `db:"updated_at"`
+	Year      int       `db:"year"`
+	Season    string    `db:"season"`
+	Program   *string   `db:"program"`
+	Deleted   bool      `db:"deleted"`
+}
+
+// BookTerm is a year and season that has at least one book.
+type BookTerm struct {
+	Year   int    `db:"year"`
+	Season string `db:"season"`
+}
+
+func (f HarvestFilter) apply(sb squirrel.SelectBuilder) squirrel.SelectBuilder {
+	if f.From != nil {
+		sb = sb.Where("b.updated_at >= ?::timestamp", f.From.UTC().Format(harvestTimeLayout))
+	}
+	if f.Until != nil {
+		sb = sb.Where("b.updated_at < ?::timestamp", f.Until.UTC().Format(harvestTimeLayout))
+	}
+	if f.Year != nil {
+		sb = sb.Where(squirrel.Eq{"b.year": *f.Year})
+	}
+	if f.Season != "" {
+		sb = sb.Where(squirrel.Eq{"b.season": f.Season})
+	}
+	if f.Program != "" {
+		sb = sb.Where(squirrel.Eq{"b.program": f.Program})
+	}
+	return sb
+}
+
+// HarvestBooks returns the next batch of book headers ordered by last
+// modification, for incremental harvesting.
+func (b *BookDB) HarvestBooks(f HarvestFilter) ([]HarvestItem, error) {
+	sb := f.apply(QB.Select("b.id", "b.updated_at", "b.year", "b.season", "b.program", "b.deleted").From(harvestSource))
+
+	if f.AfterUpdate != nil {
+		sb = sb.Where("(b.updated_at, b.id) > (?::timestamp, ?)", f.AfterUpdate.UTC().Format(harvestTimeLayout), f.AfterID)
+	}
+
+	query, args, err := sb.OrderBy("b.updated_at ASC", "b.id ASC").Limit(uint64(f.Limit)).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var items []HarvestItem
+	if err := b.db.Select(&items, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to query books: %w", err)
+	}
+	return items, nil
+}
+
+// GetHarvestItem returns the header of the book, deleted or not, or
+// ErrRecordNotFound when it never existed.
+func (b *BookDB) GetHarvestItem(id uuid.UUID) (*HarvestItem, error) {
+	query, args, err := QB.Select("b.id", "b.updated_at", "b.year", "b.season", "b.program", "b.deleted").
+		From(harvestSource).Where(squirrel.Eq{"b.id": id}).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var item HarvestItem
+	if err := b.db.Get(&item, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to query book: %w", err)
+	}
+	return &item, nil
+}
+
+// CountHarvestBooks counts every book matching the filter, ignoring the resume
+// position.
+func (b *BookDB) CountHarvestBooks(f HarvestFilter) (int, error) {
+	query, args, err := f.apply(QB.Select("COUNT(*)").From(harvestSource)).ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var count int
+	if err := b.db.Get(&count, query, args...); err != nil {
+		return 0, fmt.Errorf("failed to count books: %w", err)
+	}
+	return count, nil
+}
+
+// EarliestBookUpdate returns the oldest datestamp harvesters are shown, or
+// the zero time when the archive is empty.
+func (b *BookDB) EarliestBookUpdate() (time.Time, error) {
+	var earliest sql.NullTime
+	if err := b.db.Get(&earliest, "SELECT MIN(b.updated_at) FROM "+harvestSource); err != nil {
+		return time.Time{}, fmt.Errorf("failed to query earliest update: %w", err)
+	}
+	return earliest.Time, nil
+}
+
+// ListBookTerms lists the distinct year and season pairs of the archive.
+func (b *BookDB) ListBookTerms() ([]BookTerm, error) {
+	var terms []BookTerm
+	err := b.db.Select(&terms, "SELECT DISTINCT year, season FROM book WHERE deleted_at IS NULL ORDER BY year DESC, season")
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to query book terms: %w", err)
+	}
+	return terms, nil
+}
+
+// ListBookPrograms lists the distinct degree programs of the archive.
+func (b *BookDB) ListBookPrograms() ([]string, error) {
+	var programs []string
+	err := b.db.Select(&programs, "SELECT DISTINCT program FROM book WHERE deleted_at IS NULL AND program IS NOT NULL ORDER BY program")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query book programs: %w", err)
+	}
+	return programs, nil
+}
@@ -0,0 +1,77 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// bookIdentifierPrefix starts every persistent identifier, as in
+// PA-2024-F-0012.
+const bookIdentifierPrefix = "PA"
+
+// formatBookIdentifier builds the identifier of the seq-th book of a term.
+func formatBookIdentifier(year int, season string, seq int) string {
+	initial := ""
+	if season != "" {
+		initial = strings.ToUpper(season[:1])
+	}
+	return fmt.Sprintf("%s-%d-%s-%04d", bookIdentifierPrefix, year, initial, seq)
+}
+
+// NormalizeBookIdentifier lets identifiers be looked up however they were
+// typed or cased.
+func NormalizeBookIdentifier(identifier string) string {
+	return strings.ToUpper(strings.TrimSpace(identifier))
+}
+
+// nextBookIdentifier takes the next number of the term inside the caller's
+// transaction. Numbers are never handed out twice, even once the book that
+// held one is purged.
+func nextBookIdentifier(tx *sqlx.Tx, year int, season string) (string, error) {
+	var seq int
+	err := tx.Get(&seq, `
+		INSERT INTO book_identifier_sequences (year, season, last_value) VALUES ($1, $2, 1)
+		ON CONFLICT (year, season) DO UPDATE SET last_value = book_identifier_sequences.last_value + 1
+		RETURNING last_value`, year, season)
+	if err != nil {
+		return "", fmt.Errorf("failed to mint book identifier: %w", err)
+	}
+	return formatBookIdentifier(year, season, seq), nil
+}
+
+// recordBookIdentifier adds an identifier to the book's history, so it keeps
+// resolving once superseded.
+func recordBookIdentifier(tx *sqlx.Tx, bookID uuid.UUID, identifier string) error {
+	_, err := tx.Exec("INSERT INTO book_identifiers (identifier, book_id) VALUES ($1, $2)", identifier, bookID)
+	if err != nil {
+		return fmt.Errorf("failed to record book identifier: %w", err)
+	}
+	return nil
+}
+
+// ResolveBookIdentifier finds the book an identifier was given to, along with
+// the book's current identifier, which differs when the given one was
+// superseded.
+func (b *BookDB) ResolveBookIdentifier(identifier string) (uuid.UUID, string, error) {
+	var row struct {
+		ID         uuid.UUID `db:"id"`
+		Identifier string    `db:"identifier"`
+	}
+	err := b.db.Get(&row, `
+		SELECT b.id, b.identifier
+		FROM book_identifiers bi
+		JOIN book b ON b.id = bi.book_id
+		WHERE bi.identifier = $1 AND b.deleted_at IS NULL`, NormalizeBookIdentifier(identifier))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, "", ErrRecordNotFound
+		}
+		return uuid.Nil, "", fmt.Errorf("failed to resolve book identifier: %w", err)
+	}
+	return row.ID, row.Identifier, nil
+}
@@ -0,0 +1,242 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"project/utils/validator"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrAmbiguousName = errors.New("يوجد أكثر من مستخدم بهذا الاسم، يرجى استخدام البريد الإلكتروني")
+
+// placeholderEmailDomain is used for imported people that came without an
+// email. The .invalid TLD can never receive mail.
+const placeholderEmailDomain = "placeholder.invalid"
+
+// ImportParticipant is a student, advisor or discussant as written in an
+// import sheet: an email, a name, or both.
+type ImportParticipant struct {
+	Name  string
+	Email string
+}
+
+// BookImportRow is one parsed spreadsheet row. Errors holds problems found
+// while parsing; such rows are reported but never inserted.
+type BookImportRow struct {
+	Line        int
+	Book        Book
+	Students    []ImportParticipant
+	Advisors    []ImportParticipant
+	Discussants []ImportParticipant
+	Errors      map[string]string
+}
+
+type BookImportResult struct {
+	Line         int               `json:"line"`
+	Name         string            `json:"name"`
+	BookID       *uuid.UUID        `json:"book_id,omitempty"`
+	Placeholders []string          `json:"placeholders,omitempty"`
+	Errors       map[string]string `json:"errors,omitempty"`
+}
+
+// bookImporter resolves participants inside the import transaction and
+// remembers placeholders so a person repeated across rows is created once.
+type bookImporter struct {
+	tx       *sqlx.Tx
+	resolved map[string]uuid.UUID
+}
+
+func participantKey(p ImportParticipant) string {
+	if p.Email != "" {
+		return "email:" + strings.ToLower(p.Email)
+	}
+	return "name:" + strings.ToLower(p.Name)
+}
+
+// resolve finds the user for p by email, then by exact name, and creates an
+// unverified placeholder account when nobody matches. created is set when a
+// placeholder was made for this call.
+func (imp *bookImporter) resolve(p ImportParticipant, role string) (id uuid.UUID, created bool, err error) {
+	key := participantKey(p)
+	if id, ok := imp.resolved[key]; ok {
+		return id, false, nil
+	}
+
+	if p.Email != "" {
+		err = imp.tx.Get(&id, `SELECT id FROM users WHERE lower(email) = lower($1) AND deleted_at IS NULL`, p.Email)
+	} else {
+		var ids []uuid.UUID
+		err = imp.tx.Select(&ids, `SELECT id FROM users WHERE lower(name) = lower($1) AND deleted_at IS NULL LIMIT 2`, p.Name)
+		switch {
+		case err != nil:
+		case len(ids) > 1:
+			return uuid.Nil, false, ErrAmbiguousName
+		case len(ids) == 1:
+			id = ids[0]
+		default:
+			err = sql.ErrNoRows
+		}
+	}
+	if err == nil {
+		imp.resolved[key] = id
+		return id, false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, false, fmt.Errorf("failed to look up participant: %w", err)
+	}
+
+	name, email := p.Name, p.Email
+	if email == "" {
+		email = fmt.Sprintf("imported-%s@%s", uuid.NewString(), placeholderEmailDomain)
+	}
+	if name == "" {
+		name = strings.SplitN(email, "@", 2)[0]
+	}
+
+	// The password is not a bcrypt hash, so nobody can sign in until the
+	// account is claimed through the password reset flow, which clears
+	// placeholder and verifies it.
+	query, args, err := QB.Insert("users").
+		Columns("name", "email", "password", "verified", "placeholder").
+		Values(name, email, "!", false, true).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		return uuid.Nil, false, fmt.Errorf("failed to build query: %w", err)
+	}
+	if err := imp.tx.Get(&id, query, args...); err != nil {
+		return uuid.Nil, false, fmt.Errorf("failed to create placeholder user: %w", err)
+	}
+
+	if role != "" {
+		if _, err := imp.tx.Exec(`INSERT INTO user_roles (user_id, role_id) SELECT $1, id FROM roles WHERE name = $2`, id, role); err != nil {
+			return uuid.Nil, false, fmt.Errorf("failed to grant placeholder role: %w", err)
+		}
+	}
+
+	imp.resolved[key] = id
+	return id, true, nil
+}
+
+func (imp *bookImporter) resolveAll(people []ImportParticipant, role string, field string,
+	errs map[string]string, placeholders *[]string) ([]uuid.UUID, error) {
+
+	seen := make(map[uuid.UUID]bool)
+	var ids []uuid.UUID
+	for _, p := range people {
+		id, created, err := imp.resolve(p, role)
+		if errors.Is(err, ErrAmbiguousName) {
+			errs[field] = fmt.Sprintf("%s: %s", p.Name, err.Error())
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			label := p.Email
+			if label == "" {
+				label = p.Name
+			}
+			*placeholders = append(*placeholders, label)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// ImportBooks validates and inserts the rows in a single transaction. Each row
+// runs under its own savepoint so a failing row is reported without discarding
+// the others. With dryRun everything, placeholders included, is rolled back and
+// only the per-row report is returned.
+func (b *BookDB) ImportBooks(rows []BookImportRow, dryRun bool) ([]BookImportResult, error) {
+	tx, err := b.db.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	imp := &bookImporter{tx: tx, resolved: make(map[string]uuid.UUID)}
+	results := make([]BookImportResult, 0, len(rows))
+
+	for _, row := range rows {
+		result := BookImportResult{Line: row.Line, Name: row.Book.Name, Errors: row.Errors}
+		if len(result.Errors) > 0 {
+			results = append(results, result)
+			continue
+		}
+		result.Errors = make(map[string]string)
+
+		if _, err := tx.Exec("SAVEPOINT import_row"); err != nil {
+			return nil, fmt.Errorf("failed to create savepoint: %w", err)
+		}
+
+		book := row.Book
+		err := imp.importRow(&book, row, &result)
+		if err == nil && len(result.Errors) == 0 {
+			result.BookID = &book.ID
+			_, err = tx.Exec("RELEASE SAVEPOINT import_row")
+		} else {
+			if err != nil {
+				result.Errors["row"] = err.Error()
+			}
+			result.Placeholders = nil
+			// Placeholders made for this row go away with it, so forget them.
+			imp.resolved = make(map[string]uuid.UUID)
+			_, err = tx.Exec("ROLLBACK TO SAVEPOINT import_row")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to finish import row %d: %w", row.Line, err)
+		}
+
+		if len(result.Errors) == 0 {
+			result.Errors = nil
+		}
+		results = append(results, result)
+	}
+
+	if dryRun {
+		return results, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return results, nil
+}
+
+func (imp *bookImporter) importRow(book *Book, row BookImportRow, result *BookImportResult) error {
+	// Imported students have already graduated.
+	studentIDs, err := imp.resolveAll(row.Students, RoleGraduatedStudent, "students", result.Errors, &result.Placeholders)
+	if err != nil {
+		return err
+	}
+	advisorIDs, err := imp.resolveAll(row.Advisors, "", "advisors", result.Errors, &result.Placeholders)
+	if err != nil {
+		return err
+	}
+	discussantIDs, err := imp.resolveAll(row.Discussants, "", "discutant", result.Errors, &result.Placeholders)
+	if err != nil {
+		return err
+	}
+	if len(result.Errors) > 0 {
+		return nil
+	}
+
+	v := validator.New()
+	ValidateBook(v, book, studentIDs, advisorIDs, discussantIDs, false)
+	if !v.Valid() {
+		for field, message := range v.Errors {
+			result.Errors[field] = message
+		}
+		return nil
+	}
+
+	return insertBook(imp.tx, book, discussantIDs, advisorIDs, studentIDs)
+}
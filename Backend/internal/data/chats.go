@@ -9,6 +9,7 @@ import (
 	"project/utils/validator"
 	"time"
 
+	"github.com/Masterminds/squirrel"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
@@ -76,7 +77,7 @@ func (c *ChatDB) GetChatsByConversationID(conversationID uuid.UUID, queryParams
 		"sender.name AS sender_name", "sender.email AS sender_email", "receiver.name AS receiver_name", "receiver.email AS receiver_email"}
 
 	// Add additional filters for conversation ID
-	additionalFilters := []string{fmt.Sprintf("chats.conversation_id = '%s'", conversationID)}
+	additionalFilters := []squirrel.Sqlizer{squirrel.Eq{"chats.conversation_id": conversationID}}
 
 	meta, err := utils.BuildQuery(&chats, "chats", joins, columns, nil, queryParams, additionalFilters)
 	if err != nil {
@@ -86,6 +87,33 @@ func (c *ChatDB) GetChatsByConversationID(conversationID uuid.UUID, queryParams
 	return chats, meta, nil
 }
 
+var chatCursor = utils.CursorConfig{
+	IDColumn: "chats.id",
+	IDField:  "chat_id",
+	SortColumns: map[string]string{
+		"created_at": "chats.created_at",
+	},
+	DefaultSort: "-created_at",
+}
+
+// GetChatsByConversationIDCursor is GetChatsByConversationID with keyset pagination.
+func (c *ChatDB) GetChatsByConversationIDCursor(conversationID uuid.UUID, queryParams url.Values) ([]ChatWithUsers, *utils.CursorMeta, error) {
+	var chats []ChatWithUsers
+	joins := []string{
+		"users AS sender ON chats.sender_id = sender.id",
+		"users AS receiver ON chats.receiver_id = receiver.id",
+	}
+
+	additionalFilters := []squirrel.Sqlizer{squirrel.Eq{"chats.conversation_id": conversationID}}
+
+	meta, err := utils.BuildCursorQuery(&chats, "chats", joins, chatColumns, nil, queryParams, additionalFilters, chatCursor)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error building query: %w", err)
+	}
+
+	return chats, meta, nil
+}
+
 // DeleteChat deletes a specific chat message by its ID
 func (c *ChatDB) DeleteChat(chatID uuid.UUID) error {
 	// Construct the SQL query to delete the chat message by its ID
@@ -109,6 +137,7 @@ func (c *ChatDB) GetChatByID(chatID uuid.UUID) (*ChatWithUsers, error) {
 		Join("users AS sender ON chats.sender_id = sender.id").
 		Join("users AS receiver ON chats.receiver_id = receiver.id").
 		Where("chats.id = ?", chatID).
+		Where("chats.conversation_id IN (SELECT id FROM conversations WHERE deleted_at IS NULL)").
 		ToSql()
 	if err != nil {
 		return nil, err
@@ -40,6 +40,7 @@ func (c *ConversationDB) GetConversation(studentID, teacherID uuid.UUID) (uuid.U
 	query, args, err := QB.Select("id").
 		From("conversations").
 		Where("user1_id = ? AND user2_id = ?", studentID, teacherID).
+		Where("deleted_at IS NULL").
 		ToSql()
 	if err != nil {
 		return uuid.Nil, err
@@ -112,6 +113,7 @@ func (u *ConversationDB) buildConversationsQuery(userID uuid.UUID) (string, []in
 		Join("users u2 ON c.user2_id = u2.id").
 		Join("chats ch ON c.id = ch.conversation_id").
 		Where("c.user1_id = ? OR c.user2_id = ?", userID, userID).
+		Where("c.deleted_at IS NULL AND u1.deleted_at IS NULL AND u2.deleted_at IS NULL").
 		GroupBy("c.id", "c.user1_id", "c.user2_id", "u1.email", "u2.email", "u1.name", "u2.name", "c.created_at", "user1_image", "user2_image").
 		OrderBy("latest_message_time DESC"). // Order by the latest message timestamp
 		ToSql()
@@ -122,22 +124,19 @@ func (u *ConversationDB) buildConversationsQuery(userID uuid.UUID) (string, []in
 	return query, args, nil
 }
 
-func (c *ConversationDB) DeleteConversation(conversationID uuid.UUID) error {
-	// First, delete all chats associated with the conversation
-	err := c.DeleteChatsByConversationID(conversationID)
-	if err != nil {
-		return err
-	}
-
-	// Now delete the conversation itself
-	query, args, err := QB.Delete("conversations").
+// DeleteConversation moves the conversation to the trash. Its chats and their
+// files are kept until the conversation is purged.
+func (c *ConversationDB) DeleteConversation(conversationID, deletedBy uuid.UUID) error {
+	query, args, err := QB.Update("conversations").
+		Set("deleted_at", time.Now()).
+		Set("deleted_by", deletedBy).
 		Where("id = ?", conversationID).
+		Where("deleted_at IS NULL").
 		ToSql()
 	if err != nil {
 		return err
 	}
 
-	// Execute the delete query
 	result, err := c.db.Exec(query, args...)
 	if err != nil {
 		return err
@@ -197,9 +196,10 @@ func (c *ConversationDB) DeleteChatsByConversationID(conversationID uuid.UUID) e
 }
 func (c *ConversationDB) GetConversationByID(conversationID uuid.UUID) (*Conversation, error) {
 	var conversation Conversation
-	query, args, err := QB.Select("*").
+	query, args, err := QB.Select("id", "user1_id", "user2_id", "created_at").
 		From("conversations").
 		Where("id = ?", conversationID).
+		Where("deleted_at IS NULL").
 		ToSql()
 	if err != nil {
 		return nil, err
@@ -0,0 +1,68 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+)
+
+// BookFeedFilter selects the newest books for the syndication feed.
+type BookFeedFilter struct {
+	Year      *int
+	Degree    *int
+	AdvisorID *uuid.UUID
+	Limit     int
+}
+
+// FeedBooks returns the newest books matching the filter with their
+// participants. File holds the stored path, not the public URL, so the caller
+// can describe the enclosure.
+func (b *BookDB) FeedBooks(f BookFeedFilter) ([]BookWithDetails, error) {
+	sb := QB.Select(
+		"b.id", "b.identifier", "b.name", "b.description", "NULLIF(b.file, '') AS file",
+		"b.year", "b.season", "b.degree", "b.program", "b.created_at", "b.updated_at", "b.access_level", "b.embargo_until",
+	).Columns(metadataColumns("b")...).From("book b").Where("b.deleted_at IS NULL")
+	if f.Year != nil {
+		sb = sb.Where(squirrel.Eq{"b.year": *f.Year})
+	}
+	if f.Degree != nil {
+		sb = sb.Where(squirrel.Eq{"b.degree": *f.Degree})
+	}
+	if f.AdvisorID != nil {
+		sb = sb.Where("EXISTS (SELECT 1 FROM book_advisors ba WHERE ba.book_id = b.id AND ba.advisor_id = ?)", *f.AdvisorID)
+	}
+
+	query, args, err := sb.OrderBy("b.created_at DESC", "b.id DESC").Limit(uint64(f.Limit)).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var books []Book
+	if err := b.db.Select(&books, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to query books: %w", err)
+	}
+
+	return b.LoadBookParticipants(books)
+}
+
+// FeedPosts returns the newest live posts. File holds the stored path, not the
+// public URL.
+func (p *PostDB) FeedPosts(limit int) ([]Post, error) {
+	query, args, err := QB.Select("id", "description", "description AS description_html", "NULLIF(file, '') AS file", "category", "publish_at", "created_at", "updated_at",
+		"(SELECT name FROM users WHERE users.id = post.author_id) AS author_name").
+		From("post").
+		Where(PostFilter{}.conditions()).
+		OrderBy("publish_at DESC", "id DESC").
+		Limit(uint64(limit)).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	posts := []Post{}
+	if err := p.db.Select(&posts, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to query posts: %w", err)
+	}
+	return posts, nil
+}
@@ -0,0 +1,163 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MaxVerificationCodeAttempts is how many guesses an issued verification or
+// password reset code takes before a new one has to be requested.
+const MaxVerificationCodeAttempts = 5
+
+// Scopes failed sign-ins are counted in.
+const (
+	ThrottleAccount   = "account"
+	ThrottleIP        = "ip"
+	ThrottleTwoFactor = "two_factor"
+)
+
+// ThrottlePolicy is how a scope slows down guessing. The first FreeFailures
+// failures cost nothing; each one after that doubles the wait before the next
+// attempt, from BaseDelay up to MaxDelay. At LockoutFailures the key is locked
+// for LockoutDuration and the count starts over. Failures older than Window
+// are forgotten.
+type ThrottlePolicy struct {
+	FreeFailures    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutFailures int
+	LockoutDuration time.Duration
+	Window          time.Duration
+}
+
+var (
+	AccountThrottle = ThrottlePolicy{
+		FreeFailures:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Minute,
+		LockoutFailures: 10,
+		LockoutDuration: 15 * time.Minute,
+		Window:          time.Hour,
+	}
+	// IPThrottle is looser, since a campus or office shares addresses.
+	IPThrottle = ThrottlePolicy{
+		FreeFailures:    10,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Minute,
+		LockoutFailures: 50,
+		LockoutDuration: time.Hour,
+		Window:          time.Hour,
+	}
+)
+
+// delay is how long to wait after the given number of failures.
+func (p ThrottlePolicy) delay(failures int) time.Duration {
+	if failures < p.FreeFailures {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeFailures; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// locksOut reports whether the given number of failures locks the key out.
+func (p ThrottlePolicy) locksOut(failures int) bool {
+	return failures >= p.LockoutFailures
+}
+
+// LoginFailures is the failed sign-in record of a key in a scope.
+type LoginFailures struct {
+	Failures      int        `db:"failures" json:"failures"`
+	LastFailureAt time.Time  `db:"last_failure_at" json:"last_failure_at"`
+	LockedUntil   *time.Time `db:"locked_until" json:"locked_until"`
+}
+
+type LoginThrottleDB struct {
+	db *sqlx.DB
+}
+
+// RetryAfter returns how long the key must wait before its next attempt, and
+// whether that is because it is locked out.
+func (l *LoginThrottleDB) RetryAfter(scope, key string, policy ThrottlePolicy) (time.Duration, bool, error) {
+	record, err := l.GetFailures(scope, key)
+	if err != nil || record == nil {
+		return 0, false, err
+	}
+
+	now := time.Now()
+	if record.LockedUntil != nil && record.LockedUntil.After(now) {
+		return record.LockedUntil.Sub(now), true, nil
+	}
+	if now.Sub(record.LastFailureAt) > policy.Window {
+		return 0, false, nil
+	}
+	if wait := record.LastFailureAt.Add(policy.delay(record.Failures)).Sub(now); wait > 0 {
+		return wait, false, nil
+	}
+	return 0, false, nil
+}
+
+// RecordFailure counts a failed attempt of the key. It returns when the key
+// is locked until if this failure locked it.
+func (l *LoginThrottleDB) RecordFailure(scope, key string, policy ThrottlePolicy) (*time.Time, error) {
+	var failures int
+	err := l.db.Get(&failures, `
+		INSERT INTO login_failures (scope, key, failures, last_failure_at) VALUES ($1, $2, 1, NOW())
+		ON CONFLICT (scope, key) DO UPDATE SET
+			failures = CASE WHEN login_failures.last_failure_at < NOW() - make_interval(secs => $3)
+				THEN 1 ELSE login_failures.failures + 1 END,
+			last_failure_at = NOW()
+		RETURNING failures`, scope, key, policy.Window.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to record login failure: %w", err)
+	}
+	if !policy.locksOut(failures) {
+		return nil, nil
+	}
+
+	lockedUntil := time.Now().Add(policy.LockoutDuration)
+	_, err = l.db.Exec("UPDATE login_failures SET failures = 0, locked_until = $3 WHERE scope = $1 AND key = $2",
+		scope, key, lockedUntil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock out: %w", err)
+	}
+	return &lockedUntil, nil
+}
+
+// Reset forgets the key's failures and lifts its lockout.
+func (l *LoginThrottleDB) Reset(scope, key string) error {
+	if _, err := l.db.Exec("DELETE FROM login_failures WHERE scope = $1 AND key = $2", scope, key); err != nil {
+		return fmt.Errorf("failed to reset login failures: %w", err)
+	}
+	return nil
+}
+
+// GetFailures returns the key's record, or nil without one.
+func (l *LoginThrottleDB) GetFailures(scope, key string) (*LoginFailures, error) {
+	var record LoginFailures
+	err := l.db.Get(&record, "SELECT failures, last_failure_at, locked_until FROM login_failures WHERE scope = $1 AND key = $2", scope, key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get login failures: %w", err)
+	}
+	return &record, nil
+}
+
+// PruneFailures deletes the records that no longer slow anyone down.
+func (l *LoginThrottleDB) PruneFailures(before time.Time) (int64, error) {
+	result, err := l.db.Exec(`
+		DELETE FROM login_failures
+		WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < NOW())`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune login failures: %w", err)
+	}
+	return result.RowsAffected()
+}
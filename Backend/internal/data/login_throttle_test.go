@@ -0,0 +1,60 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottleSchedule(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   ThrottlePolicy
+		failures int
+		delay    time.Duration
+		locked   bool
+	}{
+		{"account, no failures", AccountThrottle, 0, 0, false},
+		{"account, last free failure", AccountThrottle, 2, 0, false},
+		{"account, first delayed failure", AccountThrottle, 3, time.Second, false},
+		{"account, doubles", AccountThrottle, 4, 2 * time.Second, false},
+		{"account, doubles again", AccountThrottle, 5, 4 * time.Second, false},
+		{"account, before lockout", AccountThrottle, 9, 64 * time.Second, false},
+		{"account, lockout", AccountThrottle, 10, 128 * time.Second, true},
+		{"account, capped", AccountThrottle, 12, 5 * time.Minute, true},
+		{"account, stays capped", AccountThrottle, 100, 5 * time.Minute, true},
+
+		{"ip, last free failure", IPThrottle, 9, 0, false},
+		{"ip, first delayed failure", IPThrottle, 10, time.Second, false},
+		{"ip, doubles", IPThrottle, 12, 4 * time.Second, false},
+		{"ip, last below the cap", IPThrottle, 18, 256 * time.Second, false},
+		{"ip, capped", IPThrottle, 19, 5 * time.Minute, false},
+		{"ip, before lockout", IPThrottle, 49, 5 * time.Minute, false},
+		{"ip, lockout", IPThrottle, 50, 5 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.delay(tt.failures); got != tt.delay {
+			t.Errorf("%s: delay(%d) = %v, want %v", tt.name, tt.failures, got, tt.delay)
+		}
+		if got := tt.policy.locksOut(tt.failures); got != tt.locked {
+			t.Errorf("%s: locksOut(%d) = %v, want %v", tt.name, tt.failures, got, tt.locked)
+		}
+	}
+}
+
+// Lockouts must last longer than the longest backoff, or waiting out the
+// lockout would be faster than guessing through the delays.
+func TestThrottlePolicies(t *testing.T) {
+	for name, p := range map[string]ThrottlePolicy{"account": AccountThrottle, "ip": IPThrottle} {
+		if p.LockoutFailures <= p.FreeFailures {
+			t.Errorf("%s: locks out at %d failures, within the %d free ones", name, p.LockoutFailures, p.FreeFailures)
+		}
+		if p.LockoutDuration <= p.MaxDelay {
+			t.Errorf("%s: lockout of %v is not longer than the %v maximum delay", name, p.LockoutDuration, p.MaxDelay)
+		}
+		for failures := 1; failures <= p.LockoutFailures; failures++ {
+			if p.delay(failures) < p.delay(failures-1) {
+				t.Errorf("%s: delay drops from %d to %d failures", name, failures-1, failures)
+			}
+		}
+	}
+}
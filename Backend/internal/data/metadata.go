@@ -0,0 +1,171 @@
+package data
+
+import (
+	"project/utils/markdown"
+	"project/utils/validator"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// Languages a project can be written in.
+const (
+	LanguageArabic  = "ar"
+	LanguageEnglish = "en"
+)
+
+var ProjectLanguages = []string{LanguageArabic, LanguageEnglish}
+
+const maxKeywords = 10
+
+// ProjectMetadata is the bilingual cataloguing data of books and
+// pre-projects. The project's name and description remain the title and
+// abstract shown when the client asks for no language in particular.
+type ProjectMetadata struct {
+	TitleAr    *string        `db:"title_ar" json:"title_ar,omitempty"`
+	TitleEn    *string        `db:"title_en" json:"title_en,omitempty"`
+	AbstractAr *string        `db:"abstract_ar" json:"abstract_ar,omitempty"`
+	AbstractEn *string        `db:"abstract_en" json:"abstract_en,omitempty"`
+	Keywords   pq.StringArray `db:"keywords" json:"keywords"`
+	Language   string         `db:"language" json:"language,omitempty"`
+
+	// DescriptionHTML is the description rendered from Markdown.
+	DescriptionHTML RenderedMarkdown `db:"description_html" json:"description_html,omitempty"`
+}
+
+// metadataColumns lists the metadata columns of the table aliased as alias.
+func metadataColumns(alias string) []string {
+	columns := []string{"title_ar", "title_en", "abstract_ar", "abstract_en", "keywords", "language"}
+	for i, column := range columns {
+		columns[i] = alias + "." + column
+	}
+	return append(columns, alias+".description AS description_html")
+}
+
+// metadataSearchColumns adds both titles, both abstracts and the keywords to
+// the "q" search.
+func metadataSearchColumns(alias string) []string {
+	return []string{
+		alias + ".title_ar", alias + ".title_en",
+		alias + ".abstract_ar", alias + ".abstract_en",
+		"array_to_string(" + alias + ".keywords, ' ')",
+	}
+}
+
+// Title returns the title in lang, or nil when there is none.
+func (m *ProjectMetadata) Title(lang string) *string {
+	switch lang {
+	case LanguageArabic:
+		return m.TitleAr
+	case LanguageEnglish:
+		return m.TitleEn
+	}
+	return nil
+}
+
+// Abstract returns the abstract in lang, or nil when there is none.
+func (m *ProjectMetadata) Abstract(lang string) *string {
+	switch lang {
+	case LanguageArabic:
+		return m.AbstractAr
+	case LanguageEnglish:
+		return m.AbstractEn
+	}
+	return nil
+}
+
+// complete fills in what the client left out: the language from the script
+// of the titles, then the name and description from the title and abstract
+// in that language, or else in the other one.
+func (m *ProjectMetadata) complete(name *string, description **string) {
+	if m.Keywords == nil {
+		m.Keywords = pq.StringArray{}
+	}
+	if m.Language == "" {
+		m.Language = LanguageEnglish
+		switch {
+		case m.TitleAr != nil:
+			m.Language = LanguageArabic
+		case m.TitleEn == nil && isArabic(*name):
+			m.Language = LanguageArabic
+		}
+	}
+
+	order := []string{m.Language, LanguageArabic, LanguageEnglish}
+	for _, lang := range order {
+		if title := m.Title(lang); *name == "" && title != nil {
+			*name = *title
+		}
+		if abstract := m.Abstract(lang); (*description == nil || **description == "") && abstract != nil {
+			*description = abstract
+		}
+	}
+	m.DescriptionHTML = renderMarkdown(*description)
+}
+
+// localize swaps the name and description for the title and abstract in
+// lang, where the project has them.
+func (m *ProjectMetadata) localize(lang string, name *string, description **string) {
+	if title := m.Title(lang); title != nil {
+		*name = *title
+	}
+	if abstract := m.Abstract(lang); abstract != nil {
+		*description = abstract
+		m.DescriptionHTML = renderMarkdown(abstract)
+	}
+}
+
+func isArabic(s string) bool {
+	for _, r := range s {
+		if unicode.Is(unicode.Arabic, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseKeywords splits a comma separated list, Arabic commas included, and
+// drops blanks and case-insensitive duplicates.
+func ParseKeywords(value string) pq.StringArray {
+	keywords := pq.StringArray{}
+	seen := make(map[string]bool)
+	for _, keyword := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '،' }) {
+		keyword = strings.Join(strings.Fields(keyword), " ")
+		key := strings.ToLower(keyword)
+		if keyword == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		keywords = append(keywords, keyword)
+	}
+	return keywords
+}
+
+func ValidateProjectMetadata(v *validator.Validator, name string, m *ProjectMetadata) {
+	v.Check(name != "" || m.TitleAr != nil || m.TitleEn != nil, "title", "يجب إدخال عنوان المشروع بالعربية أو بالإنجليزية على الأقل")
+
+	if m.TitleAr != nil {
+		v.Check(utf8.RuneCountInString(*m.TitleAr) >= 3, "title_ar", "يجب أن يكون العنوان العربي على الأقل 3 أحرف")
+		v.Check(utf8.RuneCountInString(*m.TitleAr) <= 600, "title_ar", "يجب أن يكون العنوان العربي أقل من 600 حرف")
+		v.Check(isArabic(*m.TitleAr), "title_ar", "يجب أن يكون العنوان العربي مكتوبا بالعربية")
+	}
+	if m.TitleEn != nil {
+		v.Check(utf8.RuneCountInString(*m.TitleEn) >= 3, "title_en", "يجب أن يكون العنوان الإنجليزي على الأقل 3 أحرف")
+		v.Check(utf8.RuneCountInString(*m.TitleEn) <= 600, "title_en", "يجب أن يكون العنوان الإنجليزي أقل من 600 حرف")
+	}
+	if m.AbstractAr != nil {
+		v.Check(utf8.RuneCountInString(markdown.Text(*m.AbstractAr)) <= 3000, "abstract_ar", "لا يمكن للملخص العربي أن يكون أكثر من 3000 حرف")
+	}
+	if m.AbstractEn != nil {
+		v.Check(utf8.RuneCountInString(markdown.Text(*m.AbstractEn)) <= 3000, "abstract_en", "لا يمكن للملخص الإنجليزي أن يكون أكثر من 3000 حرف")
+	}
+
+	v.Check(len(m.Keywords) <= maxKeywords, "keywords", "لا يمكن إضافة أكثر من 10 كلمات مفتاحية")
+	for _, keyword := range m.Keywords {
+		v.Check(utf8.RuneCountInString(keyword) <= 50, "keywords", "يجب أن تكون الكلمة المفتاحية أقل من 50 حرف")
+	}
+
+	v.Check(validator.In(m.Language, ProjectLanguages...), "language", "يجب اختيار لغة المشروع العربية أو الإنجليزية")
+}
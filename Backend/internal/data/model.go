@@ -57,9 +57,23 @@ var (
 	post_column = []string{
 		"id",
 		"description",
+		"description AS description_html",
 		"file",
 		"created_at",
 		"updated_at",
+		"author_id",
+		"(SELECT name FROM users WHERE users.id = post.author_id) AS author_name",
+		"category",
+		"pinned",
+		"requires_ack",
+		"publish_at",
+		"expires_at",
+		"audience_roles",
+		"audience_year",
+		"audience_season",
+		"audience_pre_projects",
+		"(SELECT COUNT(*) FROM post_comments c WHERE c.post_id = post.id) AS comment_count",
+		"(SELECT COALESCE(jsonb_object_agg(r.emoji, r.count), '{}') FROM (SELECT emoji, COUNT(*) AS count FROM post_reactions WHERE post_id = post.id GROUP BY emoji) r) AS reactions",
 		fmt.Sprintf("CASE WHEN NULLIF(file, '') IS NOT NULL THEN FORMAT('%s/%%s', file) ELSE NULL END AS file", Domain),
 	}
 	users_column = []string{
@@ -72,11 +86,13 @@ var (
 		"COALESCE(verification_code_expiry, '2008-01-01 00:00:00') AS verification_code_expiry",
 		"COALESCE(last_verification_code_sent, '2008-01-01 00:00:00') AS last_verification_code_sent",
 		"verified",
+		"placeholder",
+		"research_interests",
 		"created_at",
 		"updated_at",
 		fmt.Sprintf("CASE WHEN NULLIF(image, '') IS NOT NULL THEN FORMAT('%s/%%s', image) ELSE NULL END AS image", Domain),
 	}
-	preProjectJoinColumns = []string{
+	preProjectColumns = []string{
 		"pp.id",
 		"pp.name",
 		"pp.description",
@@ -90,21 +106,9 @@ var (
 		"pp.can_update",
 		"pp.created_at",
 		"pp.updated_at",
-		"u.id AS advisor_id",
-		"COALESCE(u.name, '') AS advisor_name",
-		"COALESCE(u.email, '') AS advisor_email",
-		"student.id AS student_id",
 		"COALESCE(accepted_advisor_user.id, '00000000-0000-0000-0000-000000000000') AS accepted_advisor_id",
 		"COALESCE(accepted_advisor_user.name, '') AS accepted_advisor_name",
 		"COALESCE(accepted_advisor_user.email, '') AS accepted_advisor_email",
-		"COALESCE(student.name, '') AS student_name",
-		"COALESCE(student.email, '') AS student_email",
-		"COALESCE(ar.status, 'pending') AS response_status",
-		"COALESCE(ar.created_at, pp.created_at) AS response_created_at",
-		"COALESCE(discussant.id,'00000000-0000-0000-0000-000000000000') AS discussant_id",
-		"COALESCE(discussant.name, '') AS discussant_name",
-		"COALESCE(discussant.email, '') AS discussant_email",
-		"COALESCE(ar.updated_at, pp.updated_at) AS response_updated_at",
 	}
 	// Define joins
 
@@ -113,13 +117,22 @@ var (
 )
 
 type Model struct {
-	BookDB         BookDB
-	PostDB         PostDB
-	UserDB         UserDB
-	UserRoleDB     UserRoleDB
-	ConversationDB ConversationDB
-	PreProjectDB   PreProjectDB
-	ChatDB         ChatDB
+	BookDB          BookDB
+	PostDB          PostDB
+	UserDB          UserDB
+	UserRoleDB      UserRoleDB
+	ConversationDB  ConversationDB
+	PreProjectDB    PreProjectDB
+	ChatDB          ChatDB
+	AttachmentDB    AttachmentDB
+	AnalyticsDB     AnalyticsDB
+	TrashDB         TrashDB
+	AuditDB         AuditDB
+	PostCommentDB   PostCommentDB
+	SessionDB       SessionDB
+	PermissionDB    PermissionDB
+	TwoFactorDB     TwoFactorDB
+	LoginThrottleDB LoginThrottleDB
 }
 
 func NewModels(db *sqlx.DB) Model {
@@ -131,6 +144,16 @@ func NewModels(db *sqlx.DB) Model {
 		UserRoleDB:   UserRoleDB{db},
 		ChatDB:       ChatDB{db},
 		PreProjectDB: PreProjectDB{db},
+		AttachmentDB: AttachmentDB{db},
+		AnalyticsDB:  AnalyticsDB{db},
+		TrashDB:      TrashDB{db},
+		AuditDB:      AuditDB{db},
+
+		PostCommentDB:   PostCommentDB{db},
+		SessionDB:       SessionDB{db},
+		PermissionDB:    PermissionDB{db},
+		TwoFactorDB:     TwoFactorDB{db},
+		LoginThrottleDB: LoginThrottleDB{db},
 
 		ConversationDB: ConversationDB{db},
 	}
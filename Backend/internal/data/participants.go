@@ -0,0 +1,161 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// participantRow is one student, advisor or discussant of a book or pre-project,
+// as returned by the batched participant queries below.
+type participantRow struct {
+	OwnerID uuid.UUID `db:"owner_id"`
+	Kind    string    `db:"kind"`
+	UserID  uuid.UUID `db:"user_id"`
+	Name    string    `db:"name"`
+	Email   string    `db:"email"`
+	Status  string    `db:"status"`
+}
+
+const (
+	participantStudent    = "student"
+	participantAdvisor    = "advisor"
+	participantDiscussant = "discussant"
+)
+
+// Every participant of every requested owner in a single round-trip, instead
+// of one query per row and per participant kind.
+const bookParticipantsQuery = `
+	SELECT bs.book_id AS owner_id, 'student' AS kind, u.id AS user_id, u.name, u.email, '' AS status
+	FROM book_students bs JOIN users u ON u.id = bs.student_id AND u.deleted_at IS NULL
+	WHERE bs.book_id = ANY($1)
+	UNION ALL
+	SELECT ba.book_id, 'advisor', u.id, u.name, u.email, ''
+	FROM book_advisors ba JOIN users u ON u.id = ba.advisor_id AND u.deleted_at IS NULL
+	WHERE ba.book_id = ANY($1)
+	UNION ALL
+	SELECT bd.book_id, 'discussant', u.id, u.name, u.email, ''
+	FROM book_discussants bd JOIN users u ON u.id = bd.discussant_id AND u.deleted_at IS NULL
+	WHERE bd.book_id = ANY($1)`
+
+const preProjectParticipantsQuery = `
+	SELECT pps.pre_project_id AS owner_id, 'student' AS kind, u.id AS user_id, u.name, u.email, '' AS status
+	FROM pre_project_students pps JOIN users u ON u.id = pps.student_id AND u.deleted_at IS NULL
+	WHERE pps.pre_project_id = ANY($1)
+	UNION ALL
+	SELECT ar.pre_project_id, 'advisor', u.id, u.name, u.email, COALESCE(ar.status, 'pending')
+	FROM advisor_responses ar JOIN users u ON u.id = ar.advisor_id AND u.deleted_at IS NULL
+	WHERE ar.pre_project_id = ANY($1)
+	UNION ALL
+	SELECT ppd.pre_project_id, 'discussant', u.id, u.name, u.email, ''
+	FROM pre_project_discussants ppd JOIN users u ON u.id = ppd.discussant_id AND u.deleted_at IS NULL
+	WHERE ppd.pre_project_id = ANY($1)`
+
+func loadParticipants(db *sqlx.DB, query string, ownerIDs []uuid.UUID) ([]participantRow, error) {
+	if len(ownerIDs) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, len(ownerIDs))
+	for i, id := range ownerIDs {
+		ids[i] = id.String()
+	}
+
+	var rows []participantRow
+	if err := db.Select(&rows, query, pq.Array(ids)); err != nil {
+		return nil, fmt.Errorf("failed to load participants: %w", err)
+	}
+	return rows, nil
+}
+
+// LoadBookParticipants attaches students, advisors and discussants to a page
+// of books using one query for the whole page.
+func (b *BookDB) LoadBookParticipants(books []Book) ([]BookWithDetails, error) {
+	ids := make([]uuid.UUID, len(books))
+	result := make([]BookWithDetails, len(books))
+	index := make(map[uuid.UUID]int, len(books))
+	for i, book := range books {
+		ids[i] = book.ID
+		index[book.ID] = i
+		result[i] = BookWithDetails{
+			Book:        book,
+			Discussants: []UserDetails{},
+			Advisors:    []UserDetails{},
+			Students:    []UserDetails{},
+		}
+	}
+
+	rows, err := loadParticipants(b.db, bookParticipantsQuery, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		i, ok := index[row.OwnerID]
+		if !ok {
+			continue
+		}
+		user := UserDetails{ID: row.UserID, Name: row.Name, Email: row.Email}
+		switch row.Kind {
+		case participantStudent:
+			result[i].Students = append(result[i].Students, user)
+		case participantAdvisor:
+			result[i].Advisors = append(result[i].Advisors, user)
+		case participantDiscussant:
+			result[i].Discussants = append(result[i].Discussants, user)
+		}
+	}
+
+	return result, nil
+}
+
+// loadPreProjectParticipants fills the advisors, students and discussants of
+// the given pre-projects using one query for all of them.
+func (p *PreProjectDB) loadPreProjectParticipants(preProjects []PreProjectWithAdvisorDetails) error {
+	ids := make([]uuid.UUID, len(preProjects))
+	index := make(map[uuid.UUID]int, len(preProjects))
+	for i := range preProjects {
+		ids[i] = preProjects[i].PreProject.ID
+		index[ids[i]] = i
+		preProjects[i].Advisors = []AdvisorResponseDetails{}
+		preProjects[i].Students = []StudentDetails{}
+		preProjects[i].Discussants = []DiscussantDetails{}
+	}
+
+	rows, err := loadParticipants(p.db, preProjectParticipantsQuery, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		i, ok := index[row.OwnerID]
+		if !ok {
+			continue
+		}
+		switch row.Kind {
+		case participantStudent:
+			preProjects[i].Students = append(preProjects[i].Students, StudentDetails{
+				StudentID:    row.UserID,
+				StudentName:  row.Name,
+				StudentEmail: row.Email,
+			})
+		case participantAdvisor:
+			preProjects[i].Advisors = append(preProjects[i].Advisors, AdvisorResponseDetails{
+				AdvisorID:    row.UserID,
+				AdvisorName:  row.Name,
+				AdvisorEmail: row.Email,
+				Status:       row.Status,
+			})
+		case participantDiscussant:
+			preProjects[i].Discussants = append(preProjects[i].Discussants, DiscussantDetails{
+				DiscussantID:    row.UserID,
+				DiscussantName:  row.Name,
+				DiscussantEmail: row.Email,
+			})
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"project/utils/validator"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Permissions checked by the handlers. Which roles hold them is data, kept
+// in role_permissions and edited by whoever holds PermRolesManage.
+const (
+	PermBooksManage               = "books.manage"
+	PermBooksExport               = "books.export"
+	PermBooksViewRestricted       = "books.view_restricted"
+	PermAnalyticsView             = "analytics.view"
+	PermAnalyticsViewAll          = "analytics.view_all"
+	PermPostsPublish              = "posts.publish"
+	PermPostsModerate             = "posts.moderate"
+	PermUsersManage               = "users.manage"
+	PermUsersListStudents         = "users.list_students"
+	PermRolesManage               = "roles.manage"
+	PermAuditView                 = "audit.view"
+	PermTrashManage               = "trash.manage"
+	PermPreProjectsCreate         = "preprojects.create"
+	PermPreProjectsManage         = "preprojects.manage"
+	PermPreProjectsSkipSimilarity = "preprojects.skip_similarity"
+	PermConversationsUse          = "conversations.use"
+)
+
+// ErrDuplicatedRoleName is returned when creating a role whose name is taken.
+var ErrDuplicatedRoleName = errors.New("يوجد دور بهذا الاسم بالفعل")
+
+var roleNameRX = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+type Permission struct {
+	Name        string `db:"name" json:"name"`
+	Description string `db:"description" json:"description"`
+}
+
+type Role struct {
+	ID          int            `db:"id" json:"id"`
+	Name        string         `db:"name" json:"name"`
+	Permissions pq.StringArray `db:"permissions" json:"permissions"`
+
+	// RequireTwoFactor makes holders of the role sign in with a second factor.
+	RequireTwoFactor bool `db:"require_two_factor" json:"require_two_factor"`
+}
+
+const roleColumns = `roles.id, roles.name, roles.require_two_factor, ARRAY(
+	SELECT permission FROM role_permissions
+	WHERE role_permissions.role_id = roles.id ORDER BY permission) AS permissions`
+
+type PermissionDB struct {
+	db *sqlx.DB
+}
+
+func ValidateRole(v *validator.Validator, role *Role, known []Permission) {
+	v.Check(role.Name != "", "name", "يجب إدخال اسم الدور")
+	v.Check(utf8.RuneCountInString(role.Name) <= 50, "name", "يجب أن يكون اسم الدور أقل من 50 حرف")
+	v.Check(role.Name == "" || validator.Matches(role.Name, roleNameRX), "name", "يجب أن يتكون اسم الدور من أحرف إنجليزية صغيرة وأرقام و _")
+	ValidateRolePermissions(v, role.Permissions, known)
+}
+
+func ValidateRolePermissions(v *validator.Validator, permissions []string, known []Permission) {
+	names := make([]string, len(known))
+	for i, permission := range known {
+		names[i] = permission.Name
+	}
+	for _, permission := range permissions {
+		v.Check(validator.In(permission, names...), "permissions", "صلاحية غير معروفة: "+permission)
+	}
+}
+
+func (p *PermissionDB) ListPermissions() ([]Permission, error) {
+	permissions := []Permission{}
+	if err := p.db.Select(&permissions, "SELECT name, description FROM permissions ORDER BY name"); err != nil {
+		return nil, fmt.Errorf("failed to list permissions: %w", err)
+	}
+	return permissions, nil
+}
+
+// ListRoles returns every role with its permissions.
+func (p *PermissionDB) ListRoles() ([]Role, error) {
+	roles := []Role{}
+	if err := p.db.Select(&roles, "SELECT "+roleColumns+" FROM roles ORDER BY roles.id"); err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	return roles, nil
+}
+
+func (p *PermissionDB) GetRole(roleID int) (*Role, error) {
+	var role Role
+	err := p.db.Get(&role, "SELECT "+roleColumns+" FROM roles WHERE roles.id = $1", roleID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get role: %w", err)
+	}
+	return &role, nil
+}
+
+// InsertRole creates the role with its permissions.
+func (p *PermissionDB) InsertRole(role *Role) error {
+	tx, err := p.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.Get(&role.ID, "INSERT INTO roles (name) VALUES ($1) RETURNING id", role.Name); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrDuplicatedRoleName
+		}
+		return fmt.Errorf("failed to insert role: %w", err)
+	}
+	if err := setRolePermissions(tx, role.ID, role.Permissions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetRolePermissions replaces the permissions of the role.
+func (p *PermissionDB) SetRolePermissions(roleID int, permissions []string) error {
+	tx, err := p.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := tx.Get(&id, "SELECT id FROM roles WHERE id = $1 FOR UPDATE", roleID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return fmt.Errorf("failed to get role: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM role_permissions WHERE role_id = $1", roleID); err != nil {
+		return fmt.Errorf("failed to clear role permissions: %w", err)
+	}
+	if err := setRolePermissions(tx, roleID, permissions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func setRolePermissions(tx *sqlx.Tx, roleID int, permissions []string) error {
+	_, err := tx.Exec(`
+		INSERT INTO role_permissions (role_id, permission)
+		SELECT $1, unnest($2::text[]) ON CONFLICT DO NOTHING`, roleID, pq.Array(permissions))
+	if err != nil {
+		return fmt.Errorf("failed to set role permissions: %w", err)
+	}
+	return nil
+}
+
+// SetRoleTwoFactor sets whether holders of the role must sign in with a
+// second factor.
+func (p *PermissionDB) SetRoleTwoFactor(roleID int, required bool) error {
+	result, err := p.db.Exec("UPDATE roles SET require_two_factor = $2 WHERE id = $1", roleID, required)
+	if err != nil {
+		return fmt.Errorf("failed to set two-factor policy: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// RolePermissions maps each role name to the permissions it holds.
+func (p *PermissionDB) RolePermissions() (map[string][]string, error) {
+	roles, err := p.ListRoles()
+	if err != nil {
+		return nil, err
+	}
+	byRole := make(map[string][]string, len(roles))
+	for _, role := range roles {
+		byRole[role.Name] = role.Permissions
+	}
+	return byRole, nil
+}
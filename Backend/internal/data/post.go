@@ -2,26 +2,129 @@ package data
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"net/url"
-	"os"
 	"project/utils"
+	"project/utils/markdown"
 	"project/utils/validator"
-	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Masterminds/squirrel"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type Post struct {
 	ID          uuid.UUID `db:"id" json:"id"`
 	Description string    `db:"description" json:"description"`
-	File        *string   `db:"file" json:"file"`
-	CreatedAt   time.Time `db:"created_at" json:"created_at"`
-	UpdatedAt   time.Time `db:"updated_at" json:"updated_at"`
+	// DescriptionHTML is the description rendered from Markdown.
+	DescriptionHTML RenderedMarkdown `db:"description_html" json:"description_html"`
+	File            *string          `db:"file" json:"file"`
+	AuthorID        *uuid.UUID       `db:"author_id" json:"author_id"`
+	AuthorName      *string          `db:"author_name" json:"author_name,omitempty"`
+	Category        string           `db:"category" json:"category"`
+	Pinned          bool             `db:"pinned" json:"pinned"`
+	RequiresAck     bool             `db:"requires_ack" json:"requires_ack"`
+	PublishAt       time.Time        `db:"publish_at" json:"publish_at"`
+	ExpiresAt       *time.Time       `db:"expires_at" json:"expires_at,omitempty"`
+	CreatedAt       time.Time        `db:"created_at" json:"created_at"`
+	UpdatedAt       time.Time        `db:"updated_at" json:"updated_at"`
+
+	// The audience; a post with none set is public.
+	AudienceRoles       pq.StringArray `db:"audience_roles" json:"audience_roles"`
+	AudienceYear        *int           `db:"audience_year" json:"audience_year,omitempty"`
+	AudienceSeason      *string        `db:"audience_season" json:"audience_season,omitempty"`
+	AudiencePreProjects UUIDArray      `db:"audience_pre_projects" json:"audience_pre_projects"`
+
+	// Comment count and reaction counts by emoji, read-only.
+	CommentCount int             `db:"comment_count" json:"comment_count"`
+	Reactions    json.RawMessage `db:"reactions" json:"reactions"`
+}
+
+// Post categories.
+const (
+	PostAnnouncement = "announcement"
+	PostDeadline     = "deadline"
+	PostOpportunity  = "opportunity"
+	PostEvent        = "event"
+)
+
+var PostCategories = []string{PostAnnouncement, PostDeadline, PostOpportunity, PostEvent}
+
+// Which posts a listing shows. Everyone sees live posts, those published and
+// not yet expired; the rest are for staff.
+const (
+	PostStatusLive      = "live"
+	PostStatusScheduled = "scheduled"
+	PostStatusExpired   = "expired"
+	PostStatusAll       = "all"
+)
+
+var PostStatuses = []string{PostStatusLive, PostStatusScheduled, PostStatusExpired, PostStatusAll}
+
+// audienceRoles never passes NULL to the NOT NULL column.
+func (post *Post) audienceRoles() pq.StringArray {
+	if post.AudienceRoles == nil {
+		return pq.StringArray{}
+	}
+	return post.AudienceRoles
+}
+
+// audiencePreProjects never passes NULL to the NOT NULL column.
+func (post *Post) audiencePreProjects() interface{} {
+	if post.AudiencePreProjects == nil {
+		return pq.Array([]uuid.UUID{})
+	}
+	return pq.Array([]uuid.UUID(post.AudiencePreProjects))
+}
+
+// IsLive reports whether the post is published and not yet expired at now.
+func (post *Post) IsLive(now time.Time) bool {
+	return !post.PublishAt.After(now) && (post.ExpiresAt == nil || post.ExpiresAt.After(now))
+}
+
+// PostFilter narrows a post listing. Values are validated by the caller.
+// Without a Viewer only public posts are listed, unless AllAudiences is set.
+type PostFilter struct {
+	Status       string
+	Category     string
+	AuthorID     *uuid.UUID
+	Viewer       *PostViewer
+	AllAudiences bool
+	// Unpinned leaves out the pinned posts, for listings that show them
+	// apart.
+	Unpinned bool
+}
+
+func (f PostFilter) conditions() squirrel.And {
+	filters := squirrel.And{squirrel.Expr("deleted_at IS NULL")}
+	if !f.AllAudiences {
+		filters = append(filters, squirrel.Expr(f.Viewer.visibleSQL()))
+	}
+	switch f.Status {
+	case PostStatusScheduled:
+		filters = append(filters, squirrel.Expr("publish_at > NOW()"))
+	case PostStatusExpired:
+		filters = append(filters, squirrel.Expr("expires_at <= NOW()"))
+	case PostStatusAll:
+	default:
+		filters = append(filters, squirrel.Expr("publish_at <= NOW()"), squirrel.Expr("(expires_at IS NULL OR expires_at > NOW())"))
+	}
+	if f.Category != "" {
+		filters = append(filters, squirrel.Eq{"category": f.Category})
+	}
+	if f.AuthorID != nil {
+		filters = append(filters, squirrel.Eq{"author_id": *f.AuthorID})
+	}
+	if f.Unpinned {
+		filters = append(filters, squirrel.Expr("NOT pinned"))
+	}
+	return filters
 }
 
 type PostDB struct {
@@ -29,6 +132,11 @@ type PostDB struct {
 }
 
 func ValidatePost(v *validator.Validator, post *Post, fields ...string) {
+	v.Check(validator.In(post.Category, PostCategories...), "category", "تصنيف المنشور غير صالح")
+	v.Check(post.ExpiresAt == nil || post.ExpiresAt.After(post.PublishAt), "expires_at", "يجب أن يكون تاريخ انتهاء المنشور بعد تاريخ نشره")
+	v.Check(post.AudienceYear == nil || *post.AudienceYear > 0, "audience_year", "سنة جمهور المنشور غير صالحة")
+	v.Check(post.AudienceSeason == nil || validator.In(*post.AudienceSeason, "spring", "fall"), "audience_season", "يجب اختيار موسم ربيع أو خريف")
+
 	// Check if at least one of the fields is provided
 	if post.File != nil && post.Description == "" {
 		return
@@ -39,17 +147,27 @@ func ValidatePost(v *validator.Validator, post *Post, fields ...string) {
 		return
 	}
 
-	// If description is provided, validate its length
+	// If description is provided, validate the length of its rendered text
 	if post.Description != "" {
-		v.Check(post.Description != "", "description", "يجب اضافة وصف او ملف للمنشور")
-		v.Check(len(post.Description) >= 20, "description", "يجب ان يكون وصف المنشور على الاقل من 20 حرفاً")
-		v.Check(len(post.Description) <= 4000, "description", "يجب ان يكون وصف المنشور على الاكثر 4000 حرفا")
+		description := utf8.RuneCountInString(markdown.Text(post.Description))
+		v.Check(description > 0, "description", "يجب اضافة وصف او ملف للمنشور")
+		v.Check(description >= 20, "description", "يجب ان يكون وصف المنشور على الاقل من 20 حرفاً")
+		v.Check(description <= 4000, "description", "يجب ان يكون وصف المنشور على الاكثر 4000 حرفا")
 	}
 }
+
+// InsertPost stores a post by post.AuthorID. A zero PublishAt publishes it
+// right away.
 func (p *PostDB) InsertPost(post *Post) error {
+	if post.PublishAt.IsZero() {
+		post.PublishAt = time.Now()
+	}
+	post.DescriptionHTML = renderMarkdown(&post.Description)
 	query, args, err := QB.Insert("post").
-		Columns("description", "file").
-		Values(post.Description, post.File).
+		Columns("description", "file", "author_id", "category", "pinned", "requires_ack", "publish_at", "expires_at",
+			"audience_roles", "audience_year", "audience_season", "audience_pre_projects").
+		Values(post.Description, post.File, post.AuthorID, post.Category, post.Pinned, post.RequiresAck, post.PublishAt, post.ExpiresAt,
+			post.audienceRoles(), post.AudienceYear, post.AudienceSeason, post.audiencePreProjects()).
 		Suffix("RETURNING id, created_at, updated_at").
 		ToSql()
 	if err != nil {
@@ -66,7 +184,7 @@ func (p *PostDB) InsertPost(post *Post) error {
 func (p *PostDB) GetPost(postID uuid.UUID) (*Post, error) {
 	var post Post
 	query, args, err := QB.Select(post_column...).
-		From("post").Where(squirrel.Eq{"id": postID}).ToSql()
+		From("post").Where(squirrel.Eq{"id": postID}).Where("deleted_at IS NULL").ToSql()
 	if err != nil {
 		return nil, err
 	}
@@ -83,13 +201,25 @@ func (p *PostDB) GetPost(postID uuid.UUID) (*Post, error) {
 }
 
 func (p *PostDB) UpdatePost(post *Post) error {
+	post.DescriptionHTML = renderMarkdown(&post.Description)
 	query, args, err := QB.Update("post").
 		SetMap(map[string]interface{}{
-			"description": post.Description,
-			"file":        post.File,
-			"updated_at":  time.Now(),
+			"description":  post.Description,
+			"file":         post.File,
+			"category":     post.Category,
+			"pinned":       post.Pinned,
+			"requires_ack": post.RequiresAck,
+			"publish_at":   post.PublishAt,
+			"expires_at":   post.ExpiresAt,
+			"updated_at":   time.Now(),
+
+			"audience_roles":        post.audienceRoles(),
+			"audience_year":         post.AudienceYear,
+			"audience_season":       post.AudienceSeason,
+			"audience_pre_projects": post.audiencePreProjects(),
 		}).
 		Where(squirrel.Eq{"id": post.ID}).
+		Where("deleted_at IS NULL").
 		ToSql()
 	if err != nil {
 		return err
@@ -103,46 +233,97 @@ func (p *PostDB) UpdatePost(post *Post) error {
 	return nil
 }
 
-func (p *PostDB) DeletePost(postID uuid.UUID) error {
-	post, err := p.GetPost(postID)
+// DeletePost moves the post to the trash. Its file is kept until the post is
+// purged.
+func (p *PostDB) DeletePost(postID, deletedBy uuid.UUID) error {
+	query, args, err := QB.Update("post").
+		Set("deleted_at", time.Now()).
+		Set("deleted_by", deletedBy).
+		Where(squirrel.Eq{"id": postID}).
+		Where("deleted_at IS NULL").
+		ToSql()
 	if err != nil {
 		return err
 	}
 
-	query, args, err := QB.Delete("post").Where(squirrel.Eq{"id": postID}).ToSql()
+	result, err := p.db.Exec(query, args...)
 	if err != nil {
-		return err
+		return fmt.Errorf("error while deleting post: %v", err)
 	}
-
-	_, err = p.db.Exec(query, args...)
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
-
-	if post.File != nil {
-		filePath := strings.TrimPrefix(*post.File, Domain+"/")
-		if _, err := os.Stat(filePath); err == nil {
-			err = utils.DeleteFile(filePath)
-			if err != nil {
-				return fmt.Errorf("failed to delete file %s: %w", filePath, err)
-			}
-		} else if !os.IsNotExist(err) {
-			return fmt.Errorf("error checking file %s: %w", filePath, err)
-		}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
 	}
 
 	return nil
 }
 
-func (p *PostDB) ListPosts(queryParams url.Values) ([]Post, *utils.Meta, error) {
+// ListPosts pages through the posts matching the filter, most recently
+// published first unless ?sort= says otherwise.
+func (p *PostDB) ListPosts(filter PostFilter, queryParams url.Values) ([]Post, *utils.Meta, error) {
 	var posts []Post
 	searchCols := []string{"description"}
 	table := "post"
 
-	meta, err := utils.BuildQuery(&posts, table, nil, post_column, searchCols, queryParams, nil)
+	// The default goes on a copy, so the caller's values are left as they were.
+	queryParams = maps.Clone(queryParams)
+	if queryParams.Get("sort") == "" {
+		queryParams.Set("sort", "-publish_at")
+	}
+
+	meta, err := utils.BuildQuery(&posts, table, nil, post_column, searchCols, queryParams, filter.conditions())
 	if err != nil {
 		return nil, nil, fmt.Errorf("error building query: %v", err)
 	}
 
 	return posts, meta, nil
 }
+
+var postCursor = utils.CursorConfig{
+	IDColumn: "id",
+	IDField:  "id",
+	SortColumns: map[string]string{
+		"created_at": "created_at",
+		"updated_at": "updated_at",
+		"publish_at": "publish_at",
+	},
+	DefaultSort: "-publish_at",
+}
+
+// ListPostsCursor is ListPosts with keyset pagination.
+func (p *PostDB) ListPostsCursor(filter PostFilter, queryParams url.Values) ([]Post, *utils.CursorMeta, error) {
+	var posts []Post
+	searchCols := []string{"description"}
+	table := "post"
+
+	meta, err := utils.BuildCursorQuery(&posts, table, nil, post_column, searchCols, queryParams, filter.conditions(), postCursor)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error building query: %w", err)
+	}
+
+	return posts, meta, nil
+}
+
+// ListPinnedPosts returns the pinned posts matching the filter, most recently
+// published first. Listings show them above the page of posts.
+func (p *PostDB) ListPinnedPosts(filter PostFilter) ([]Post, error) {
+	filter.Unpinned = false
+	query, args, err := QB.Select(post_column...).
+		From("post").
+		Where("pinned").
+		Where(filter.conditions()).
+		OrderBy("publish_at DESC", "id DESC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	posts := []Post{}
+	if err := p.db.Select(&posts, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to query pinned posts: %w", err)
+	}
+	return posts, nil
+}
@@ -0,0 +1,100 @@
+package data
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PostAckUser is a user a post reaches, with when they acknowledged it.
+type PostAckUser struct {
+	ID             uuid.UUID  `db:"id" json:"id"`
+	Name           string     `db:"name" json:"name"`
+	Email          string     `db:"email" json:"email"`
+	AcknowledgedAt *time.Time `db:"acknowledged_at" json:"acknowledged_at,omitempty"`
+}
+
+// PostAckReport splits the users a post reaches by whether they acknowledged
+// it.
+type PostAckReport struct {
+	Total        int           `json:"total"`
+	Acknowledged []PostAckUser `json:"acknowledged"`
+	Pending      []PostAckUser `json:"pending"`
+	RemindedAt   *time.Time    `json:"reminded_at,omitempty"`
+}
+
+// userRolesSQL is the array of role names of the user u.
+const userRolesSQL = `(SELECT COALESCE(array_agg(r.name::text), '{}') FROM user_roles ur
+	JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = u.id)`
+
+// Acknowledge records that the user has read the post. Acknowledging twice
+// keeps the first time.
+func (p *PostDB) Acknowledge(postID, userID uuid.UUID) (time.Time, error) {
+	var at time.Time
+	err := p.db.Get(&at, `
+		INSERT INTO post_acknowledgements (post_id, user_id) VALUES ($1, $2)
+		ON CONFLICT (post_id, user_id) DO UPDATE SET acknowledged_at = post_acknowledgements.acknowledged_at
+		RETURNING acknowledged_at`, postID, userID)
+	if err != nil {
+		return at, fmt.Errorf("failed to acknowledge post: %w", err)
+	}
+	return at, nil
+}
+
+// AcknowledgedAt returns when the user acknowledged the post, or nil.
+func (p *PostDB) AcknowledgedAt(postID, userID uuid.UUID) (*time.Time, error) {
+	var at []time.Time
+	err := p.db.Select(&at, "SELECT acknowledged_at FROM post_acknowledgements WHERE post_id = $1 AND user_id = $2", postID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get acknowledgement: %w", err)
+	}
+	if len(at) == 0 {
+		return nil, nil
+	}
+	return &at[0], nil
+}
+
+// AckReport lists the users in the post's audience, other than its author,
+// with whether they acknowledged it. A public post reaches every verified
+// user; imported placeholder accounts cannot be reached and are left out.
+func (p *PostDB) AckReport(postID uuid.UUID) (*PostAckReport, error) {
+	users := []PostAckUser{}
+	query := fmt.Sprintf(`
+		SELECT u.id, u.name, u.email, a.acknowledged_at
+		FROM post CROSS JOIN users u
+		LEFT JOIN post_acknowledgements a ON a.post_id = post.id AND a.user_id = u.id
+		WHERE post.id = $1 AND u.deleted_at IS NULL AND NOT u.placeholder AND u.verified
+			AND u.id IS DISTINCT FROM post.author_id
+			AND %s
+		ORDER BY u.name, u.id`, postAudienceSQL("u.id", userRolesSQL))
+	if err := p.db.Select(&users, query, postID); err != nil {
+		return nil, fmt.Errorf("failed to query acknowledgements: %w", err)
+	}
+
+	report := &PostAckReport{
+		Total:        len(users),
+		Acknowledged: []PostAckUser{},
+		Pending:      []PostAckUser{},
+	}
+	for _, user := range users {
+		if user.AcknowledgedAt != nil {
+			report.Acknowledged = append(report.Acknowledged, user)
+		} else {
+			report.Pending = append(report.Pending, user)
+		}
+	}
+
+	if err := p.db.Get(&report.RemindedAt, "SELECT ack_reminded_at FROM post WHERE id = $1", postID); err != nil {
+		return nil, fmt.Errorf("failed to get reminder time: %w", err)
+	}
+	return report, nil
+}
+
+// MarkAckReminded records that the pending users were reminded at at.
+func (p *PostDB) MarkAckReminded(postID uuid.UUID, at time.Time) error {
+	if _, err := p.db.Exec("UPDATE post SET ack_reminded_at = $1 WHERE id = $2", at, postID); err != nil {
+		return fmt.Errorf("failed to record reminder: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// PostViewer is who a post listing is for: a signed-in user with the role
+// names from their token.
+type PostViewer struct {
+	ID    uuid.UUID
+	Roles []string
+}
+
+// postPublicSQL holds for posts without an audience.
+const postPublicSQL = `(cardinality(post.audience_roles) = 0
+	AND post.audience_year IS NULL AND post.audience_season IS NULL
+	AND cardinality(post.audience_pre_projects) = 0)`
+
+// postAudienceSQL holds when the user userExpr, holding the role names
+// rolesExpr, is in the audience of the post. Both are SQL expressions, so the
+// condition serves listings for one viewer as well as lookups of every user a
+// post reaches.
+func postAudienceSQL(userExpr, rolesExpr string) string {
+	return fmt.Sprintf(`((cardinality(post.audience_roles) = 0 OR post.audience_roles && %[2]s)
+	AND (post.audience_year IS NULL AND post.audience_season IS NULL OR EXISTS (
+		SELECT 1 FROM pre_project_members m JOIN pre_project pp ON pp.id = m.pre_project_id
+		WHERE m.user_id = %[1]s
+			AND (post.audience_year IS NULL OR pp.year = post.audience_year)
+			AND (post.audience_season IS NULL OR pp.season = post.audience_season)))
+	AND (cardinality(post.audience_pre_projects) = 0 OR EXISTS (
+		SELECT 1 FROM pre_project_members m
+		WHERE m.user_id = %[1]s AND m.pre_project_id = ANY(post.audience_pre_projects))))`, userExpr, rolesExpr)
+}
+
+// visibleSQL holds for the posts the viewer may read: public ones without a
+// viewer, else their own and those they are in the audience of.
+func (viewer *PostViewer) visibleSQL() string {
+	if viewer == nil {
+		return postPublicSQL
+	}
+
+	roles := "'{}'::text[]"
+	if len(viewer.Roles) > 0 {
+		quoted := make([]string, len(viewer.Roles))
+		for i, role := range viewer.Roles {
+			quoted[i] = pq.QuoteLiteral(role)
+		}
+		roles = "ARRAY[" + strings.Join(quoted, ", ") + "]::text[]"
+	}
+	user := pq.QuoteLiteral(viewer.ID.String()) + "::uuid"
+
+	return fmt.Sprintf("(post.author_id = %s OR %s)", user, postAudienceSQL(user, roles))
+}
+
+// CanViewPost reports whether the viewer, nil for anonymous, may read the
+// post. It does not check whether the post is live.
+func (p *PostDB) CanViewPost(postID uuid.UUID, viewer *PostViewer) (bool, error) {
+	var ok bool
+	query := fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM post WHERE id = $1 AND deleted_at IS NULL AND %s)", viewer.visibleSQL())
+	if err := p.db.Get(&ok, query, postID); err != nil {
+		return false, fmt.Errorf("failed to check post audience: %w", err)
+	}
+	return ok, nil
+}
+
+// UnknownPreProjects returns the ids that name no pre-project, so a post
+// cannot be aimed at one that does not exist.
+func (p *PostDB) UnknownPreProjects(ids []uuid.UUID) ([]uuid.UUID, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var unknown []uuid.UUID
+	err := p.db.Select(&unknown, `
+		SELECT t.id FROM unnest($1::uuid[]) AS t(id)
+		WHERE NOT EXISTS (SELECT 1 FROM pre_project pp WHERE pp.id = t.id AND pp.deleted_at IS NULL)`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check pre-projects: %w", err)
+	}
+	return unknown, nil
+}
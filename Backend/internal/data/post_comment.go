@@ -0,0 +1,195 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"project/utils"
+	"project/utils/validator"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// PostComment is a comment on a post, or a reply to one when ParentID is set.
+// Replies cannot be replied to.
+type PostComment struct {
+	ID         uuid.UUID  `db:"id" json:"id"`
+	PostID     uuid.UUID  `db:"post_id" json:"post_id"`
+	ParentID   *uuid.UUID `db:"parent_id" json:"parent_id,omitempty"`
+	AuthorID   *uuid.UUID `db:"author_id" json:"author_id"`
+	AuthorName *string    `db:"author_name" json:"author_name,omitempty"`
+	Body       string     `db:"body" json:"body"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt  time.Time  `db:"updated_at" json:"updated_at"`
+
+	Replies []PostComment `db:"-" json:"replies,omitempty"`
+}
+
+// PostEmojis are the reactions a post accepts.
+var PostEmojis = []string{"👍", "❤️", "😂", "😮", "😢", "🎉", "🙏", "👀"}
+
+var commentColumns = []string{
+	"id",
+	"post_id",
+	"parent_id",
+	"author_id",
+	"(SELECT name FROM users WHERE users.id = post_comments.author_id) AS author_name",
+	"body",
+	"created_at",
+	"updated_at",
+}
+
+type PostCommentDB struct {
+	db *sqlx.DB
+}
+
+func ValidatePostComment(v *validator.Validator, comment *PostComment) {
+	v.Check(comment.Body != "", "body", "يجب كتابة نص التعليق")
+	v.Check(utf8.RuneCountInString(comment.Body) <= 2000, "body", "يجب أن يكون التعليق أقل من 2000 حرف")
+}
+
+func (c *PostCommentDB) InsertComment(comment *PostComment) error {
+	query, args, err := QB.Insert("post_comments").
+		Columns("post_id", "parent_id", "author_id", "body").
+		Values(comment.PostID, comment.ParentID, comment.AuthorID, comment.Body).
+		Suffix("RETURNING id, created_at, updated_at").
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	if err := c.db.QueryRowx(query, args...).StructScan(comment); err != nil {
+		return fmt.Errorf("error while inserting comment: %w", err)
+	}
+	return nil
+}
+
+func (c *PostCommentDB) GetComment(commentID uuid.UUID) (*PostComment, error) {
+	var comment PostComment
+	query, args, err := QB.Select(commentColumns...).
+		From("post_comments").Where(squirrel.Eq{"id": commentID}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.db.Get(&comment, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &comment, nil
+}
+
+func (c *PostCommentDB) UpdateComment(comment *PostComment) error {
+	comment.UpdatedAt = time.Now()
+	query, args, err := QB.Update("post_comments").
+		Set("body", comment.Body).
+		Set("updated_at", comment.UpdatedAt).
+		Where(squirrel.Eq{"id": comment.ID}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.db.Exec(query, args...); err != nil {
+		return fmt.Errorf("error while updating comment: %w", err)
+	}
+	return nil
+}
+
+// DeleteComment removes the comment and its replies for good; comments are
+// not kept in the trash.
+func (c *PostCommentDB) DeleteComment(commentID uuid.UUID) error {
+	result, err := c.db.Exec("DELETE FROM post_comments WHERE id = $1", commentID)
+	if err != nil {
+		return fmt.Errorf("error while deleting comment: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListComments pages through the top-level comments of the post, oldest
+// first, each with all of its replies.
+func (c *PostCommentDB) ListComments(postID uuid.UUID, queryParams url.Values) ([]PostComment, *utils.Meta, error) {
+	var comments []PostComment
+	searchCols := []string{"body"}
+	filters := []squirrel.Sqlizer{squirrel.Eq{"post_id": postID}, squirrel.Expr("parent_id IS NULL")}
+
+	if queryParams.Get("sort") == "" {
+		queryParams.Set("sort", "created_at")
+	}
+
+	meta, err := utils.BuildQuery(&comments, "post_comments", nil, commentColumns, searchCols, queryParams, filters)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error building query: %w", err)
+	}
+	if len(comments) == 0 {
+		return comments, meta, nil
+	}
+
+	ids := make([]uuid.UUID, len(comments))
+	index := make(map[uuid.UUID]int, len(comments))
+	for i, comment := range comments {
+		ids[i] = comment.ID
+		index[comment.ID] = i
+	}
+
+	query, args, err := QB.Select(commentColumns...).
+		From("post_comments").
+		Where("parent_id = ANY(?)", pq.Array(ids)).
+		OrderBy("created_at", "id").
+		ToSql()
+	if err != nil {
+		return nil, nil, err
+	}
+	var replies []PostComment
+	if err := c.db.Select(&replies, query, args...); err != nil {
+		return nil, nil, fmt.Errorf("failed to query replies: %w", err)
+	}
+	for _, reply := range replies {
+		i := index[*reply.ParentID]
+		comments[i].Replies = append(comments[i].Replies, reply)
+	}
+
+	return comments, meta, nil
+}
+
+// AddReaction records the user's emoji on the post. Reacting twice with the
+// same emoji is a no-op.
+func (c *PostCommentDB) AddReaction(postID, userID uuid.UUID, emoji string) error {
+	_, err := c.db.Exec(`
+		INSERT INTO post_reactions (post_id, user_id, emoji) VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`, postID, userID, emoji)
+	if err != nil {
+		return fmt.Errorf("failed to add reaction: %w", err)
+	}
+	return nil
+}
+
+func (c *PostCommentDB) RemoveReaction(postID, userID uuid.UUID, emoji string) error {
+	result, err := c.db.Exec("DELETE FROM post_reactions WHERE post_id = $1 AND user_id = $2 AND emoji = $3", postID, userID, emoji)
+	if err != nil {
+		return fmt.Errorf("failed to remove reaction: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -4,11 +4,12 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
 	"net/url"
 	"project/utils"
+	"project/utils/markdown"
 	"project/utils/validator"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Masterminds/squirrel"
 	"github.com/google/uuid"
@@ -33,17 +34,33 @@ type PreProject struct {
 	Degree          *int       `db:"degree" json:"degree,omitempty"`
 	CreatedAt       time.Time  `db:"created_at" json:"created_at"`
 	UpdatedAt       time.Time  `db:"updated_at" json:"updated_at"`
+
+	ProjectMetadata
+}
+
+// CompleteMetadata is the pre-project counterpart of Book.CompleteMetadata.
+func (preProject *PreProject) CompleteMetadata() {
+	preProject.ProjectMetadata.complete(&preProject.Name, &preProject.Description)
+}
+
+// Localize is the pre-project counterpart of Book.Localize.
+func (preProject *PreProject) Localize(lang string) {
+	preProject.ProjectMetadata.localize(lang, &preProject.Name, &preProject.Description)
 }
 
 func ValidatePreProject(v *validator.Validator, preProject *PreProject, students, advisors []uuid.UUID) {
 	v.Check(preProject.Name != "", "name", "اسم المشروع مطلوب")
-	v.Check(len(preProject.Name) >= 3, "name", "يجب أن يكون اسم المشروع على الأقل 3 أحرف")
-	v.Check(len(preProject.Name) <= 600, "name", "يجب أن يكون اسم المشروع أقل من 600 حرف")
+	v.Check(utf8.RuneCountInString(preProject.Name) >= 3, "name", "يجب أن يكون اسم المشروع على الأقل 3 أحرف")
+	v.Check(utf8.RuneCountInString(preProject.Name) <= 600, "name", "يجب أن يكون اسم المشروع أقل من 600 حرف")
 
 	v.Check(*preProject.Description != "", "name", "اسم المشروع مطلوب")
 
-	v.Check(len(*preProject.Description) >= 60, "description", "يجب أن يكون وصف المشروع على الأقل 10 أحرف")
-	v.Check(len(*preProject.Description) <= 3000, "description", "لا يمكن لوصف المشروع أن يكون أكثر من 3000 حرف")
+	// The description is Markdown; its length is that of the rendered text.
+	description := utf8.RuneCountInString(markdown.Text(*preProject.Description))
+	v.Check(description >= 60, "description", "يجب أن يكون وصف المشروع على الأقل 60 أحرف")
+	v.Check(description <= 3000, "description", "لا يمكن لوصف المشروع أن يكون أكثر من 3000 حرف")
+	ValidateProjectMetadata(v, preProject.Name, &preProject.ProjectMetadata)
+
 	v.Check(preProject.Season != "", "season", "الموسم مطلوب")
 	v.Check(preProject.Season == "spring" || preProject.Season == "fall", "season", "يجب اختيار موسم ربيع أو خريف")
 	v.Check(preProject.Year >= time.Now().Year(), "year", "يجب ان تكون سنة المشروع اما السنة الحاليه او قادمة")
@@ -94,7 +111,8 @@ func (p *PreProjectDB) InsertPreProject(preProject *PreProject, studentIDs []uui
 	}
 	defer tx.Rollback()
 	query, args, err := QB.Insert("pre_project").
-		Columns("name, description, file, file_description, project_owner, year, season, can_update").
+		Columns("name, description, file, file_description, project_owner, year, season, can_update",
+			"title_ar", "title_en", "abstract_ar", "abstract_en", "keywords", "language").
 		Values(
 			preProject.Name,
 			preProject.Description,
@@ -104,6 +122,12 @@ func (p *PreProjectDB) InsertPreProject(preProject *PreProject, studentIDs []uui
 			preProject.Year,
 			preProject.Season,
 			true,
+			preProject.TitleAr,
+			preProject.TitleEn,
+			preProject.AbstractAr,
+			preProject.AbstractEn,
+			preProject.Keywords,
+			preProject.Language,
 		).
 		Suffix("RETURNING id, created_at, updated_at").
 		ToSql()
@@ -178,116 +202,46 @@ type AdvisorResponseDetails struct {
 
 func (p *PreProjectDB) GetPreProjectWithAdvisorDetails(preProjectID uuid.UUID) (*PreProjectWithAdvisorDetails, error) {
 	query, args, err := QB.Select(
-		preProjectJoinColumns...,
+		preProjectColumns...,
 	).
+		Columns(metadataColumns("pp")...).
 		From("pre_project pp").
-		LeftJoin("advisor_responses ar ON ar.pre_project_id = pp.id").
-		LeftJoin("users u ON u.id = ar.advisor_id").
-		LeftJoin("pre_project_students pps ON pps.pre_project_id = pp.id").
-		LeftJoin("users student ON student.id = pps.student_id").
 		LeftJoin("users accepted_advisor_user ON accepted_advisor_user.id = pp.accepted_advisor").
-		LeftJoin("pre_project_discussants ppd ON ppd.pre_project_id = pp.id").
-		LeftJoin("users discussant ON discussant.id = ppd.discussant_id").
 		Where("pp.id = ?", preProjectID).
+		Where("pp.deleted_at IS NULL").
 		ToSql()
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to build query: %w", err)
 	}
 
-	// Execute the query
-	rows, err := p.db.Queryx(query, args...)
-	if err != nil {
+	var row struct {
+		PreProject
+		AcceptedAdvisorID    uuid.UUID `db:"accepted_advisor_id"`
+		AcceptedAdvisorName  string    `db:"accepted_advisor_name"`
+		AcceptedAdvisorEmail string    `db:"accepted_advisor_email"`
+	}
+	if err := p.db.Get(&row, query, args...); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrRecordNotFound
 		}
 		return nil, fmt.Errorf("failed to query pre-project: %w", err)
 	}
-	defer rows.Close()
-
-	result := &PreProjectWithAdvisorDetails{
-		Advisors: []AdvisorResponseDetails{},
-		Students: []StudentDetails{},
-	}
-
-	preProjectPopulated := false
-	studentSet := map[uuid.UUID]bool{}
-	discussantSet := map[uuid.UUID]bool{}
-	advisorSet := map[uuid.UUID]bool{}
-
-	for rows.Next() {
-		var row struct {
-			PreProject
-			AdvisorID            uuid.UUID `db:"advisor_id"`
-			AdvisorName          string    `db:"advisor_name"`
-			AdvisorEmail         string    `db:"advisor_email"`
-			ResponseStatus       string    `db:"response_status"`
-			ResponseCreatedAt    time.Time `db:"response_created_at"`
-			ResponseUpdatedAt    time.Time `db:"response_updated_at"`
-			StudentID            uuid.UUID `db:"student_id"`
-			StudentName          string    `db:"student_name"`
-			StudentEmail         string    `db:"student_email"`
-			AcceptedAdvisorID    uuid.UUID `db:"accepted_advisor_id"`
-			AcceptedAdvisorName  string    `db:"accepted_advisor_name"`
-			AcceptedAdvisorEmail string    `db:"accepted_advisor_email"`
-			DiscussantID         uuid.UUID `db:"discussant_id"`
-			DiscussantName       string    `db:"discussant_name"`
-			DiscussantEmail      string    `db:"discussant_email"`
-		}
-
-		if err := rows.StructScan(&row); err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
-		}
-
-		// Populate PreProject details
-		if !preProjectPopulated {
-			result.PreProject = row.PreProject
-			preProjectPopulated = true
-		}
 
-		// Populate advisor details, avoiding duplicates
-		if row.AdvisorID != uuid.Nil && !advisorSet[row.AdvisorID] {
-			result.Advisors = append(result.Advisors, AdvisorResponseDetails{
-				AdvisorID:    row.AdvisorID,
-				AdvisorName:  row.AdvisorName,
-				AdvisorEmail: row.AdvisorEmail,
-				Status:       row.ResponseStatus,
-			})
-			advisorSet[row.AdvisorID] = true // Mark this advisor as added
-		}
-		// Add accepted advisor info if exists
-		if row.AcceptedAdvisorID != uuid.Nil {
-			result.AcceptedAdvisorInfo = &AdvisorInfo{
-				ID:    row.AcceptedAdvisorID,
-				Name:  row.AcceptedAdvisorName,
-				Email: row.AcceptedAdvisorEmail,
-			}
-		}
-		if row.StudentID != uuid.Nil && !studentSet[row.StudentID] {
-			result.Students = append(result.Students, StudentDetails{
-				StudentID:    row.StudentID,
-				StudentName:  row.StudentName,
-				StudentEmail: row.StudentEmail,
-			})
-			studentSet[row.StudentID] = true
-		}
-		if row.DiscussantID != uuid.Nil && !discussantSet[row.DiscussantID] {
-			result.Discussants = append(result.Discussants, DiscussantDetails{
-				DiscussantID:    row.DiscussantID,
-				DiscussantName:  row.DiscussantName,
-				DiscussantEmail: row.DiscussantEmail,
-			})
-			discussantSet[row.DiscussantID] = true // Mark this discussant as added
+	result := []PreProjectWithAdvisorDetails{{PreProject: row.PreProject}}
+	if row.AcceptedAdvisorID != uuid.Nil {
+		result[0].AcceptedAdvisorInfo = &AdvisorInfo{
+			ID:    row.AcceptedAdvisorID,
+			Name:  row.AcceptedAdvisorName,
+			Email: row.AcceptedAdvisorEmail,
 		}
 	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error processing rows: %w", err)
-	}
-	if !preProjectPopulated {
-		return nil, ErrRecordNotFound
+
+	if err := p.loadPreProjectParticipants(result); err != nil {
+		return nil, err
 	}
 
-	return result, nil
+	return &result[0], nil
 }
 
 type PreProjectWithStudentAdvisorDetails struct {
@@ -316,7 +270,7 @@ type PreProjectWithStudentAdvisorDetails struct {
 
 func (p *PreProjectDB) ListPreProjects(queryParams url.Values) ([]PreProjectWithAdvisorDetails, *utils.Meta, error) {
 	var preProjects []PreProject
-	searchCols := []string{"b.name", "b.description"}
+	searchCols := append([]string{"b.name", "b.description"}, metadataSearchColumns("b")...)
 	table := "pre_project b"
 
 	// Define columns to include in the result
@@ -326,35 +280,84 @@ func (p *PreProjectDB) ListPreProjects(queryParams url.Values) ([]PreProjectWith
 		"COALESCE(b.description, '') AS description",
 	}
 
-	meta, err := utils.BuildQuery(&preProjects, table, nil, bookJoinColumns, searchCols, queryParams, nil)
+	meta, err := utils.BuildQuery(&preProjects, table, nil, append(bookJoinColumns, metadataColumns("b")...), searchCols, queryParams, []squirrel.Sqlizer{squirrel.Expr("b.deleted_at IS NULL")})
 	if err != nil {
 		return nil, nil, fmt.Errorf("error building query: %v", err)
 	}
 	result := make([]PreProjectWithAdvisorDetails, len(preProjects))
 	for i, pp := range preProjects {
-		advisors, err := p.getAdvisorsForPreProject(pp.ID)
-		if err != nil {
-			return nil, nil, fmt.Errorf("error fetching advisors: %v", err)
-		}
-		students, err := p.getStudentsForPreProject(pp.ID)
-		if err != nil {
-			return nil, nil, fmt.Errorf("error fetching students: %v", err)
+		result[i] = PreProjectWithAdvisorDetails{PreProject: pp}
+	}
+	if err := p.loadPreProjectParticipants(result); err != nil {
+		return nil, nil, err
+	}
+
+	return result, meta, nil
+}
+
+// StreamPreProjects is the pre-project counterpart of BookDB.StreamBooks.
+func (p *PreProjectDB) StreamPreProjects(queryParams url.Values, fn func(PreProjectWithAdvisorDetails) error) error {
+	searchCols := append([]string{"pp.name", "pp.description"}, metadataSearchColumns("pp")...)
+	columns := []string{
+		"pp.id",
+		"pp.name",
+		"pp.description",
+		"pp.file_description",
+		fmt.Sprintf("CASE WHEN NULLIF(pp.file, '') IS NOT NULL THEN FORMAT('%s/%%s', pp.file) ELSE NULL END AS file", Domain),
+		"pp.project_owner",
+		"pp.accepted_advisor",
+		"pp.year",
+		"pp.degree",
+		"pp.season",
+		"pp.can_update",
+		"pp.created_at",
+		"pp.updated_at",
+	}
+
+	query, args, err := utils.BuildListQuery("pre_project pp", nil, append(columns, metadataColumns("pp")...), searchCols, queryParams, []squirrel.Sqlizer{squirrel.Expr("pp.deleted_at IS NULL")})
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := p.db.Queryx(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query pre-projects: %w", err)
+	}
+	defer rows.Close()
+
+	batch := make([]PreProjectWithAdvisorDetails, 0, exportBatchSize)
+	flush := func() error {
+		if err := p.loadPreProjectParticipants(batch); err != nil {
+			return err
 		}
-		discussants, err := p.getDiscussantsForPreProject(pp.ID)
-		if err != nil {
-			return nil, nil, fmt.Errorf("error fetching discussants: %v", err)
+		for _, preProject := range batch {
+			if err := fn(preProject); err != nil {
+				return err
+			}
 		}
+		batch = batch[:0]
+		return nil
+	}
 
-		result[i] = PreProjectWithAdvisorDetails{
-			PreProject:  pp,
-			Advisors:    advisors,
-			Students:    students,
-			Discussants: discussants,
+	for rows.Next() {
+		var preProject PreProject
+		if err := rows.StructScan(&preProject); err != nil {
+			return fmt.Errorf("failed to scan pre-project: %w", err)
+		}
+		batch = append(batch, PreProjectWithAdvisorDetails{PreProject: preProject})
+		if len(batch) == exportBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error processing rows: %w", err)
+	}
 
-	return result, meta, nil
+	return flush()
 }
+
 func (p *PreProjectDB) ListAssociatedPreProjects(userID uuid.UUID) ([]PreProject, error) {
 
 	var preProjects []PreProject
@@ -378,6 +381,7 @@ func (p *PreProjectDB) ListAssociatedPreProjects(userID uuid.UUID) ([]PreProject
 			squirrel.Eq{"s.student_id": userID},
 			squirrel.Eq{"d.discussant_id": userID},
 		}).
+		Where("pp.deleted_at IS NULL").
 		Distinct()
 
 	// Generate SQL query and arguments
@@ -398,65 +402,6 @@ func (p *PreProjectDB) ListAssociatedPreProjects(userID uuid.UUID) ([]PreProject
 
 	return preProjects, nil
 }
-func (p *PreProjectDB) getAdvisorsForPreProject(id uuid.UUID) ([]AdvisorResponseDetails, error) {
-	query, args, err := QB.Select(
-		"ar.advisor_id",
-		"u.name AS advisor_name",
-		"u.email AS advisor_email",
-		"ar.status",
-	).
-		From("advisor_responses ar").
-		LeftJoin("users u ON ar.advisor_id = u.id").
-		Where(squirrel.Eq{"ar.pre_project_id": id}).
-		ToSql()
-	if err != nil {
-		return nil, fmt.Errorf("failed to build query: %w", err)
-	}
-
-	var advisors []AdvisorResponseDetails
-	if err := p.db.Select(&advisors, query, args...); err != nil {
-		return nil, fmt.Errorf("failed to execute advisor query: %w", err)
-	}
-	return advisors, nil
-}
-
-func (p *PreProjectDB) getStudentsForPreProject(id uuid.UUID) ([]StudentDetails, error) {
-	query, args, err := QB.Select(
-		"pps.student_id",
-		"u.name AS student_name",
-		"u.email AS student_email",
-	).
-		From("pre_project_students pps").
-		Join("users u ON pps.student_id = u.id").
-		Where(squirrel.Eq{"pps.pre_project_id": id}).
-		ToSql()
-	if err != nil {
-		return nil, fmt.Errorf("failed to build query: %w", err)
-	}
-
-	var students []StudentDetails
-	err = p.db.Select(&students, query, args...)
-	return students, err
-}
-
-func (p *PreProjectDB) getDiscussantsForPreProject(id uuid.UUID) ([]DiscussantDetails, error) {
-	query, args, err := QB.Select(
-		"ppd.discussant_id",
-		"u.name AS discussant_name",
-		"u.email AS discussant_email",
-	).
-		From("pre_project_discussants ppd").
-		Join("users u ON ppd.discussant_id = u.id").
-		Where(squirrel.Eq{"ppd.pre_project_id": id}).
-		ToSql()
-	if err != nil {
-		return nil, fmt.Errorf("failed to build query: %w", err)
-	}
-
-	var discussants []DiscussantDetails
-	err = p.db.Select(&discussants, query, args...)
-	return discussants, err
-}
 
 type AdvisorResponse struct {
 	ID           uuid.UUID `db:"id" json:"id"`
@@ -466,87 +411,46 @@ type AdvisorResponse struct {
 
 }
 
-func (p *PreProjectDB) DeletePreProject(preProjectID, studentID uuid.UUID) error {
-	tx, err := p.db.Beginx()
-	if err != nil {
-		return fmt.Errorf("failed to start transaction: %w", err)
-	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-			return
-		}
-		err = tx.Commit()
-	}()
-	lockQuery, lockArgs, err := QB.Select("*").
-		From("pre_project").
+// DeletePreProject moves the pre-project to the trash. Its file and
+// attachments are kept until it is purged.
+func (p *PreProjectDB) DeletePreProject(preProjectID, deletedBy uuid.UUID) error {
+	query, args, err := QB.Update("pre_project").
+		Set("deleted_at", time.Now()).
+		Set("deleted_by", deletedBy).
 		Where(squirrel.Eq{"id": preProjectID}).
+		Where("deleted_at IS NULL").
 		ToSql()
 	if err != nil {
-		return fmt.Errorf("failed to build lock query: %w", err)
-	}
-
-	_, err = tx.Exec(lockQuery+" FOR UPDATE", lockArgs...)
-	if err != nil {
-		return fmt.Errorf("failed to lock pre-project: %w", err)
-	}
-	existenceQuery, existenceArgs, err := QB.Select("COUNT(*) > 0").
-		From("pre_project").
-		Where(squirrel.Eq{"id": preProjectID}).
-		ToSql()
-	if err != nil {
-		return fmt.Errorf("failed to build existence check query: %w", err)
-	}
-
-	var preProjectExists bool
-	err = p.db.Get(&preProjectExists, existenceQuery, existenceArgs...)
-	if err != nil {
-		return fmt.Errorf("failed to check pre-project existence: %w", err)
-	}
-
-	if !preProjectExists {
-		return fmt.Errorf("pre-project not found")
+		return fmt.Errorf("failed to build delete query: %w", err)
 	}
 
-	ownerQuery, ownerArgs, err := QB.Select("COUNT(*) > 0").
-		From("pre_project").
-		Where(squirrel.And{
-			squirrel.Eq{"id": preProjectID},
-			squirrel.Eq{"project_owner": studentID},
-		}).
-		ToSql()
+	result, err := p.db.Exec(query, args...)
 	if err != nil {
-		return fmt.Errorf("failed to build owner check query: %w", err)
+		return fmt.Errorf("failed to delete pre-project: %w", err)
 	}
 
-	var isProjectOwner bool
-	err = p.db.Get(&isProjectOwner, ownerQuery, ownerArgs...)
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to check project owner: %w", err)
+		return fmt.Errorf("failed to check rows affected: %w", err)
 	}
-
-	fileQuery, fileArgs, err := QB.Select("file").
-		From("pre_project").
-		Where(squirrel.Eq{"id": preProjectID}).
-		ToSql()
-	if err != nil {
-		return fmt.Errorf("failed to build file query: %w", err)
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
 	}
 
-	var existingFile *string
-	err = tx.Get(&existingFile, fileQuery, fileArgs...)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return fmt.Errorf("failed to retrieve file information: %w", err)
-	}
+	return nil
+}
 
-	deleteQuery, deleteArgs, err := QB.Delete("pre_project").
+// RemovePreProject deletes a pre-project that became a book for good. Its
+// file and attachments have moved to the book, so none are removed here.
+func (p *PreProjectDB) RemovePreProject(preProjectID uuid.UUID) error {
+	query, args, err := QB.Delete("pre_project").
 		Where(squirrel.Eq{"id": preProjectID}).
 		ToSql()
 	if err != nil {
 		return fmt.Errorf("failed to build delete query: %w", err)
 	}
 
-	result, err := tx.Exec(deleteQuery, deleteArgs...)
+	result, err := p.db.Exec(query, args...)
 	if err != nil {
 		return fmt.Errorf("failed to delete pre-project: %w", err)
 	}
@@ -555,15 +459,8 @@ func (p *PreProjectDB) DeletePreProject(preProjectID, studentID uuid.UUID) error
 	if err != nil {
 		return fmt.Errorf("failed to check rows affected: %w", err)
 	}
-
 	if rowsAffected == 0 {
-		return fmt.Errorf("no pre-project found")
-	}
-
-	if existingFile != nil && *existingFile != "" {
-		if err := utils.DeleteFile(*existingFile); err != nil {
-			log.Printf("Failed to delete file %s: %v", *existingFile, err)
-		}
+		return ErrRecordNotFound
 	}
 
 	return nil
@@ -609,7 +506,14 @@ func (p *PreProjectDB) UpdatePreProject(preProject *PreProject, advisorIDs, stud
 		Set("updated_at", time.Now()).
 		Set("can_update", preProject.CanUpdate).
 		Set("degree", preProject.Degree).
+		Set("title_ar", preProject.TitleAr).
+		Set("title_en", preProject.TitleEn).
+		Set("abstract_ar", preProject.AbstractAr).
+		Set("abstract_en", preProject.AbstractEn).
+		Set("keywords", preProject.Keywords).
+		Set("language", preProject.Language).
 		Where(squirrel.Eq{"id": preProject.ID}).
+		Where("deleted_at IS NULL").
 		ToSql()
 	if err != nil {
 		return fmt.Errorf("failed to build update query: %w", err)
@@ -776,10 +680,14 @@ func (p *PreProjectDB) InsertAdvisorResponse(preProjectID, advisorID uuid.UUID,
 	return nil
 }
 func (p *PreProjectDB) CheckExistingPreProject(studentID uuid.UUID) (*PreProject, error) {
-	query, args, err := QB.Select("pp.*").
+	query, args, err := QB.Select(
+		"pp.id", "pp.name", "pp.description", "pp.file", "pp.file_description", "pp.project_owner",
+		"pp.accepted_advisor", "pp.year", "pp.season", "pp.can_update", "pp.degree", "pp.created_at", "pp.updated_at",
+	).
 		From("pre_project_students ps").
 		Join("pre_project pp ON ps.pre_project_id = pp.id").
 		Where(squirrel.Eq{"ps.student_id": studentID}).
+		Where("pp.deleted_at IS NULL").
 		ToSql()
 	if err != nil {
 		return nil, fmt.Errorf("failed to build query: %w", err)
@@ -872,6 +780,7 @@ func (p *PreProjectDB) UpdateCanUpdate(canUpdate bool, id uuid.UUID) error {
 		Set("can_update", canUpdate).
 		Set("updated_at", time.Now()).
 		Where(squirrel.Eq{"id": id}).
+		Where("deleted_at IS NULL").
 		ToSql()
 	if err != nil {
 		return fmt.Errorf("failed to build update query: %w", err)
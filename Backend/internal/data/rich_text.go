@@ -0,0 +1,34 @@
+package data
+
+import (
+	"fmt"
+	"project/utils/markdown"
+)
+
+// RenderedMarkdown is a Markdown text column scanned as its sanitized HTML.
+// Selecting the column a second time under the _html name fills it next to
+// the raw text.
+type RenderedMarkdown string
+
+func (m *RenderedMarkdown) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*m = ""
+	case string:
+		*m = RenderedMarkdown(markdown.HTML(v))
+	case []byte:
+		*m = RenderedMarkdown(markdown.HTML(string(v)))
+	default:
+		return fmt.Errorf("unexpected type for RenderedMarkdown: %T", value)
+	}
+	return nil
+}
+
+// renderMarkdown renders text, which may be nil, for a RenderedMarkdown field
+// of a record that was written rather than scanned.
+func renderMarkdown(text *string) RenderedMarkdown {
+	if text == nil {
+		return ""
+	}
+	return RenderedMarkdown(markdown.HTML(*text))
+}
@@ -0,0 +1,180 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrRefreshTokenReused is returned when a refresh token that was already
+// exchanged is presented again. Someone else may hold a copy, so the session
+// is revoked.
+var ErrRefreshTokenReused = errors.New("refresh token already used")
+
+// sessionTouchInterval limits how often a request updates last_used_at.
+const sessionTouchInterval = time.Minute
+
+type Session struct {
+	ID         uuid.UUID  `db:"id" json:"id"`
+	UserID     uuid.UUID  `db:"user_id" json:"user_id"`
+	UserAgent  string     `db:"user_agent" json:"user_agent"`
+	IP         string     `db:"ip" json:"ip"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	LastUsedAt time.Time  `db:"last_used_at" json:"last_used_at"`
+	ExpiresAt  time.Time  `db:"expires_at" json:"expires_at"`
+	RevokedAt  *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+
+	// Current marks the session of the request listing them.
+	Current bool `db:"-" json:"current"`
+}
+
+const sessionColumns = "id, user_id, user_agent, ip, created_at, last_used_at, expires_at, revoked_at"
+
+type SessionDB struct {
+	db *sqlx.DB
+}
+
+// CreateSession starts a session whose refresh token hashes to refreshHash.
+func (s *SessionDB) CreateSession(session *Session, refreshHash string) error {
+	err := s.db.QueryRowx(`
+		INSERT INTO sessions (user_id, refresh_token_hash, user_agent, ip, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+sessionColumns,
+		session.UserID, refreshHash, session.UserAgent, session.IP, session.ExpiresAt).StructScan(session)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+// RotateSession exchanges the refresh token hashing to refreshHash for the
+// one hashing to newHash and extends the session until expiresAt. Presenting
+// a token that was already exchanged revokes the session.
+func (s *SessionDB) RotateSession(refreshHash, newHash string, expiresAt time.Time) (*Session, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var session Session
+	err = tx.Get(&session, `
+		SELECT `+sessionColumns+` FROM sessions
+		WHERE refresh_token_hash = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		FOR UPDATE`, refreshHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		result, err := tx.Exec(`
+			UPDATE sessions SET revoked_at = NOW()
+			WHERE previous_token_hash = $1 AND revoked_at IS NULL`, refreshHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to revoke session: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		if reused, _ := result.RowsAffected(); reused > 0 {
+			return nil, ErrRefreshTokenReused
+		}
+		return nil, ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	err = tx.QueryRowx(`
+		UPDATE sessions
+		SET refresh_token_hash = $1, previous_token_hash = $2, last_used_at = NOW(), expires_at = $3
+		WHERE id = $4
+		RETURNING `+sessionColumns, newHash, refreshHash, expiresAt, session.ID).StructScan(&session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate session: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// TouchSession reports whether the user's session is still active, and
+// records that it was used. It also returns the version the user's roles are
+// at, to tell whether the roles in the access token are still current.
+func (s *SessionDB) TouchSession(sessionID, userID uuid.UUID) (int, bool, error) {
+	var rows []struct {
+		LastUsedAt   time.Time `db:"last_used_at"`
+		RolesVersion int       `db:"roles_version"`
+	}
+	err := s.db.Select(&rows, `
+		SELECT s.last_used_at, u.roles_version FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.id = $1 AND s.user_id = $2 AND s.revoked_at IS NULL AND s.expires_at > NOW()
+			AND u.deleted_at IS NULL`, sessionID, userID)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to check session: %w", err)
+	}
+	if len(rows) == 0 {
+		return 0, false, nil
+	}
+
+	if time.Since(rows[0].LastUsedAt) > sessionTouchInterval {
+		if _, err := s.db.Exec("UPDATE sessions SET last_used_at = NOW() WHERE id = $1", sessionID); err != nil {
+			return 0, true, fmt.Errorf("failed to touch session: %w", err)
+		}
+	}
+	return rows[0].RolesVersion, true, nil
+}
+
+// ListSessions returns the user's active sessions, most recently used first.
+func (s *SessionDB) ListSessions(userID uuid.UUID) ([]Session, error) {
+	sessions := []Session{}
+	err := s.db.Select(&sessions, `
+		SELECT `+sessionColumns+` FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one of the user's sessions.
+func (s *SessionDB) RevokeSession(sessionID, userID uuid.UUID) error {
+	result, err := s.db.Exec(`
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// RevokeUserSessions ends every session of the user but except, which may be
+// uuid.Nil, and returns how many it ended.
+func (s *SessionDB) RevokeUserSessions(userID, except uuid.UUID) (int64, error) {
+	result, err := s.db.Exec(`
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL`, userID, except)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// PruneSessions deletes the sessions that expired or were revoked before
+// before.
+func (s *SessionDB) PruneSessions(before time.Time) (int64, error) {
+	result, err := s.db.Exec("DELETE FROM sessions WHERE expires_at < $1 OR revoked_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune sessions: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,155 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"project/utils"
+	"strconv"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Teacher profile sections, each paginated on its own.
+const (
+	TeacherSectionAdvised     = "advised"
+	TeacherSectionDiscussed   = "discussed"
+	TeacherSectionPreProjects = "preprojects"
+)
+
+var TeacherSections = []string{TeacherSectionAdvised, TeacherSectionDiscussed, TeacherSectionPreProjects}
+
+// Teacher is the public part of a teacher's account.
+type Teacher struct {
+	ID                uuid.UUID      `db:"id" json:"id"`
+	Name              string         `db:"name" json:"name"`
+	Email             string         `db:"email" json:"email"`
+	Image             *string        `db:"image" json:"image,omitempty"`
+	ResearchInterests pq.StringArray `db:"research_interests" json:"research_interests"`
+	CreatedAt         time.Time      `db:"created_at" json:"created_at"`
+}
+
+// TeacherStats sums up a teacher's supervision history. Advisor responses
+// only exist while their pre-project does, so accepted, rejected and pending
+// cover the pre-projects still in progress; promoted ones count as advised
+// books.
+type TeacherStats struct {
+	BooksAdvised       int      `db:"books_advised" json:"books_advised"`
+	BooksDiscussed     int      `db:"books_discussed" json:"books_discussed"`
+	CurrentPreProjects int      `db:"current_pre_projects" json:"current_pre_projects"`
+	Accepted           int      `db:"accepted" json:"accepted"`
+	Rejected           int      `db:"rejected" json:"rejected"`
+	Pending            int      `db:"pending" json:"pending"`
+	AverageDegree      *float64 `db:"average_degree" json:"average_degree"`
+}
+
+type TeacherBook struct {
+	ID        uuid.UUID `db:"id" json:"id"`
+	Name      string    `db:"name" json:"name"`
+	Year      int       `db:"year" json:"year"`
+	Season    string    `db:"season" json:"season"`
+	Degree    *int      `db:"degree" json:"degree,omitempty"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+type TeacherPreProject struct {
+	ID            uuid.UUID `db:"id" json:"id"`
+	Name          string    `db:"name" json:"name"`
+	Year          int       `db:"year" json:"year"`
+	Season        string    `db:"season" json:"season"`
+	AdvisorStatus string    `db:"advisor_status" json:"advisor_status"`
+	CreatedAt     time.Time `db:"created_at" json:"created_at"`
+}
+
+// GetTeacher returns the user if they hold the teacher role.
+func (u *UserRoleDB) GetTeacher(teacherID uuid.UUID) (*Teacher, error) {
+	var teacher Teacher
+	err := u.db.Get(&teacher, fmt.Sprintf(`
+		SELECT u.id, u.name, u.email,
+			CASE WHEN NULLIF(u.image, '') IS NOT NULL THEN FORMAT('%s/%%s', u.image) ELSE NULL END AS image,
+			u.research_interests, u.created_at
+		FROM users u
+		JOIN user_roles ur ON ur.user_id = u.id
+		JOIN roles r ON r.id = ur.role_id AND r.name = $2
+		WHERE u.id = $1 AND u.deleted_at IS NULL`, Domain), teacherID, RoleTeacher)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get teacher: %w", err)
+	}
+	return &teacher, nil
+}
+
+// GetTeacherStats computes the summary counters of a teacher. Books without a
+// degree (stored as 0 or NULL) are left out of the average.
+func (u *UserRoleDB) GetTeacherStats(teacherID uuid.UUID) (*TeacherStats, error) {
+	var stats TeacherStats
+	err := u.db.Get(&stats, `
+		SELECT
+			(SELECT COUNT(*) FROM book_advisors ba JOIN book b ON b.id = ba.book_id
+				WHERE ba.advisor_id = $1 AND b.deleted_at IS NULL) AS books_advised,
+			(SELECT COUNT(*) FROM book_discussants bd JOIN book b ON b.id = bd.book_id
+				WHERE bd.discussant_id = $1 AND b.deleted_at IS NULL) AS books_discussed,
+			(SELECT COUNT(*) FROM pre_project WHERE accepted_advisor = $1 AND deleted_at IS NULL) AS current_pre_projects,
+			(SELECT COUNT(*) FILTER (WHERE ar.status = 'accepted') FROM advisor_responses ar JOIN pre_project pp ON pp.id = ar.pre_project_id
+				WHERE ar.advisor_id = $1 AND pp.deleted_at IS NULL) AS accepted,
+			(SELECT COUNT(*) FILTER (WHERE ar.status = 'rejected') FROM advisor_responses ar JOIN pre_project pp ON pp.id = ar.pre_project_id
+				WHERE ar.advisor_id = $1 AND pp.deleted_at IS NULL) AS rejected,
+			(SELECT COUNT(*) FILTER (WHERE ar.status = 'pending') FROM advisor_responses ar JOIN pre_project pp ON pp.id = ar.pre_project_id
+				WHERE ar.advisor_id = $1 AND pp.deleted_at IS NULL) AS pending,
+			(SELECT ROUND(AVG(b.degree)::numeric, 2)::float8
+				FROM book b JOIN book_advisors ba ON ba.book_id = b.id
+				WHERE ba.advisor_id = $1 AND b.degree > 0 AND b.deleted_at IS NULL) AS average_degree`, teacherID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get teacher stats: %w", err)
+	}
+	return &stats, nil
+}
+
+// pageParams keeps only the pagination of one section, so sections never
+// pick up each other's or the caller's search and filter parameters.
+func pageParams(page, perPage int, sort string) url.Values {
+	return url.Values{
+		"page":     {strconv.Itoa(page)},
+		"per_page": {strconv.Itoa(perPage)},
+		"sort":     {sort},
+	}
+}
+
+// GetTeacherBooks pages through the books the teacher advised or discussed,
+// newest first.
+func (u *UserRoleDB) GetTeacherBooks(teacherID uuid.UUID, section string, page, perPage int) ([]TeacherBook, *utils.Meta, error) {
+	join := fmt.Sprintf("book_advisors rel ON rel.book_id = b.id AND rel.advisor_id = '%s'", teacherID)
+	if section == TeacherSectionDiscussed {
+		join = fmt.Sprintf("book_discussants rel ON rel.book_id = b.id AND rel.discussant_id = '%s'", teacherID)
+	}
+	columns := []string{"b.id", "b.name", "b.year", "b.season", "b.degree", "b.created_at"}
+
+	books := []TeacherBook{}
+	meta, err := utils.BuildQuery(&books, "book b", []string{join}, columns, nil,
+		pageParams(page, perPage, "-b.created_at"), []squirrel.Sqlizer{squirrel.Expr("rel.book_id IS NOT NULL"), squirrel.Expr("b.deleted_at IS NULL")})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error building query: %w", err)
+	}
+	return books, meta, nil
+}
+
+// GetTeacherPreProjects pages through the pre-projects the teacher supervises
+// or has yet to answer.
+func (u *UserRoleDB) GetTeacherPreProjects(teacherID uuid.UUID, page, perPage int) ([]TeacherPreProject, *utils.Meta, error) {
+	join := fmt.Sprintf("advisor_responses ar ON ar.pre_project_id = pp.id AND ar.advisor_id = '%s'", teacherID)
+	columns := []string{"pp.id", "pp.name", "pp.year", "pp.season", "ar.status AS advisor_status", "pp.created_at"}
+
+	preProjects := []TeacherPreProject{}
+	meta, err := utils.BuildQuery(&preProjects, "pre_project pp", []string{join}, columns, nil,
+		pageParams(page, perPage, "-pp.created_at"), []squirrel.Sqlizer{squirrel.Expr("ar.status IN ('accepted', 'pending')"), squirrel.Expr("pp.deleted_at IS NULL")})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error building query: %w", err)
+	}
+	return preProjects, meta, nil
+}
@@ -0,0 +1,199 @@
+package data
+
+import (
+	"fmt"
+	"net/url"
+	"project/utils"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// trashEntity is a kind of record that can be moved to the trash.
+type trashEntity struct {
+	table string
+	title string // expression naming the row in the trash listing, over alias t
+	// files lists the stored paths removed from disk along with the rows
+	// of ids. Users and pre-projects cascade to the rows that reference
+	// them, so their files are included as well.
+	files string
+	// tombstone keeps what harvesters need to know of the rows of ids
+	// once they are purged.
+	tombstone string
+	// touch sets updated_at on restore, so harvesters pick the row up again.
+	touch bool
+}
+
+var trashEntities = map[string]trashEntity{
+	"book": {
+		table: "book",
+		title: "t.name",
+		files: `SELECT file FROM book WHERE id = ANY($1)
+			UNION ALL SELECT file FROM attachments WHERE book_id = ANY($1)`,
+		tombstone: `INSERT INTO book_tombstones (id, identifier, year, season, program, deleted_at)
+			SELECT id, identifier, year, season, program, COALESCE(updated_at, deleted_at) FROM book WHERE id = ANY($1)
+			ON CONFLICT (id) DO NOTHING`,
+		touch: true,
+	},
+	"post": {
+		table: "post",
+		title: "LEFT(t.description, 120)",
+		files: `SELECT file FROM post WHERE id = ANY($1)`,
+	},
+	"user": {
+		table: "users",
+		title: "t.name || ' <' || t.email || '>'",
+		files: `SELECT image FROM users WHERE id = ANY($1)
+			UNION ALL SELECT file FROM pre_project WHERE project_owner = ANY($1)
+			UNION ALL SELECT a.file FROM attachments a JOIN pre_project pp ON pp.id = a.pre_project_id
+				WHERE pp.project_owner = ANY($1)
+			UNION ALL SELECT ch.file FROM chats ch JOIN conversations c ON c.id = ch.conversation_id
+				WHERE c.user1_id = ANY($1) OR c.user2_id = ANY($1)`,
+	},
+	"preproject": {
+		table: "pre_project",
+		title: "t.name",
+		files: `SELECT file FROM pre_project WHERE id = ANY($1)
+			UNION ALL SELECT file FROM attachments WHERE pre_project_id = ANY($1)`,
+	},
+	"conversation": {
+		table: "conversations",
+		title: "(SELECT string_agg(u.name, ' / ') FROM users u WHERE u.id IN (t.user1_id, t.user2_id))",
+		files: `SELECT file FROM chats WHERE conversation_id = ANY($1)`,
+	},
+}
+
+// trashPurgeOrder purges users last, so the rows they cascade to are
+// collected by their own entity first whenever both are due.
+var trashPurgeOrder = []string{"book", "post", "preproject", "conversation", "user"}
+
+// TrashTypes are the values accepted for ?type= and {type}.
+var TrashTypes = []string{"book", "post", "user", "preproject", "conversation"}
+
+type TrashDB struct {
+	db *sqlx.DB
+}
+
+type TrashItem struct {
+	ID            uuid.UUID  `db:"id" json:"id"`
+	Title         *string    `db:"title" json:"title"`
+	DeletedAt     time.Time  `db:"deleted_at" json:"deleted_at"`
+	DeletedBy     *uuid.UUID `db:"deleted_by" json:"deleted_by"`
+	DeletedByName *string    `db:"deleted_by_name" json:"deleted_by_name"`
+}
+
+// ListTrash pages through the trashed records of one type, most recently
+// deleted first.
+func (t *TrashDB) ListTrash(entityType string, queryParams url.Values) ([]TrashItem, *utils.Meta, error) {
+	entity, ok := trashEntities[entityType]
+	if !ok {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	params := url.Values{"sort": {"-t.deleted_at"}}
+	for _, key := range []string{"page", "per_page"} {
+		if value := queryParams.Get(key); value != "" {
+			params.Set(key, value)
+		}
+	}
+	columns := []string{"t.id", entity.title + " AS title", "t.deleted_at", "t.deleted_by", "d.name AS deleted_by_name"}
+
+	items := []TrashItem{}
+	meta, err := utils.BuildQuery(&items, entity.table+" t", []string{"users d ON d.id = t.deleted_by"}, columns, nil,
+		params, []squirrel.Sqlizer{squirrel.Expr("t.deleted_at IS NOT NULL")})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error building query: %w", err)
+	}
+	return items, meta, nil
+}
+
+// Restore takes a record out of the trash.
+func (t *TrashDB) Restore(entityType string, id uuid.UUID) error {
+	entity, ok := trashEntities[entityType]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	ub := QB.Update(entity.table).
+		Set("deleted_at", nil).
+		Set("deleted_by", nil).
+		Where("id = ? AND deleted_at IS NOT NULL", id)
+	if entity.touch {
+		ub = ub.Set("updated_at", time.Now())
+	}
+	query, args, err := ub.ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build restore query: %w", err)
+	}
+
+	result, err := t.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to restore %s: %w", entityType, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Purge deletes for good every record trashed before the cutoff and returns
+// the stored paths of the files they leave behind. The caller removes the
+// files once the rows are gone.
+func (t *TrashDB) Purge(before time.Time) (purged int, files []string, err error) {
+	tx, err := t.db.Beginx()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, entityType := range trashPurgeOrder {
+		entity := trashEntities[entityType]
+
+		var ids []uuid.UUID
+		err = tx.Select(&ids, fmt.Sprintf("SELECT id FROM %s WHERE deleted_at < $1 FOR UPDATE", entity.table), before)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to select expired %s rows: %w", entityType, err)
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		var paths []*string
+		if err = tx.Select(&paths, entity.files, pq.Array(ids)); err != nil {
+			return 0, nil, fmt.Errorf("failed to collect %s files: %w", entityType, err)
+		}
+		for _, path := range paths {
+			if path != nil && *path != "" {
+				files = append(files, *path)
+			}
+		}
+
+		if entity.tombstone != "" {
+			if _, err = tx.Exec(entity.tombstone, pq.Array(ids)); err != nil {
+				return 0, nil, fmt.Errorf("failed to keep %s tombstones: %w", entityType, err)
+			}
+		}
+
+		result, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = ANY($1)", entity.table), pq.Array(ids))
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to purge %s rows: %w", entityType, err)
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return 0, nil, err
+		}
+		purged += int(rowsAffected)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, nil, fmt.Errorf("failed to commit purge: %w", err)
+	}
+	return purged, files, nil
+}
@@ -0,0 +1,283 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// ErrTwoFactorEnabled is returned when enrolling a user who already has a
+// second factor.
+var ErrTwoFactorEnabled = errors.New("المصادقة الثنائية مفعلة بالفعل")
+
+// MaxChallengeAttempts is how many wrong codes a login challenge takes before
+// it is dropped and the password has to be entered again.
+const MaxChallengeAttempts = 5
+
+// TwoFactor is a user's TOTP enrollment. EnabledAt is nil until the first
+// code confirms it.
+type TwoFactor struct {
+	UserID    uuid.UUID  `db:"user_id"`
+	Secret    string     `db:"secret"`
+	EnabledAt *time.Time `db:"enabled_at"`
+	LastStep  int64      `db:"last_step"`
+}
+
+type LoginChallenge struct {
+	UserID    uuid.UUID `db:"user_id"`
+	Attempts  int       `db:"attempts"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+type TwoFactorDB struct {
+	db *sqlx.DB
+}
+
+// GetTwoFactor returns the user's enrollment, ErrRecordNotFound without one.
+func (t *TwoFactorDB) GetTwoFactor(userID uuid.UUID) (*TwoFactor, error) {
+	var tf TwoFactor
+	err := t.db.Get(&tf, "SELECT user_id, secret, enabled_at, last_step FROM user_two_factor WHERE user_id = $1", userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get two-factor: %w", err)
+	}
+	return &tf, nil
+}
+
+// StartEnrollment stores a new secret awaiting confirmation, replacing any
+// earlier one that was never confirmed.
+func (t *TwoFactorDB) StartEnrollment(userID uuid.UUID, secret string) error {
+	result, err := t.db.Exec(`
+		INSERT INTO user_two_factor (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0, created_at = NOW()
+		WHERE user_two_factor.enabled_at IS NULL`, userID, secret)
+	if err != nil {
+		return fmt.Errorf("failed to start two-factor enrollment: %w", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+		return ErrTwoFactorEnabled
+	}
+	return nil
+}
+
+// Enable confirms the enrollment with the step of its first code and sets
+// the recovery codes.
+func (t *TwoFactorDB) Enable(userID uuid.UUID, step int64, recoveryHashes []string) error {
+	tx, err := t.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE user_two_factor SET enabled_at = NOW(), last_step = $2
+		WHERE user_id = $1 AND enabled_at IS NULL`, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to enable two-factor: %w", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+		return ErrTwoFactorEnabled
+	}
+	if err := replaceRecoveryCodes(tx, userID, recoveryHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseStep accepts a code of the step unless one of the same or a later step
+// was already accepted.
+func (t *TwoFactorDB) UseStep(userID uuid.UUID, step int64) (bool, error) {
+	result, err := t.db.Exec(`
+		UPDATE user_two_factor SET last_step = $2
+		WHERE user_id = $1 AND enabled_at IS NOT NULL AND last_step < $2`, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to use two-factor code: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected == 1, err
+}
+
+// Disable removes the user's enrollment and recovery codes.
+func (t *TwoFactorDB) Disable(userID uuid.UUID) error {
+	tx, err := t.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM user_two_factor WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("failed to disable two-factor: %w", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes invalidates the user's recovery codes in favour of
+// new ones.
+func (t *TwoFactorDB) ReplaceRecoveryCodes(userID uuid.UUID, hashes []string) error {
+	tx, err := t.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, hashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sqlx.Tx, userID uuid.UUID, hashes []string) error {
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	_, err := tx.Exec(`
+		INSERT INTO user_recovery_codes (user_id, code_hash)
+		SELECT $1, unnest($2::text[]) ON CONFLICT DO NOTHING`, userID, pq.Array(hashes))
+	if err != nil {
+		return fmt.Errorf("failed to insert recovery codes: %w", err)
+	}
+	return nil
+}
+
+// UseRecoveryCode spends the recovery code hashing to hash, reporting false
+// when it does not exist or was already used.
+func (t *TwoFactorDB) UseRecoveryCode(userID uuid.UUID, hash string) (bool, error) {
+	result, err := t.db.Exec(`
+		UPDATE user_recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userID, hash)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected == 1, err
+}
+
+// CountRecoveryCodes returns how many of the user's recovery codes are left.
+func (t *TwoFactorDB) CountRecoveryCodes(userID uuid.UUID) (int, error) {
+	var count int
+	err := t.db.Get(&count, "SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL", userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return count, nil
+}
+
+// RequiresTwoFactor reports whether one of the user's roles requires a
+// second factor.
+func (t *TwoFactorDB) RequiresTwoFactor(userID uuid.UUID) (bool, error) {
+	var required bool
+	err := t.db.Get(&required, `
+		SELECT EXISTS (
+			SELECT 1 FROM user_roles JOIN roles ON roles.id = user_roles.role_id
+			WHERE user_roles.user_id = $1 AND roles.require_two_factor)`, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check two-factor policy: %w", err)
+	}
+	return required, nil
+}
+
+// RevokeUnenrolledSessions signs out the holders of the role that have no
+// second factor, so they enroll on their next sign-in.
+func (t *TwoFactorDB) RevokeUnenrolledSessions(roleID int) (int64, error) {
+	result, err := t.db.Exec(`
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE revoked_at IS NULL AND user_id IN (
+			SELECT user_roles.user_id FROM user_roles
+			WHERE user_roles.role_id = $1 AND NOT EXISTS (
+				SELECT 1 FROM user_two_factor
+				WHERE user_two_factor.user_id = user_roles.user_id AND user_two_factor.enabled_at IS NOT NULL))`, roleID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// RevokeUnenrolledUserSessions signs the user out unless they have a second
+// factor, for when they are granted a role that requires one.
+func (t *TwoFactorDB) RevokeUnenrolledUserSessions(userID uuid.UUID) (int64, error) {
+	result, err := t.db.Exec(`
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE revoked_at IS NULL AND user_id = $1 AND NOT EXISTS (
+			SELECT 1 FROM user_two_factor
+			WHERE user_two_factor.user_id = $1 AND user_two_factor.enabled_at IS NOT NULL)`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// CreateChallenge records that the user passed the password step. The
+// challenge token hashes to tokenHash.
+func (t *TwoFactorDB) CreateChallenge(userID uuid.UUID, tokenHash string, expiresAt time.Time) error {
+	_, err := t.db.Exec("INSERT INTO login_challenges (token_hash, user_id, expires_at) VALUES ($1, $2, $3)",
+		tokenHash, userID, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create login challenge: %w", err)
+	}
+	return nil
+}
+
+// GetChallenge returns the unexpired challenge whose token hashes to
+// tokenHash.
+func (t *TwoFactorDB) GetChallenge(tokenHash string) (*LoginChallenge, error) {
+	var challenge LoginChallenge
+	err := t.db.Get(&challenge, `
+		SELECT user_id, attempts, expires_at FROM login_challenges
+		WHERE token_hash = $1 AND expires_at > NOW() AND attempts < $2`, tokenHash, MaxChallengeAttempts)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get login challenge: %w", err)
+	}
+	return &challenge, nil
+}
+
+// FailChallenge counts a wrong code against the challenge and returns how
+// many attempts it has left.
+func (t *TwoFactorDB) FailChallenge(tokenHash string) (int, error) {
+	var attempts int
+	err := t.db.Get(&attempts, `
+		UPDATE login_challenges SET attempts = attempts + 1
+		WHERE token_hash = $1 RETURNING attempts`, tokenHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to record challenge attempt: %w", err)
+	}
+	if attempts >= MaxChallengeAttempts {
+		return 0, t.DeleteChallenge(tokenHash)
+	}
+	return MaxChallengeAttempts - attempts, nil
+}
+
+func (t *TwoFactorDB) DeleteChallenge(tokenHash string) error {
+	if _, err := t.db.Exec("DELETE FROM login_challenges WHERE token_hash = $1", tokenHash); err != nil {
+		return fmt.Errorf("failed to delete login challenge: %w", err)
+	}
+	return nil
+}
+
+// PruneChallenges deletes the challenges that expired.
+func (t *TwoFactorDB) PruneChallenges() (int64, error) {
+	result, err := t.db.Exec("DELETE FROM login_challenges WHERE expires_at < NOW()")
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune login challenges: %w", err)
+	}
+	return result.RowsAffected()
+}
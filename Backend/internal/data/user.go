@@ -1,6 +1,7 @@
 package data
 
 import (
+	"crypto/subtle"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -18,18 +19,20 @@ import (
 )
 
 type User struct {
-	ID                       uuid.UUID   `db:"id" json:"id"`
-	Name                     string      `db:"name" json:"name"`
-	Email                    string      `db:"email" json:"email"`
-	Password                 string      `db:"password" json:"-"` // Don't include password in JSON
-	Image                    *string     `db:"image" json:"image,omitempty"`
-	CreatedAt                time.Time   `db:"created_at" json:"created_at"`
-	UpdatedAt                time.Time   `db:"updated_at" json:"updated_at"`
-	Roles                    StringArray `json:"roles,omitempty"`                 // Custom type for roles
-	Verified                 bool        `db:"verified" json:"verified"`          // New field for email verification
-	VerificationCode         string      `db:"verification_code" json:"-"`        // Field for verification code
-	VerificationCodeExpiry   time.Time   `db:"verification_code_expiry" json:"-"` // Expiry timestamp for email verification
-	LastVerificationCodeSent time.Time   `db:"last_verification_code_sent" json:"-"`
+	ID                       uuid.UUID      `db:"id" json:"id"`
+	Name                     string         `db:"name" json:"name"`
+	Email                    string         `db:"email" json:"email"`
+	Password                 string         `db:"password" json:"-"` // Don't include password in JSON
+	Image                    *string        `db:"image" json:"image,omitempty"`
+	CreatedAt                time.Time      `db:"created_at" json:"created_at"`
+	UpdatedAt                time.Time      `db:"updated_at" json:"updated_at"`
+	Roles                    StringArray    `json:"roles,omitempty"`              // Custom type for roles
+	Verified                 bool           `db:"verified" json:"verified"`       // New field for email verification
+	Placeholder              bool           `db:"placeholder" json:"placeholder"` // Created by the book import, not claimed yet
+	ResearchInterests        pq.StringArray `db:"research_interests" json:"research_interests,omitempty"`
+	VerificationCode         string         `db:"verification_code" json:"-"`        // Field for verification code
+	VerificationCodeExpiry   time.Time      `db:"verification_code_expiry" json:"-"` // Expiry timestamp for email verification
+	LastVerificationCodeSent time.Time      `db:"last_verification_code_sent" json:"-"`
 }
 
 type StringArray []string
@@ -61,6 +64,19 @@ type UserDB struct {
 	db *sqlx.DB
 }
 
+const (
+	maxResearchInterests      = 20
+	maxResearchInterestLength = 100
+)
+
+// ValidateResearchInterests checks a teacher's list of research interests.
+func ValidateResearchInterests(v *validator.Validator, interests []string) {
+	v.Check(len(interests) <= maxResearchInterests, "research_interests", fmt.Sprintf("لا يمكن إضافة أكثر من %d اهتمامات بحثية", maxResearchInterests))
+	for _, interest := range interests {
+		v.Check(len([]rune(interest)) <= maxResearchInterestLength, "research_interests", fmt.Sprintf("يجب أن يكون كل اهتمام بحثي أقل من %d حرف", maxResearchInterestLength))
+	}
+}
+
 // ValidateUser validates fields in the User struct.
 func ValidateUser(v *validator.Validator, user *User, isAdmin bool, fields ...string) {
 	for _, field := range fields {
@@ -114,7 +130,7 @@ func (u *UserDB) InsertUser(user *User) error {
 // GetUser ByEmail retrieves a user by their email.
 func (u *UserDB) GetUserByEmail(email string) (*User, error) {
 	var user User
-	query, args, err := QB.Select(users_column...).From("users").Where(squirrel.Eq{"email": email}).ToSql()
+	query, args, err := QB.Select(users_column...).From("users").Where(squirrel.Eq{"email": email}).Where("deleted_at IS NULL").ToSql()
 	if err != nil {
 		return nil, err
 	}
@@ -138,13 +154,14 @@ func (u *UserDB) GetUser(userID uuid.UUID) (*User, error) {
 	query, args, err := QB.Select(
 		"u.id", "u.name", "u.email", "u.password", "u.verified", "u.verification_code", "u.verification_code_expiry",
 		fmt.Sprintf("CASE WHEN NULLIF(u.image, '') IS NOT NULL THEN FORMAT('%s/%%s', u.image) ELSE NULL END AS image", Domain), // Include domain before file
-		"u.created_at", "u.updated_at",
+		"u.created_at", "u.updated_at", "u.research_interests",
 		"ARRAY_AGG(r.name) AS roles",
 	).
 		From("users u").
 		LeftJoin("user_roles ur ON u.id = ur.user_id").
 		LeftJoin("roles r ON ur.role_id = r.id").
 		Where(squirrel.Eq{"u.id": userID}).
+		Where("u.deleted_at IS NULL").
 		GroupBy("u.id").
 		ToSql()
 
@@ -171,11 +188,15 @@ func (u *UserDB) UpdateUser(user *User) error {
 			"image":                       user.Image,
 			"updated_at":                  time.Now(),
 			"verified":                    user.Verified,
+			"placeholder":                 user.Placeholder,
 			"last_verification_code_sent": user.LastVerificationCodeSent, // Update the last sent time
 			"verification_code":           user.VerificationCode,         // Update the verification code
 			"verification_code_expiry":    user.VerificationCodeExpiry,   // Update the verification code expiry
+			// A new code gets a fresh set of attempts.
+			"verification_code_attempts": squirrel.Expr("CASE WHEN verification_code IS DISTINCT FROM ? THEN 0 ELSE verification_code_attempts END", user.VerificationCode),
 		}).
 		Where(squirrel.Eq{"id": user.ID}).
+		Where("deleted_at IS NULL").
 		ToSql()
 	if err != nil {
 		return err
@@ -202,15 +223,40 @@ func (u *UserDB) UpdateUser(user *User) error {
 	return nil
 }
 
-// DeleteUser deletes a user by their ID.
-func (u *UserDB) DeleteUser(userID uuid.UUID) error {
-	query, args, err := QB.Delete("users").Where(squirrel.Eq{"id": userID}).ToSql()
+// SetResearchInterests replaces the user's research interests.
+func (u *UserDB) SetResearchInterests(userID uuid.UUID, interests []string) error {
+	_, err := u.db.Exec("UPDATE users SET research_interests = $1, updated_at = NOW() WHERE id = $2 AND deleted_at IS NULL", pq.Array(interests), userID)
+	if err != nil {
+		return fmt.Errorf("error while updating research interests: %v", err)
+	}
+	return nil
+}
+
+// DeleteUser moves a user to the trash. The account can no longer sign in and
+// is hidden from every listing until it is restored or purged.
+func (u *UserDB) DeleteUser(userID, deletedBy uuid.UUID) error {
+	query, args, err := QB.Update("users").
+		Set("deleted_at", time.Now()).
+		Set("deleted_by", deletedBy).
+		Where(squirrel.Eq{"id": userID}).
+		Where("deleted_at IS NULL").
+		ToSql()
 	if err != nil {
 		return err
 	}
 
-	_, err = u.db.Exec(query, args...)
-	return err
+	result, err := u.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
 }
 func (p *UserDB) ListUsers(queryParams url.Values) ([]User, *utils.Meta, error) {
 	var users []User
@@ -218,7 +264,7 @@ func (p *UserDB) ListUsers(queryParams url.Values) ([]User, *utils.Meta, error)
 	table := "users"
 
 	// Call BuildQuery to construct and execute the query
-	meta, err := utils.BuildQuery(&users, table, nil, users_column, searchCols, queryParams, nil)
+	meta, err := utils.BuildQuery(&users, table, nil, users_column, searchCols, queryParams, []squirrel.Sqlizer{squirrel.Expr("deleted_at IS NULL")})
 	if err != nil {
 		return nil, nil, fmt.Errorf("error building query: %v", err)
 	}
@@ -226,6 +272,31 @@ func (p *UserDB) ListUsers(queryParams url.Values) ([]User, *utils.Meta, error)
 	return users, meta, nil
 }
 
+var userCursor = utils.CursorConfig{
+	IDColumn: "id",
+	IDField:  "id",
+	SortColumns: map[string]string{
+		"created_at": "created_at",
+		"name":       "name",
+		"email":      "email",
+	},
+	DefaultSort: "-created_at",
+}
+
+// ListUsersCursor is ListUsers with keyset pagination.
+func (p *UserDB) ListUsersCursor(queryParams url.Values) ([]User, *utils.CursorMeta, error) {
+	var users []User
+	searchCols := []string{"name", "email"}
+	table := "users"
+
+	meta, err := utils.BuildCursorQuery(&users, table, nil, users_column, searchCols, queryParams, []squirrel.Sqlizer{squirrel.Expr("deleted_at IS NULL")}, userCursor)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error building query: %w", err)
+	}
+
+	return users, meta, nil
+}
+
 // CountGraduationStudents returns the number of students in their graduation semester
 
 // CheckVerificationCodeExpiry checks if the user's verification code has expired.
@@ -251,26 +322,52 @@ func (u *UserDB) CheckVerificationCodeExpiry(userID uuid.UUID) (bool, error) {
 
 	return true, nil // Valid
 }
-func (u *UserDB) VerifyUser(userID uuid.UUID, code string) error {
-	// Retrieve the user from the database
-	user, err := u.GetUser(userID)
+
+var (
+	ErrInvalidVerificationCode  = errors.New("رمز التحقق غير صالح")
+	ErrVerificationCodeExpired  = errors.New("رمز التحقق منتهي الصلاحية")
+	ErrVerificationCodeAttempts = errors.New("تم تجاوز عدد المحاولات المسموح بها لهذا الرمز، يرجى طلب رمز جديد")
+)
+
+// CheckVerificationCode checks a guess at the user's verification or reset
+// code. Every guess counts against the code, which stops being accepted
+// after MaxVerificationCodeAttempts of them.
+func (u *UserDB) CheckVerificationCode(userID uuid.UUID, code string) error {
+	var current struct {
+		Code   string    `db:"verification_code"`
+		Expiry time.Time `db:"verification_code_expiry"`
+	}
+	err := u.db.Get(&current, `
+		UPDATE users SET verification_code_attempts = verification_code_attempts + 1
+		WHERE id = $1 AND verification_code_attempts < $2
+		RETURNING COALESCE(verification_code, '') AS verification_code,
+			COALESCE(verification_code_expiry, '2008-01-01 00:00:00') AS verification_code_expiry`,
+		userID, MaxVerificationCodeAttempts)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrVerificationCodeAttempts
+		}
 		return err
 	}
 
-	// Check if the verification code has expired
-	if user.VerificationCodeExpiry.Before(time.Now()) {
-		return fmt.Errorf("رمز التاكيد انتهت صلاحية")
+	if current.Expiry.Before(time.Now()) {
+		return ErrVerificationCodeExpired
+	}
+	if current.Code == "" || subtle.ConstantTimeCompare([]byte(current.Code), []byte(code)) != 1 {
+		return ErrInvalidVerificationCode
 	}
+	return nil
+}
 
-	// Check if the code matches
-	if user.VerificationCode != code {
-		return fmt.Errorf("رمز التاكيد خاطئ")
+func (u *UserDB) VerifyUser(userID uuid.UUID, code string) error {
+	if err := u.CheckVerificationCode(userID, code); err != nil {
+		return err
 	}
 
-	// Mark the user as verified
+	// Mark the user as verified. Proving the email claims an imported account.
 	query, args, err := QB.Update("users").
 		Set("verified", true).
+		Set("placeholder", false).
 		Where(squirrel.Eq{"id": userID}).
 		ToSql()
 	if err != nil {
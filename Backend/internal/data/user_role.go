@@ -1,9 +1,12 @@
 package data
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/url"
 	"project/utils"
+	"strings"
 
 	"github.com/Masterminds/squirrel"
 	"github.com/google/uuid"
@@ -17,6 +20,16 @@ type UserRole struct {
 	Role_id int64     `db:"role_id" json:"role_id"`
 }
 
+// Roles seeded by the role migration. Access checks go through permissions;
+// the names are for the places that move users between these roles.
+const (
+	RoleAdmin             = "admin"
+	RoleTeacher           = "teacher"
+	RoleStudent           = "student"
+	RoleGraduationStudent = "graduation_student"
+	RoleGraduatedStudent  = "graduated_student"
+)
+
 // BookDB handles all operations related to books.
 type UserRoleDB struct {
 	db *sqlx.DB
@@ -43,6 +56,34 @@ func (u *UserRoleDB) GrantRole(userID uuid.UUID, roleID int) error {
 	return nil
 }
 
+// GrantRoleByName assigns the named role to a user.
+func (u *UserRoleDB) GrantRoleByName(userID uuid.UUID, role string) error {
+	result, err := u.db.Exec(`
+		INSERT INTO user_roles (user_id, role_id)
+		SELECT $1, id FROM roles WHERE name = $2`, userID, role)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrHasRole
+		}
+		return fmt.Errorf("error executing query: %v", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// RevokeRoleByName removes the named role from a user.
+func (u *UserRoleDB) RevokeRoleByName(userID uuid.UUID, role string) error {
+	_, err := u.db.Exec(`
+		DELETE FROM user_roles
+		WHERE user_id = $1 AND role_id = (SELECT id FROM roles WHERE name = $2)`, userID, role)
+	if err != nil {
+		return fmt.Errorf("error executing query: %v", err)
+	}
+	return nil
+}
+
 // RevokeRole removes a specific role from a user
 func (u *UserRoleDB) RevokeRole(userID uuid.UUID, roleID int) error {
 	query, args, err := QB.Delete("user_roles").
@@ -60,6 +101,27 @@ func (u *UserRoleDB) RevokeRole(userID uuid.UUID, roleID int) error {
 	return nil
 }
 
+// GetRolesVersion returns the user's roles with the version they are at,
+// which changes with every grant and revocation.
+func (u *UserRoleDB) GetRolesVersion(userID uuid.UUID) ([]string, int, error) {
+	var result struct {
+		Roles   pq.StringArray `db:"roles"`
+		Version int            `db:"roles_version"`
+	}
+	err := u.db.Get(&result, `
+		SELECT users.roles_version, ARRAY(
+			SELECT roles.name FROM user_roles JOIN roles ON user_roles.role_id = roles.id
+			WHERE user_roles.user_id = users.id) AS roles
+		FROM users WHERE users.id = $1`, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, 0, ErrUserNotFound
+		}
+		return nil, 0, fmt.Errorf("error executing query: %v", err)
+	}
+	return result.Roles, result.Version, nil
+}
+
 // GetUserRoles retrieves all roles assigned to a user
 func (u *UserRoleDB) GetUserRoles(userID uuid.UUID) ([]string, error) {
 	var roles []string
@@ -82,7 +144,7 @@ func (u *UserRoleDB) GetUserRoles(userID uuid.UUID) ([]string, error) {
 func (u *UserRoleDB) GetTeachers(queryParams url.Values) ([]User, *utils.Meta, error) {
 	// Define the base table, joins, columns, and searchable columns
 	table := "user_roles"
-	joins := []string{"users ON user_roles.user_id = users.id"} // Example join
+	joins := []string{"users ON user_roles.user_id = users.id", "roles ON user_roles.role_id = roles.id"}
 	columns := []string{
 		"users.id",
 		"users.name",
@@ -92,8 +154,8 @@ func (u *UserRoleDB) GetTeachers(queryParams url.Values) ([]User, *utils.Meta, e
 		"users.updated_at",
 		fmt.Sprintf("CASE WHEN NULLIF(users.image, '') IS NOT NULL THEN FORMAT('%s/%%s', users.image) ELSE NULL END AS image", Domain),
 	}
-	searchCols := []string{"users.name", "users.email"}     // Fields for search functionality
-	additionalFilters := []string{"user_roles.role_id = 2"} // Ensure only teachers are retrieved
+	searchCols := []string{"users.name", "users.email"}                                                                        // Fields for search functionality
+	additionalFilters := []squirrel.Sqlizer{squirrel.Eq{"roles.name": RoleTeacher}, squirrel.Expr("users.deleted_at IS NULL")} // Ensure only teachers are retrieved
 
 	// Prepare destination for query results
 	var users []User
@@ -109,7 +171,7 @@ func (u *UserRoleDB) GetTeachers(queryParams url.Values) ([]User, *utils.Meta, e
 func (u *UserRoleDB) GetStudents(queryParams url.Values) ([]User, *utils.Meta, error) {
 	// Define the base table, joins, columns, and searchable columns
 	table := "user_roles"
-	joins := []string{"users ON user_roles.user_id = users.id"} // Example join
+	joins := []string{"users ON user_roles.user_id = users.id", "roles ON user_roles.role_id = roles.id"}
 	columns := []string{
 		"users.id",
 		"users.name",
@@ -119,8 +181,8 @@ func (u *UserRoleDB) GetStudents(queryParams url.Values) ([]User, *utils.Meta, e
 		"users.updated_at",
 		fmt.Sprintf("CASE WHEN NULLIF(users.image, '') IS NOT NULL THEN FORMAT('%s/%%s', users.image) ELSE NULL END AS image", Domain),
 	}
-	searchCols := []string{"users.name", "users.email"}     // Fields for search functionality
-	additionalFilters := []string{"user_roles.role_id = 3"} // Ensure only teachers are retrieved
+	searchCols := []string{"users.name", "users.email"}                                                                        // Fields for search functionality
+	additionalFilters := []squirrel.Sqlizer{squirrel.Eq{"roles.name": RoleStudent}, squirrel.Expr("users.deleted_at IS NULL")} // Ensure only students are retrieved
 
 	// Prepare destination for query results
 	var users []User
@@ -147,11 +209,11 @@ func (u *UserRoleDB) GetGraduationStudents(queryParams url.Values) ([]User, *uti
 		fmt.Sprintf("CASE WHEN NULLIF(users.image, '') IS NOT NULL THEN FORMAT('%s/%%s', users.image) ELSE NULL END AS image", Domain),
 	}
 	searchCols := []string{"users.name", "users.email"}
-	additionalFilters := []string{}
+	additionalFilters := []squirrel.Sqlizer{squirrel.Expr("users.deleted_at IS NULL")}
 	roleIds := queryParams.Get("role_ids")
 	if roleIds != "" {
 		// Construct the filter for role IDs using IN clause
-		additionalFilters = append(additionalFilters, fmt.Sprintf("user_roles.role_id IN (%s)", roleIds))
+		additionalFilters = append(additionalFilters, squirrel.Eq{"user_roles.role_id": strings.Split(roleIds, ",")})
 	}
 	var users []User
 
@@ -163,14 +225,16 @@ func (u *UserRoleDB) GetGraduationStudents(queryParams url.Values) ([]User, *uti
 
 	return users, meta, nil
 }
-func (u *UserRoleDB) CountUsersWithRole(roleID int) (int, error) {
+func (u *UserRoleDB) CountUsersWithRole(role string) (int, error) {
 	var count int
 
 	// Build the query using squirrel
 	query, args, err := QB.Select("COUNT(users.id)").
 		From("user_roles").
 		Join("users ON user_roles.user_id = users.id").
-		Where(squirrel.Eq{"user_roles.role_id": roleID}).
+		Join("roles ON user_roles.role_id = roles.id").
+		Where(squirrel.Eq{"roles.name": role}).
+		Where("users.deleted_at IS NULL").
 		ToSql()
 	if err != nil {
 		return 0, fmt.Errorf("error building query: %v", err)
@@ -184,10 +248,13 @@ func (u *UserRoleDB) CountUsersWithRole(roleID int) (int, error) {
 
 	return count, nil
 }
-func (u *UserRoleDB) CountGraduationStudents(role int) (int, error) {
+func (u *UserRoleDB) CountGraduationStudents(role string) (int, error) {
 	query, args, err := QB.Select("COUNT(*)").
 		From("user_roles").
-		Where(squirrel.Eq{"role_id": role}).
+		Join("users ON user_roles.user_id = users.id").
+		Join("roles ON user_roles.role_id = roles.id").
+		Where(squirrel.Eq{"roles.name": role}).
+		Where("users.deleted_at IS NULL").
 		ToSql()
 
 	if err != nil {
@@ -0,0 +1,2 @@
+-- Accounts created by the book import for people that are not registered yet.
+ALTER TABLE users ADD COLUMN placeholder BOOLEAN NOT NULL DEFAULT FALSE;
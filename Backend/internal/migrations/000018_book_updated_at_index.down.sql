@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_book_updated_at_id;
@@ -0,0 +1,2 @@
+-- Incremental harvesting (OAI-PMH) walks books by modification time.
+CREATE INDEX IF NOT EXISTS idx_book_updated_at_id ON book (updated_at, id);
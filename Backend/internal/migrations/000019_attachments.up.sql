@@ -0,0 +1,21 @@
+-- Typed files attached to a book or a pre-project. Pre-project attachments are
+-- moved to the book when the pre-project is promoted.
+CREATE TABLE attachments (
+    id             uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    book_id        uuid REFERENCES book(id) ON DELETE CASCADE,
+    pre_project_id uuid REFERENCES pre_project(id) ON DELETE CASCADE,
+    type           VARCHAR(20) NOT NULL CHECK (type IN ('report', 'source', 'presentation', 'poster', 'dataset', 'other')),
+    title          VARCHAR(255) NOT NULL,
+    file           VARCHAR(255) NOT NULL,
+    original_name  VARCHAR(255) NOT NULL,
+    content_type   VARCHAR(255) NOT NULL,
+    size           BIGINT NOT NULL,
+    checksum       CHAR(64) NOT NULL,
+    position       INTEGER NOT NULL DEFAULT 0,
+    uploaded_by    uuid REFERENCES users(id) ON DELETE SET NULL,
+    created_at     TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((book_id IS NULL) <> (pre_project_id IS NULL))
+);
+
+CREATE INDEX idx_attachments_book_id ON attachments(book_id, position);
+CREATE INDEX idx_attachments_pre_project_id ON attachments(pre_project_id, position);
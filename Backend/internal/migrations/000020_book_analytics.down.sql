@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS book_daily_stats;
+DROP TABLE IF EXISTS book_events;
@@ -0,0 +1,23 @@
+-- One row per visitor, book, kind and day, used only to deduplicate. Visitors
+-- are a hash of the user ID or IP address and rows are pruned after a few days.
+CREATE TABLE book_events (
+    book_id    uuid NOT NULL REFERENCES book(id) ON DELETE CASCADE,
+    kind       VARCHAR(10) NOT NULL CHECK (kind IN ('view', 'download')),
+    visitor    CHAR(64) NOT NULL,
+    day        DATE NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (book_id, kind, visitor, day)
+);
+
+CREATE INDEX idx_book_events_day ON book_events(day);
+
+-- Daily counters of unique views and downloads per book.
+CREATE TABLE book_daily_stats (
+    book_id   uuid NOT NULL REFERENCES book(id) ON DELETE CASCADE,
+    day       DATE NOT NULL,
+    views     INTEGER NOT NULL DEFAULT 0,
+    downloads INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (book_id, day)
+);
+
+CREATE INDEX idx_book_daily_stats_day ON book_daily_stats(day);
@@ -0,0 +1,4 @@
+ALTER TABLE book
+    DROP CONSTRAINT IF EXISTS book_embargo_until_check,
+    DROP COLUMN IF EXISTS embargo_until,
+    DROP COLUMN IF EXISTS access_level;
@@ -0,0 +1,8 @@
+-- Who may download a book's files. Metadata stays public whatever the level;
+-- an embargo lifts by itself once embargo_until has passed.
+ALTER TABLE book
+    ADD COLUMN access_level VARCHAR(20) NOT NULL DEFAULT 'public'
+        CHECK (access_level IN ('public', 'authenticated', 'staff', 'embargoed')),
+    ADD COLUMN embargo_until TIMESTAMP,
+    ADD CONSTRAINT book_embargo_until_check
+        CHECK (access_level <> 'embargoed' OR embargo_until IS NOT NULL);
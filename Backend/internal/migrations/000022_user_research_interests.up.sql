@@ -0,0 +1,2 @@
+-- Free-form research interests, shown on teacher profiles.
+ALTER TABLE users ADD COLUMN research_interests TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,23 @@
+-- Deleted rows stay in place until the retention job purges them, so an
+-- accidental delete can be restored from the trash.
+ALTER TABLE book
+    ADD COLUMN deleted_at TIMESTAMP,
+    ADD COLUMN deleted_by uuid REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE post
+    ADD COLUMN deleted_at TIMESTAMP,
+    ADD COLUMN deleted_by uuid REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE users
+    ADD COLUMN deleted_at TIMESTAMP,
+    ADD COLUMN deleted_by uuid REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE pre_project
+    ADD COLUMN deleted_at TIMESTAMP,
+    ADD COLUMN deleted_by uuid REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE conversations
+    ADD COLUMN deleted_at TIMESTAMP,
+    ADD COLUMN deleted_by uuid REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_book_deleted_at ON book(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX idx_post_deleted_at ON post(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX idx_users_deleted_at ON users(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX idx_pre_project_deleted_at ON pre_project(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX idx_conversations_deleted_at ON conversations(deleted_at) WHERE deleted_at IS NOT NULL;
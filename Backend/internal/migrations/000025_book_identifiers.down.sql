@@ -0,0 +1,4 @@
+ALTER TABLE book DROP CONSTRAINT IF EXISTS book_identifier_key;
+ALTER TABLE book DROP COLUMN IF EXISTS identifier;
+DROP TABLE IF EXISTS book_identifiers;
+DROP TABLE IF EXISTS book_identifier_sequences;
@@ -0,0 +1,40 @@
+-- Persistent identifiers such as PA-2024-F-0012: the year, the season's
+-- initial and a sequence within that term. book.identifier is the one to
+-- cite; book_identifiers keeps every identifier a book was ever given, so an
+-- old one keeps resolving after the book moves to another term.
+CREATE TABLE book_identifier_sequences (
+    year       INTEGER NOT NULL,
+    season     VARCHAR(10) NOT NULL,
+    last_value INTEGER NOT NULL,
+    PRIMARY KEY (year, season)
+);
+
+CREATE TABLE book_identifiers (
+    identifier VARCHAR(32) PRIMARY KEY,
+    book_id    uuid NOT NULL REFERENCES book(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_book_identifiers_book ON book_identifiers(book_id);
+
+ALTER TABLE book ADD COLUMN identifier VARCHAR(32);
+
+WITH numbered AS (
+    SELECT id, year, season,
+        ROW_NUMBER() OVER (PARTITION BY year, season ORDER BY created_at, id) AS seq
+    FROM book
+)
+UPDATE book b
+SET identifier = FORMAT('PA-%s-%s-%s', n.year, UPPER(LEFT(n.season, 1)),
+    LPAD(n.seq::text, GREATEST(4, LENGTH(n.seq::text)), '0'))
+FROM numbered n
+WHERE n.id = b.id;
+
+INSERT INTO book_identifiers (identifier, book_id, created_at)
+SELECT identifier, id, created_at FROM book;
+
+INSERT INTO book_identifier_sequences (year, season, last_value)
+SELECT year, season, COUNT(*) FROM book GROUP BY year, season;
+
+ALTER TABLE book ALTER COLUMN identifier SET NOT NULL;
+ALTER TABLE book ADD CONSTRAINT book_identifier_key UNIQUE (identifier);
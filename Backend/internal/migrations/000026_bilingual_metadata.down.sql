@@ -0,0 +1,18 @@
+DROP INDEX IF EXISTS idx_pre_project_keywords;
+DROP INDEX IF EXISTS idx_book_keywords;
+
+ALTER TABLE pre_project
+    DROP COLUMN IF EXISTS language,
+    DROP COLUMN IF EXISTS keywords,
+    DROP COLUMN IF EXISTS abstract_en,
+    DROP COLUMN IF EXISTS abstract_ar,
+    DROP COLUMN IF EXISTS title_en,
+    DROP COLUMN IF EXISTS title_ar;
+
+ALTER TABLE book
+    DROP COLUMN IF EXISTS language,
+    DROP COLUMN IF EXISTS keywords,
+    DROP COLUMN IF EXISTS abstract_en,
+    DROP COLUMN IF EXISTS abstract_ar,
+    DROP COLUMN IF EXISTS title_en,
+    DROP COLUMN IF EXISTS title_ar;
@@ -0,0 +1,36 @@
+-- Arabic and English titles and abstracts, keywords and the language the
+-- project is written in. name and description stay the title and abstract
+-- shown when no language is asked for.
+ALTER TABLE book
+    ADD COLUMN title_ar    TEXT,
+    ADD COLUMN title_en    TEXT,
+    ADD COLUMN abstract_ar TEXT,
+    ADD COLUMN abstract_en TEXT,
+    ADD COLUMN keywords    TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN language    VARCHAR(10) NOT NULL DEFAULT 'ar';
+
+ALTER TABLE pre_project
+    ADD COLUMN title_ar    TEXT,
+    ADD COLUMN title_en    TEXT,
+    ADD COLUMN abstract_ar TEXT,
+    ADD COLUMN abstract_en TEXT,
+    ADD COLUMN keywords    TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN language    VARCHAR(10) NOT NULL DEFAULT 'ar';
+
+-- Existing projects are filed under the language their name is written in.
+UPDATE book SET
+    language    = CASE WHEN name ~ '[\u0600-\u06FF]' THEN 'ar' ELSE 'en' END,
+    title_ar    = CASE WHEN name ~ '[\u0600-\u06FF]' THEN name END,
+    title_en    = CASE WHEN name ~ '[\u0600-\u06FF]' THEN NULL ELSE name END,
+    abstract_ar = CASE WHEN name ~ '[\u0600-\u06FF]' THEN description END,
+    abstract_en = CASE WHEN name ~ '[\u0600-\u06FF]' THEN NULL ELSE description END;
+
+UPDATE pre_project SET
+    language    = CASE WHEN name ~ '[\u0600-\u06FF]' THEN 'ar' ELSE 'en' END,
+    title_ar    = CASE WHEN name ~ '[\u0600-\u06FF]' THEN name END,
+    title_en    = CASE WHEN name ~ '[\u0600-\u06FF]' THEN NULL ELSE name END,
+    abstract_ar = CASE WHEN name ~ '[\u0600-\u06FF]' THEN description END,
+    abstract_en = CASE WHEN name ~ '[\u0600-\u06FF]' THEN NULL ELSE description END;
+
+CREATE INDEX idx_book_keywords ON book USING GIN (keywords);
+CREATE INDEX idx_pre_project_keywords ON pre_project USING GIN (keywords);
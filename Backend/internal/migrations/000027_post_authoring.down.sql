@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_post_publish_at;
+DROP INDEX IF EXISTS idx_post_author;
+
+ALTER TABLE post
+    DROP COLUMN IF EXISTS expires_at,
+    DROP COLUMN IF EXISTS publish_at,
+    DROP COLUMN IF EXISTS pinned,
+    DROP COLUMN IF EXISTS category,
+    DROP COLUMN IF EXISTS author_id;
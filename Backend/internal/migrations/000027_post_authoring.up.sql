@@ -0,0 +1,17 @@
+-- Posts record their author, who may edit or delete them along with the
+-- admins. Existing posts have no known author and are left to the admins.
+ALTER TABLE post
+    ADD COLUMN author_id  uuid REFERENCES users(id) ON DELETE SET NULL,
+    ADD COLUMN category   VARCHAR(20) NOT NULL DEFAULT 'announcement'
+        CHECK (category IN ('announcement', 'deadline', 'opportunity', 'event')),
+    ADD COLUMN pinned     BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN publish_at TIMESTAMP,
+    ADD COLUMN expires_at TIMESTAMP;
+
+UPDATE post SET publish_at = created_at;
+ALTER TABLE post
+    ALTER COLUMN publish_at SET NOT NULL,
+    ALTER COLUMN publish_at SET DEFAULT CURRENT_TIMESTAMP;
+
+CREATE INDEX idx_post_author ON post(author_id);
+CREATE INDEX idx_post_publish_at ON post(publish_at) WHERE deleted_at IS NULL;
@@ -0,0 +1,7 @@
+DROP VIEW IF EXISTS pre_project_members;
+
+ALTER TABLE post
+    DROP COLUMN IF EXISTS audience_pre_projects,
+    DROP COLUMN IF EXISTS audience_season,
+    DROP COLUMN IF EXISTS audience_year,
+    DROP COLUMN IF EXISTS audience_roles;
@@ -0,0 +1,28 @@
+-- Who a post is for. A post with no audience is public. Otherwise a viewer
+-- must hold one of audience_roles if any are set, take part in a pre-project
+-- of audience_year and audience_season if either is set, and take part in one
+-- of audience_pre_projects if any are listed. The pre-projects are kept
+-- without a foreign key so a post never turns public when one of them is
+-- deleted.
+ALTER TABLE post
+    ADD COLUMN audience_roles        TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN audience_year         INTEGER,
+    ADD COLUMN audience_season       VARCHAR(10),
+    ADD COLUMN audience_pre_projects uuid[] NOT NULL DEFAULT '{}';
+
+-- Everyone taking part in a pre-project that is not in the trash.
+CREATE VIEW pre_project_members AS
+    SELECT pp.id AS pre_project_id, pp.project_owner AS user_id
+    FROM pre_project pp WHERE pp.deleted_at IS NULL
+    UNION
+    SELECT pps.pre_project_id, pps.student_id
+    FROM pre_project_students pps JOIN pre_project pp ON pp.id = pps.pre_project_id
+    WHERE pp.deleted_at IS NULL
+    UNION
+    SELECT ar.pre_project_id, ar.advisor_id
+    FROM advisor_responses ar JOIN pre_project pp ON pp.id = ar.pre_project_id
+    WHERE pp.deleted_at IS NULL
+    UNION
+    SELECT ppd.pre_project_id, ppd.discussant_id
+    FROM pre_project_discussants ppd JOIN pre_project pp ON pp.id = ppd.pre_project_id
+    WHERE pp.deleted_at IS NULL;
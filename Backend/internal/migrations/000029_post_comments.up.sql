@@ -0,0 +1,23 @@
+-- Comments on posts, with one level of replies: parent_id names a top-level
+-- comment of the same post. Removing a comment removes its replies.
+CREATE TABLE post_comments (
+    id         uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    post_id    uuid NOT NULL REFERENCES post(id) ON DELETE CASCADE,
+    parent_id  uuid REFERENCES post_comments(id) ON DELETE CASCADE,
+    author_id  uuid REFERENCES users(id) ON DELETE SET NULL,
+    body       TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_post_comments_post ON post_comments(post_id, created_at) WHERE parent_id IS NULL;
+CREATE INDEX idx_post_comments_parent ON post_comments(parent_id, created_at);
+
+-- One row per user and emoji they reacted to a post with.
+CREATE TABLE post_reactions (
+    post_id    uuid NOT NULL REFERENCES post(id) ON DELETE CASCADE,
+    user_id    uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    emoji      VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (post_id, user_id, emoji)
+);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS post_acknowledgements;
+
+ALTER TABLE post
+    DROP COLUMN IF EXISTS ack_reminded_at,
+    DROP COLUMN IF EXISTS requires_ack;
@@ -0,0 +1,11 @@
+ALTER TABLE post
+    ADD COLUMN requires_ack BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN ack_reminded_at TIMESTAMP;
+
+-- Who confirmed having read a post that asks for it.
+CREATE TABLE post_acknowledgements (
+    post_id         uuid NOT NULL REFERENCES post(id) ON DELETE CASCADE,
+    user_id         uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    acknowledged_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (post_id, user_id)
+);
@@ -0,0 +1,18 @@
+-- A signed-in device. Access tokens name their session, which must still be
+-- active; the refresh token is stored hashed and replaced on every use. The
+-- one it replaced is kept so a stolen, already used token can be detected.
+CREATE TABLE sessions (
+    id                  uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id             uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    refresh_token_hash  TEXT NOT NULL UNIQUE,
+    previous_token_hash TEXT,
+    user_agent          TEXT NOT NULL DEFAULT '',
+    ip                  TEXT NOT NULL DEFAULT '',
+    created_at          TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at        TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at          TIMESTAMP NOT NULL,
+    revoked_at          TIMESTAMP
+);
+
+CREATE INDEX idx_sessions_user ON sessions(user_id) WHERE revoked_at IS NULL;
+CREATE INDEX idx_sessions_previous_token ON sessions(previous_token_hash);
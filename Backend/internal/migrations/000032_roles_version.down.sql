@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS user_roles_version ON user_roles;
+DROP FUNCTION IF EXISTS bump_roles_version();
+ALTER TABLE users DROP COLUMN IF EXISTS roles_version;
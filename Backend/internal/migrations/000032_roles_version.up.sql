@@ -0,0 +1,19 @@
+-- Counts changes to a user's roles. Access tokens carry the version they were
+-- issued at, so a token from before a grant or revocation is recognised.
+ALTER TABLE users ADD COLUMN roles_version INTEGER NOT NULL DEFAULT 0;
+
+CREATE FUNCTION bump_roles_version() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        UPDATE users SET roles_version = roles_version + 1 WHERE id = OLD.user_id;
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        UPDATE users SET roles_version = roles_version + 1 WHERE id = NEW.user_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER user_roles_version
+    AFTER INSERT OR UPDATE OR DELETE ON user_roles
+    FOR EACH ROW EXECUTE FUNCTION bump_roles_version();
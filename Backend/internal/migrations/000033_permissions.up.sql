@@ -0,0 +1,69 @@
+-- What each role may do. Handlers check permissions rather than role names,
+-- so a new role only needs rows here.
+CREATE TABLE permissions (
+    name VARCHAR(64) PRIMARY KEY,
+    description TEXT NOT NULL
+);
+
+CREATE TABLE role_permissions (
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    permission VARCHAR(64) NOT NULL REFERENCES permissions(name) ON UPDATE CASCADE ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission)
+);
+
+INSERT INTO permissions (name, description)
+VALUES
+    ('books.manage', 'Create, edit and delete books and their attachments, and import books'),
+    ('books.export', 'Export books and pre-projects'),
+    ('books.view_restricted', 'Read books restricted to their participants'),
+    ('analytics.view', 'View analytics of the books one advises'),
+    ('analytics.view_all', 'View analytics of every book'),
+    ('posts.publish', 'Publish posts and manage one''s own posts'),
+    ('posts.moderate', 'Manage every post and see every audience'),
+    ('users.manage', 'List, create, edit and delete users and their sessions'),
+    ('users.list_students', 'List students and graduation students'),
+    ('roles.manage', 'Grant and revoke roles, and edit roles and their permissions'),
+    ('audit.view', 'Read the audit log'),
+    ('trash.manage', 'List and restore deleted records'),
+    ('preprojects.create', 'Submit pre-projects'),
+    ('preprojects.manage', 'Manage every pre-project and move pre-projects to the books'),
+    ('preprojects.skip_similarity', 'Submit pre-projects without the similarity check'),
+    ('conversations.use', 'Read conversations')
+ON CONFLICT (name) DO NOTHING;
+
+-- Reproduces what the role middlewares allowed.
+INSERT INTO role_permissions (role_id, permission)
+SELECT roles.id, grants.permission
+FROM (VALUES
+    ('admin', 'books.manage'),
+    ('admin', 'books.export'),
+    ('admin', 'books.view_restricted'),
+    ('admin', 'analytics.view'),
+    ('admin', 'analytics.view_all'),
+    ('admin', 'posts.publish'),
+    ('admin', 'posts.moderate'),
+    ('admin', 'users.manage'),
+    ('admin', 'users.list_students'),
+    ('admin', 'roles.manage'),
+    ('admin', 'audit.view'),
+    ('admin', 'trash.manage'),
+    ('admin', 'preprojects.create'),
+    ('admin', 'preprojects.manage'),
+    ('admin', 'preprojects.skip_similarity'),
+    ('admin', 'conversations.use'),
+    ('teacher', 'books.view_restricted'),
+    ('teacher', 'analytics.view'),
+    ('teacher', 'posts.publish'),
+    ('teacher', 'preprojects.create'),
+    ('teacher', 'preprojects.skip_similarity'),
+    ('teacher', 'conversations.use'),
+    ('student', 'conversations.use'),
+    ('graduation_student', 'users.list_students'),
+    ('graduation_student', 'preprojects.create'),
+    ('graduation_student', 'conversations.use')
+) AS grants (role, permission)
+JOIN roles ON roles.name = grants.role
+ON CONFLICT DO NOTHING;
+
+-- Roles added after the seed get the next free id.
+SELECT setval(pg_get_serial_sequence('roles', 'id'), GREATEST((SELECT MAX(id) FROM roles), 1));
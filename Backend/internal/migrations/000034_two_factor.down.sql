@@ -0,0 +1,4 @@
+ALTER TABLE roles DROP COLUMN IF EXISTS require_two_factor;
+DROP TABLE IF EXISTS login_challenges;
+DROP TABLE IF EXISTS user_recovery_codes;
+DROP TABLE IF EXISTS user_two_factor;
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS verification_code_attempts;
+DROP TABLE IF EXISTS login_failures;
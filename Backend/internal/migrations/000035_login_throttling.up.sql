@@ -0,0 +1,14 @@
+-- Recent failed sign-ins per account, per IP address and per second-factor
+-- challenge holder. Failures older than the policy's window no longer count.
+CREATE TABLE login_failures (
+    scope VARCHAR(16) NOT NULL,
+    key TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (scope, key)
+);
+
+-- Guesses made at the current verification or reset code. Issuing a new code
+-- starts the count again.
+ALTER TABLE users ADD COLUMN verification_code_attempts INTEGER NOT NULL DEFAULT 0;
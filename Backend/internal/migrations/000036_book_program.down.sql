@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS book_tombstones;
+ALTER TABLE book DROP COLUMN IF EXISTS program;
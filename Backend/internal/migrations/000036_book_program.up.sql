@@ -0,0 +1,18 @@
+-- The degree program a book was submitted under, as a short code such as
+-- "cs" or "software-engineering". OAI-PMH harvesters get a set per program.
+ALTER TABLE book ADD COLUMN program VARCHAR(64);
+
+CREATE INDEX idx_book_program ON book(program) WHERE program IS NOT NULL;
+
+-- What is left of a book once the trash is purged, so harvesters keep being
+-- told it was deleted. deleted_at is the datestamp it was last reported with.
+CREATE TABLE book_tombstones (
+    id         uuid PRIMARY KEY,
+    identifier VARCHAR(32),
+    year       INTEGER NOT NULL,
+    season     VARCHAR(10) NOT NULL,
+    program    VARCHAR(64),
+    deleted_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_book_tombstones_deleted_at ON book_tombstones(deleted_at, id);
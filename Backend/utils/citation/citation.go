@@ -0,0 +1,230 @@
+package citation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var ErrUnknownFormat = errors.New("unknown citation format, expected bibtex, ris or csl-json")
+
+// Work is an archived project in the shape every citation format needs.
+type Work struct {
+	Key          string // citation key, unique within one response
+	Title        string
+	Abstract     string
+	Authors      []string // students
+	Contributors []string // advisors
+	Year         int
+	Season       string // "spring" or "fall"
+	URL          string
+}
+
+type Format struct {
+	Name        string
+	ContentType string
+	Extension   string
+}
+
+var formats = map[string]Format{
+	"bibtex":   {Name: "bibtex", ContentType: "application/x-bibtex; charset=utf-8", Extension: "bib"},
+	"ris":      {Name: "ris", ContentType: "application/x-research-info-systems; charset=utf-8", Extension: "ris"},
+	"csl-json": {Name: "csl-json", ContentType: "application/vnd.citationstyles.csl+json; charset=utf-8", Extension: "json"},
+}
+
+// LookupFormat resolves the ?format= value, defaulting to BibTeX.
+func LookupFormat(name string) (Format, error) {
+	if name == "" {
+		name = "bibtex"
+	}
+	format, ok := formats[strings.ToLower(name)]
+	if !ok {
+		return Format{}, ErrUnknownFormat
+	}
+	return format, nil
+}
+
+// Write renders the works in the given format.
+func Write(w io.Writer, format Format, works []Work) error {
+	switch format.Name {
+	case "bibtex":
+		return writeBibTeX(w, works)
+	case "ris":
+		return writeRIS(w, works)
+	case "csl-json":
+		return writeCSLJSON(w, works)
+	default:
+		return ErrUnknownFormat
+	}
+}
+
+func seasonTitle(season string) string {
+	switch season {
+	case "spring":
+		return "Spring"
+	case "fall":
+		return "Fall"
+	default:
+		return season
+	}
+}
+
+var bibtexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`&`, `\&`,
+	`%`, `\%`,
+	`$`, `\$`,
+	`#`, `\#`,
+	`_`, `\_`,
+	`~`, `\textasciitilde{}`,
+	`^`, `\textasciicircum{}`,
+)
+
+// bibtexNames joins names with "and". Each name is braced so BibTeX keeps it
+// whole instead of guessing first and last names, which fails for Arabic names.
+func bibtexNames(names []string) string {
+	braced := make([]string, len(names))
+	for i, name := range names {
+		braced[i] = "{" + bibtexEscaper.Replace(name) + "}"
+	}
+	return strings.Join(braced, " and ")
+}
+
+func writeBibTeX(w io.Writer, works []Work) error {
+	for i, work := range works {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+
+		fields := [][2]string{
+			{"title", "{" + bibtexEscaper.Replace(work.Title) + "}"},
+		}
+		if len(work.Authors) > 0 {
+			fields = append(fields, [2]string{"author", bibtexNames(work.Authors)})
+		}
+		if len(work.Contributors) > 0 {
+			fields = append(fields, [2]string{"editor", bibtexNames(work.Contributors)},
+				[2]string{"editortype", "{supervisor}"})
+		}
+		fields = append(fields,
+			[2]string{"year", fmt.Sprintf("{%d}", work.Year)},
+			[2]string{"note", fmt.Sprintf("{Graduation project, %s %d}", seasonTitle(work.Season), work.Year)},
+			[2]string{"url", "{" + work.URL + "}"},
+		)
+		if work.Abstract != "" {
+			fields = append(fields, [2]string{"abstract", "{" + bibtexEscaper.Replace(work.Abstract) + "}"})
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "@misc{%s,\n", work.Key)
+		for j, field := range fields {
+			fmt.Fprintf(&sb, "  %s = %s", field[0], field[1])
+			if j < len(fields)-1 {
+				sb.WriteString(",")
+			}
+			sb.WriteString("\n")
+		}
+		sb.WriteString("}\n")
+
+		if _, err := io.WriteString(w, sb.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// risLine drops line breaks, since every RIS tag must fit on a single line.
+func risLine(sb *strings.Builder, tag, value string) {
+	value = strings.Join(strings.Fields(value), " ")
+	if value == "" {
+		return
+	}
+	fmt.Fprintf(sb, "%s  - %s\r\n", tag, value)
+}
+
+func writeRIS(w io.Writer, works []Work) error {
+	for _, work := range works {
+		var sb strings.Builder
+		risLine(&sb, "TY", "THES")
+		risLine(&sb, "ID", work.Key)
+		risLine(&sb, "TI", work.Title)
+		for _, author := range work.Authors {
+			risLine(&sb, "AU", author)
+		}
+		// A3 is the tertiary author, which reference managers map to the
+		// thesis advisor.
+		for _, contributor := range work.Contributors {
+			risLine(&sb, "A3", contributor)
+		}
+		risLine(&sb, "PY", fmt.Sprint(work.Year))
+		risLine(&sb, "DA", fmt.Sprintf("%d///%s", work.Year, seasonTitle(work.Season)))
+		risLine(&sb, "M3", "Graduation project")
+		risLine(&sb, "AB", work.Abstract)
+		risLine(&sb, "UR", work.URL)
+		sb.WriteString("ER  - \r\n")
+
+		if _, err := io.WriteString(w, sb.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type cslName struct {
+	Literal string `json:"literal"`
+}
+
+type cslDate struct {
+	DateParts [][]int `json:"date-parts"`
+	Season    int     `json:"season,omitempty"`
+}
+
+type cslItem struct {
+	ID          string    `json:"id"`
+	Type        string    `json:"type"`
+	Genre       string    `json:"genre"`
+	Title       string    `json:"title"`
+	Abstract    string    `json:"abstract,omitempty"`
+	Author      []cslName `json:"author,omitempty"`
+	Contributor []cslName `json:"contributor,omitempty"`
+	Issued      cslDate   `json:"issued"`
+	URL         string    `json:"URL"`
+}
+
+// cslSeasons follows the CSL season numbering (1 spring ... 4 winter).
+var cslSeasons = map[string]int{"spring": 1, "fall": 3}
+
+func cslNames(names []string) []cslName {
+	result := make([]cslName, len(names))
+	for i, name := range names {
+		result[i] = cslName{Literal: name}
+	}
+	return result
+}
+
+func writeCSLJSON(w io.Writer, works []Work) error {
+	items := make([]cslItem, len(works))
+	for i, work := range works {
+		items[i] = cslItem{
+			ID:          work.Key,
+			Type:        "thesis",
+			Genre:       "Graduation project",
+			Title:       work.Title,
+			Abstract:    work.Abstract,
+			Author:      cslNames(work.Authors),
+			Contributor: cslNames(work.Contributors),
+			Issued:      cslDate{DateParts: [][]int{{work.Year}}, Season: cslSeasons[work.Season]},
+			URL:         work.URL,
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(items)
+}
@@ -0,0 +1,273 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidSort   = errors.New("invalid sort value")
+)
+
+const (
+	defaultCursorPageSize = 20
+	maxCursorPageSize     = 100
+)
+
+// CursorConfig describes how a listing can be paged with keyset cursors.
+type CursorConfig struct {
+	IDColumn    string            // SQL expression of the unique tiebreaker, e.g. "b.id"
+	IDField     string            // db tag of the tiebreaker in the destination struct
+	SortColumns map[string]string // sort key -> SQL expression, the key must match a db tag
+	DefaultSort string            // e.g. "-created_at"
+}
+
+type CursorMeta struct {
+	PerPage    int     `json:"per_page"`
+	NextCursor *string `json:"next_cursor"`
+	PrevCursor *string `json:"prev_cursor"`
+	Total      *int    `json:"total,omitempty"`
+}
+
+// cursorToken is the decoded form of the opaque cursor handed to clients.
+type cursorToken struct {
+	Sort     string      `json:"s"`
+	Value    interface{} `json:"v"`
+	ID       interface{} `json:"id"`
+	Backward bool        `json:"b,omitempty"`
+}
+
+// UseCursor reports whether the request opted into keyset pagination.
+func UseCursor(queryParams url.Values) bool {
+	return queryParams.Get("pagination") == "cursor" || queryParams.Get("cursor") != ""
+}
+
+func encodeCursor(token cursorToken) (string, error) {
+	raw, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeCursor(cursor string) (*cursorToken, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	dec.UseNumber()
+
+	var token cursorToken
+	if err := dec.Decode(&token); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if token.Sort == "" || token.Value == nil || token.ID == nil {
+		return nil, ErrInvalidCursor
+	}
+	return &token, nil
+}
+
+// cursorArg converts a decoded cursor value into a query argument.
+func cursorArg(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return v, nil
+	default:
+		return nil, ErrInvalidCursor
+	}
+}
+
+// fieldByTag finds the struct field carrying the given db tag, looking into
+// embedded structs the same way sqlx does when scanning.
+func fieldByTag(v reflect.Value, tag string) (reflect.Value, bool) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Value{}, false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("db") == tag {
+			return v.Field(i), true
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Anonymous {
+			if found, ok := fieldByTag(v.Field(i), tag); ok {
+				return found, true
+			}
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func cursorForRow(row reflect.Value, sort string, cfg CursorConfig, backward bool) (*string, error) {
+	sortField, ok := fieldByTag(row, strings.TrimPrefix(sort, "-"))
+	if !ok {
+		return nil, fmt.Errorf("sort field %q is not selected", sort)
+	}
+	idField, ok := fieldByTag(row, cfg.IDField)
+	if !ok {
+		return nil, fmt.Errorf("id field %q is not selected", cfg.IDField)
+	}
+
+	// Round-trip through JSON so times, UUIDs and numbers get their wire form.
+	encoded, err := encodeCursor(cursorToken{
+		Sort:     sort,
+		Value:    sortField.Interface(),
+		ID:       idField.Interface(),
+		Backward: backward,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &encoded, nil
+}
+
+// BuildCursorQuery is the keyset counterpart of BuildQuery. Instead of
+// page/per_page it reads an opaque "cursor" and walks the listing ordered by
+// the sort key plus the ID, so inserts between requests never shift a page.
+// The total is only counted when include_total=true.
+func BuildCursorQuery(dest interface{}, table string,
+	joins []string, columns []string,
+	searchCols []string, queryParams url.Values,
+	additionalFilters []squirrel.Sqlizer, cfg CursorConfig) (*CursorMeta, error) {
+
+	perPage, _ := strconv.Atoi(queryParams.Get("per_page"))
+	if perPage <= 0 {
+		perPage = defaultCursorPageSize
+	}
+	if perPage > maxCursorPageSize {
+		perPage = maxCursorPageSize
+	}
+
+	sort := queryParams.Get("sort")
+	var token *cursorToken
+	if raw := queryParams.Get("cursor"); raw != "" {
+		decoded, err := decodeCursor(raw)
+		if err != nil {
+			return nil, err
+		}
+		token = decoded
+		// The cursor carries the ordering it was issued for.
+		sort = token.Sort
+	}
+	if sort == "" {
+		sort = cfg.DefaultSort
+	}
+
+	sortColumn, ok := cfg.SortColumns[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		return nil, ErrInvalidSort
+	}
+
+	descending := strings.HasPrefix(sort, "-")
+	backward := token != nil && token.Backward
+	scanDescending := descending != backward
+
+	sb := buildListSelect(table, joins, searchCols, queryParams, additionalFilters)
+
+	meta := &CursorMeta{PerPage: perPage}
+
+	if includeTotal, _ := ParseBoolOrDefault(queryParams.Get("include_total"), false); includeTotal {
+		countSQL, countArgs, err := sb.Column("COUNT(*)").ToSql()
+		if err != nil {
+			return nil, err
+		}
+
+		var total int
+		if err := db.QueryRow(countSQL, countArgs...).Scan(&total); err != nil {
+			return nil, err
+		}
+		meta.Total = &total
+	}
+
+	sb = sb.Columns(columns...)
+
+	if token != nil {
+		value, err := cursorArg(token.Value)
+		if err != nil {
+			return nil, err
+		}
+		id, err := cursorArg(token.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		op := ">"
+		if scanDescending {
+			op = "<"
+		}
+		sb = sb.Where(fmt.Sprintf("(%s, %s) %s (?, ?)", sortColumn, cfg.IDColumn, op), value, id)
+	}
+
+	direction := "ASC"
+	if scanDescending {
+		direction = "DESC"
+	}
+	sb = sb.OrderBy(sortColumn+" "+direction, cfg.IDColumn+" "+direction).
+		Limit(uint64(perPage + 1))
+
+	sql, args, err := sb.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Select(dest, sql, args...); err != nil {
+		return nil, err
+	}
+
+	rows := reflect.ValueOf(dest).Elem()
+	hasMore := rows.Len() > perPage
+	if hasMore {
+		rows.Set(rows.Slice(0, perPage))
+	}
+	if backward {
+		swap := reflect.Swapper(rows.Interface())
+		for i, j := 0, rows.Len()-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+
+	if rows.Len() == 0 {
+		return meta, nil
+	}
+
+	// Walking forwards there is a next page only if we over-fetched; walking
+	// backwards we came from the next page, so it always exists.
+	if hasMore || backward {
+		meta.NextCursor, err = cursorForRow(rows.Index(rows.Len()-1), sort, cfg, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if (backward && hasMore) || (!backward && token != nil) {
+		meta.PrevCursor, err = cursorForRow(rows.Index(0), sort, cfg, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return meta, nil
+}
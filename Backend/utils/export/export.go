@@ -0,0 +1,212 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format, expected csv, xlsx or jsonl")
+
+// Row is a single exported record. Tabular formats use Values, which must line
+// up with the headers the writer was created with; JSON Lines encodes the row
+// itself.
+type Row interface {
+	Values() []string
+}
+
+// Writer streams rows to an underlying io.Writer without buffering the whole
+// export in memory.
+type Writer interface {
+	WriteRow(row Row) error
+	Close() error
+}
+
+// Format describes an export format as exposed over HTTP.
+type Format struct {
+	Name        string
+	ContentType string
+	Extension   string
+}
+
+var formats = map[string]Format{
+	"csv":   {Name: "csv", ContentType: "text/csv; charset=utf-8", Extension: "csv"},
+	"xlsx":  {Name: "xlsx", ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", Extension: "xlsx"},
+	"jsonl": {Name: "jsonl", ContentType: "application/x-ndjson", Extension: "jsonl"},
+}
+
+// LookupFormat resolves the ?format= value, defaulting to CSV.
+func LookupFormat(name string) (Format, error) {
+	if name == "" {
+		name = "csv"
+	}
+	format, ok := formats[strings.ToLower(name)]
+	if !ok {
+		return Format{}, ErrUnknownFormat
+	}
+	return format, nil
+}
+
+// NewWriter creates a writer for the given format. sheet names the XLSX worksheet.
+func NewWriter(format Format, w io.Writer, headers []string, sheet string) (Writer, error) {
+	switch format.Name {
+	case "csv":
+		return newCSVWriter(w, headers)
+	case "xlsx":
+		return newXLSXWriter(w, headers, sheet)
+	case "jsonl":
+		return &jsonLinesWriter{enc: json.NewEncoder(w)}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer, headers []string) (*csvWriter, error) {
+	// A UTF-8 BOM so spreadsheet applications detect Arabic text correctly.
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return nil, err
+	}
+	cw := &csvWriter{w: csv.NewWriter(w)}
+	if err := cw.w.Write(headers); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (c *csvWriter) WriteRow(row Row) error {
+	return c.w.Write(row.Values())
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonLinesWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonLinesWriter) WriteRow(row Row) error {
+	return j.enc.Encode(row)
+}
+
+func (j *jsonLinesWriter) Close() error {
+	return nil
+}
+
+// xlsxWriter writes a minimal single-sheet workbook. The static parts are
+// written up front so the worksheet can be streamed as the last zip entry.
+type xlsxWriter struct {
+	zw    *zip.Writer
+	sheet *bufio.Writer
+	row   int
+}
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`
+
+func newXLSXWriter(w io.Writer, headers []string, sheet string) (*xlsxWriter, error) {
+	zw := zip.NewWriter(w)
+
+	parts := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, escapeXML(sheet))},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	xw := &xlsxWriter{zw: zw, sheet: bufio.NewWriter(f)}
+	xw.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	if err := xw.writeCells(headers); err != nil {
+		return nil, err
+	}
+	return xw, nil
+}
+
+func (x *xlsxWriter) writeCells(values []string) error {
+	x.row++
+	fmt.Fprintf(x.sheet, `<row r="%d">`, x.row)
+	for _, value := range values {
+		x.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+		x.sheet.WriteString(escapeXML(value))
+		x.sheet.WriteString(`</t></is></c>`)
+	}
+	_, err := x.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (x *xlsxWriter) WriteRow(row Row) error {
+	return x.writeCells(row.Values())
+}
+
+func (x *xlsxWriter) Close() error {
+	if _, err := x.sheet.WriteString(`</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.zw.Close()
+}
+
+// escapeXML escapes text for an XML body and drops characters XML 1.0 forbids.
+func escapeXML(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' || r >= 0x20 && r != 0xFFFE && r != 0xFFFF {
+			return r
+		}
+		return -1
+	}, value)
+
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(value))
+	return sb.String()
+}
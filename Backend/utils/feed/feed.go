@@ -0,0 +1,253 @@
+package feed
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnknownFormat = errors.New("unknown feed format, expected atom or rss")
+
+// Feed is a syndication feed in the shape both Atom and RSS need.
+type Feed struct {
+	ID          string // stable URI identifying the feed
+	Title       string
+	Description string
+	Link        string // the page the feed describes
+	Self        string // the feed's own URL
+	Updated     time.Time
+	Entries     []Entry
+}
+
+type Entry struct {
+	ID        string
+	Title     string
+	Summary   string
+	Link      string
+	Authors   []string
+	Published time.Time
+	Updated   time.Time
+	Enclosure *Enclosure
+}
+
+// Enclosure is a file attached to an entry.
+type Enclosure struct {
+	URL    string
+	Type   string
+	Length int64
+}
+
+type Format struct {
+	Name        string
+	ContentType string
+}
+
+var formats = map[string]Format{
+	"atom": {Name: "atom", ContentType: "application/atom+xml; charset=utf-8"},
+	"rss":  {Name: "rss", ContentType: "application/rss+xml; charset=utf-8"},
+}
+
+// LookupFormat resolves the ?format= value, defaulting to Atom.
+func LookupFormat(name string) (Format, error) {
+	if name == "" {
+		name = "atom"
+	}
+	format, ok := formats[strings.ToLower(name)]
+	if !ok {
+		return Format{}, ErrUnknownFormat
+	}
+	return format, nil
+}
+
+// Write renders the feed in the given format.
+func Write(w io.Writer, format Format, feed Feed) error {
+	var doc interface{}
+	switch format.Name {
+	case "atom":
+		doc = atomDocument(feed)
+	case "rss":
+		doc = rssDocument(feed)
+	default:
+		return ErrUnknownFormat
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// Atom 1.0, RFC 4287.
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"feed"`
+	Xmlns    string      `xml:"xmlns,attr"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length string `xml:"length,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID        string       `xml:"id"`
+	Title     string       `xml:"title"`
+	Updated   string       `xml:"updated"`
+	Published string       `xml:"published,omitempty"`
+	Authors   []atomPerson `xml:"author"`
+	Summary   string       `xml:"summary,omitempty"`
+	Links     []atomLink   `xml:"link"`
+}
+
+func atomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func atomDocument(feed Feed) atomFeed {
+	doc := atomFeed{
+		Xmlns:    "http://www.w3.org/2005/Atom",
+		ID:       feed.ID,
+		Title:    feed.Title,
+		Subtitle: feed.Description,
+		Updated:  atomTime(feed.Updated),
+		Links: []atomLink{
+			{Href: feed.Self, Rel: "self", Type: "application/atom+xml"},
+			{Href: feed.Link, Rel: "alternate"},
+		},
+	}
+
+	for _, entry := range feed.Entries {
+		item := atomEntry{
+			ID:      entry.ID,
+			Title:   entry.Title,
+			Updated: atomTime(entry.Updated),
+			Summary: entry.Summary,
+			Links:   []atomLink{{Href: entry.Link, Rel: "alternate"}},
+		}
+		if !entry.Published.IsZero() {
+			item.Published = atomTime(entry.Published)
+		}
+		for _, author := range entry.Authors {
+			item.Authors = append(item.Authors, atomPerson{Name: author})
+		}
+		// Atom requires an author on every entry unless the feed has one.
+		if len(item.Authors) == 0 {
+			item.Authors = []atomPerson{{Name: feed.Title}}
+		}
+		if entry.Enclosure != nil {
+			link := atomLink{Href: entry.Enclosure.URL, Rel: "enclosure", Type: entry.Enclosure.Type}
+			if entry.Enclosure.Length > 0 {
+				link.Length = strconv.FormatInt(entry.Enclosure.Length, 10)
+			}
+			item.Links = append(item.Links, link)
+		}
+		doc.Entries = append(doc.Entries, item)
+	}
+	return doc
+}
+
+// RSS 2.0.
+
+type rssDocumentRoot struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Self          rssSelf   `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssSelf struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	Description string        `xml:"description,omitempty"`
+	Authors     []string      `xml:"dc:creator,omitempty"`
+	GUID        rssGUID       `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Enclosure   *rssEnclosure `xml:"enclosure,omitempty"`
+}
+
+func rssDocument(feed Feed) rssDocumentRoot {
+	channel := rssChannel{
+		Title:         feed.Title,
+		Link:          feed.Link,
+		Description:   feed.Description,
+		LastBuildDate: feed.Updated.UTC().Format(time.RFC1123Z),
+		Self:          rssSelf{Href: feed.Self, Rel: "self", Type: "application/rss+xml"},
+	}
+
+	for _, entry := range feed.Entries {
+		published := entry.Published
+		if published.IsZero() {
+			published = entry.Updated
+		}
+		item := rssItem{
+			Title:       entry.Title,
+			Link:        entry.Link,
+			Description: entry.Summary,
+			Authors:     entry.Authors,
+			GUID:        rssGUID{IsPermaLink: "false", Value: entry.ID},
+			PubDate:     published.UTC().Format(time.RFC1123Z),
+		}
+		if entry.Enclosure != nil {
+			item.Enclosure = &rssEnclosure{
+				URL:    entry.Enclosure.URL,
+				Length: entry.Enclosure.Length,
+				Type:   entry.Enclosure.Type,
+			}
+		}
+		channel.Items = append(channel.Items, item)
+	}
+
+	return rssDocumentRoot{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Channel: channel,
+	}
+}
@@ -0,0 +1,47 @@
+package markdown
+
+import (
+	"bytes"
+	"html"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// renderer turns CommonMark, plus strikethrough and bare links, into HTML.
+// Raw HTML in the source is dropped rather than passed through.
+var renderer = goldmark.New(goldmark.WithExtensions(extension.Strikethrough, extension.Linkify))
+
+// policy is the subset of HTML descriptions may render to: text formatting,
+// lists, quotes, code and links. Images, tables, scripts, styles and event
+// attributes are stripped, and links must be http, https or mailto.
+var policy = func() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "hr", "strong", "em", "del", "code", "pre", "blockquote",
+		"ul", "ol", "li", "h1", "h2", "h3", "h4", "h5", "h6")
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}()
+
+// HTML renders the Markdown source to sanitized HTML.
+func HTML(source string) string {
+	var buf bytes.Buffer
+	if err := renderer.Convert([]byte(source), &buf); err != nil {
+		// goldmark only fails on writer errors, which a buffer has none of.
+		return html.EscapeString(source)
+	}
+	return strings.TrimSpace(policy.Sanitize(buf.String()))
+}
+
+// Text is the text a reader sees once the source is rendered, with the
+// Markdown syntax gone. Length limits apply to it.
+func Text(source string) string {
+	return strings.TrimSpace(html.UnescapeString(bluemonday.StrictPolicy().Sanitize(HTML(source))))
+}
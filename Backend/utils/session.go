@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// Lifetimes of the tokens handed out at sign-in. Access tokens are short-lived
+// and renewed with the refresh token, which is replaced on every use.
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// RefreshTokenPath is the only path the refresh token cookie is sent to.
+const RefreshTokenPath = "/token/refresh"
+
+// GenerateRefreshToken returns a random opaque refresh token.
+func GenerateRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken is the form a refresh token is stored in, so a leaked sessions
+// table cannot be used to sign in.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func SetRefreshTokenCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refreshToken",
+		Value:    token,
+		Expires:  time.Now().Add(RefreshTokenTTL),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Path:     RefreshTokenPath,
+	})
+}
+
+// ClearTokenCookies removes both token cookies from the browser.
+func ClearTokenCookies(w http.ResponseWriter) {
+	for _, cookie := range []*http.Cookie{
+		{Name: "accessToken", Path: "/"},
+		{Name: "refreshToken", Path: RefreshTokenPath},
+	} {
+		cookie.MaxAge = -1
+		cookie.HttpOnly = true
+		cookie.Secure = true
+		http.SetCookie(w, cookie)
+	}
+}
@@ -54,6 +54,7 @@ var (
 	ErrExpiredToken  = errors.New("token has expired")
 	ErrMissingToken  = errors.New("missing authorization token")
 	ErrInvalidClaims = errors.New("invalid token claims")
+	ErrRevokedToken  = errors.New("session has been revoked")
 )
 
 func SendJSONResponse(w http.ResponseWriter, status int, data Envelope) error {
@@ -104,9 +105,20 @@ func ReadJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 
 	return nil
 }
+
+// UploadsDir is where SaveFile stores files and where they are served from.
+const UploadsDir = "/app/cmd/api/uploads"
+
+// LocalFilePath maps a stored "/uploads/..." path to the file on disk. The path
+// is cleaned first so it can never point outside UploadsDir.
+func LocalFilePath(urlPath string) string {
+	cleaned := filepath.Clean("/" + urlPath)
+	return filepath.Join(UploadsDir, strings.TrimPrefix(cleaned, "/uploads"))
+}
+
 func SaveFile(file io.Reader, table string, filename string) (string, error) {
 	// Create directory structure if it doesn't exist
-	fullPath := filepath.Join("/app/cmd/api/uploads", table)
+	fullPath := filepath.Join(UploadsDir, table)
 	// fullPath := filepath.Join("uploads", table)
 
 	if err := os.MkdirAll(fullPath, os.ModePerm); err != nil {
@@ -143,9 +155,24 @@ func SaveFile(file io.Reader, table string, filename string) (string, error) {
 	return urlPath, nil // Returns "/uploads/chats/chats_1738165207_121.jpg"}
 }
 
-// DeleteFile removes a file from the specified path.
+// MoveFile moves a stored file into another table's folder and returns its new
+// stored path.
+func MoveFile(filePath string, table string) (string, error) {
+	fullPath := filepath.Join(UploadsDir, table)
+	if err := os.MkdirAll(fullPath, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	name := filepath.Base(filePath)
+	if err := os.Rename(LocalFilePath(filePath), filepath.Join(fullPath, name)); err != nil {
+		return "", fmt.Errorf("could not move file: %v", err)
+	}
+	return filepath.Join("/uploads", table, name), nil
+}
+
+// DeleteFile removes a stored "/uploads/..." file from disk.
 func DeleteFile(filePath string) error {
-	if err := os.Remove(filePath); err != nil {
+	if err := os.Remove(LocalFilePath(filePath)); err != nil {
 		return fmt.Errorf("could not delete file: %v", err)
 	}
 	return nil
@@ -171,12 +198,16 @@ func NormalizeFloatInput(input string) string {
 
 var jwtSecret = []byte("ahmedpa55wordforitmajormarjcomputerscience")
 
-func GenerateToken(userID string, userRole []string) (string, error) {
-	expirationTime := time.Now().Add(time.Hour * 24).Unix() // 24 hours expiration time
+// GenerateToken issues an access token of the session sessionID, valid for
+// AccessTokenTTL. rolesVersion is the version userRole was read at.
+func GenerateToken(userID string, userRole []string, sessionID string, rolesVersion int) (string, error) {
+	expirationTime := time.Now().Add(AccessTokenTTL).Unix()
 
 	claims := &jwt.MapClaims{
 		"id":        userID,
 		"user_role": userRole,
+		"sid":       sessionID,
+		"rv":        rolesVersion,
 		"exp":       expirationTime,
 	}
 
@@ -192,7 +223,7 @@ func SetTokenCookie(w http.ResponseWriter, token string) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "accessToken",
 		Value:    token,
-		Expires:  time.Now().Add(24 * time.Hour),
+		Expires:  time.Now().Add(AccessTokenTTL),
 		HttpOnly: true,
 		Secure:   true,
 		Path:     "/",
@@ -228,42 +259,13 @@ func ParseBoolOrDefault(value string, defaultValue bool) (bool, error) {
 func BuildQuery(dest interface{}, table string,
 	joins []string, columns []string,
 	searchCols []string, queryParams url.Values,
-	additionalFilters []string) (*Meta, error) {
+	additionalFilters []squirrel.Sqlizer) (*Meta, error) {
 
-	q := queryParams.Get("q")
-	filters := queryParams.Get("filters")
 	sort := queryParams.Get("sort")
 	page, _ := strconv.Atoi(queryParams.Get("page"))
 	perPage, _ := strconv.Atoi(queryParams.Get("per_page"))
 
-	sb := squirrel.Select().PlaceholderFormat(squirrel.Dollar).From(table)
-
-	for _, join := range joins {
-		sb = sb.LeftJoin(join)
-	}
-
-	if q != "" {
-		orConditions := squirrel.Or{}
-		for _, col := range searchCols {
-			searchStr := fmt.Sprintf("%v", q)
-			orConditions = append(orConditions, squirrel.ILike{col: "%" + searchStr + "%"})
-		}
-		sb = sb.Where(orConditions)
-	}
-
-	if filters != "" {
-		pairs := strings.Split(filters, ",")
-		for _, pair := range pairs {
-			parts := strings.Split(pair, ":")
-			if len(parts) == 2 {
-				sb = sb.Where(squirrel.Eq{parts[0]: parts[1]})
-			}
-		}
-	}
-
-	for _, filter := range additionalFilters {
-		sb = sb.Where(filter)
-	}
+	sb := buildListSelect(table, joins, searchCols, queryParams, additionalFilters)
 
 	countSb := sb.Column("COUNT(*)")
 
@@ -333,6 +335,65 @@ func BuildQuery(dest interface{}, table string,
 
 	return &meta, nil
 }
+
+// BuildListQuery builds the same filtered and sorted listing as BuildQuery but
+// without pagination, for callers that stream every matching row.
+func BuildListQuery(table string, joins []string, columns []string,
+	searchCols []string, queryParams url.Values,
+	additionalFilters []squirrel.Sqlizer) (string, []interface{}, error) {
+
+	sb := buildListSelect(table, joins, searchCols, queryParams, additionalFilters).Columns(columns...)
+
+	if sort := queryParams.Get("sort"); sort != "" {
+		if strings.HasPrefix(sort, "-") {
+			sb = sb.OrderBy(strings.TrimPrefix(sort, "-") + " DESC")
+		} else {
+			sb = sb.OrderBy(sort + " ASC")
+		}
+	}
+
+	return sb.ToSql()
+}
+
+// buildListSelect applies the joins, the "q" search, the "filters" pairs and the
+// additional filters shared by every listing query.
+func buildListSelect(table string, joins []string, searchCols []string,
+	queryParams url.Values, additionalFilters []squirrel.Sqlizer) squirrel.SelectBuilder {
+
+	q := queryParams.Get("q")
+	filters := queryParams.Get("filters")
+
+	sb := squirrel.Select().PlaceholderFormat(squirrel.Dollar).From(table)
+
+	for _, join := range joins {
+		sb = sb.LeftJoin(join)
+	}
+
+	if q != "" {
+		orConditions := squirrel.Or{}
+		for _, col := range searchCols {
+			searchStr := fmt.Sprintf("%v", q)
+			orConditions = append(orConditions, squirrel.ILike{col: "%" + searchStr + "%"})
+		}
+		sb = sb.Where(orConditions)
+	}
+
+	if filters != "" {
+		pairs := strings.Split(filters, ",")
+		for _, pair := range pairs {
+			parts := strings.Split(pair, ":")
+			if len(parts) == 2 {
+				sb = sb.Where(squirrel.Eq{parts[0]: parts[1]})
+			}
+		}
+	}
+
+	for _, filter := range additionalFilters {
+		sb = sb.Where(filter)
+	}
+
+	return sb
+}
 func normalizeArabicText(input string) string {
 	// Normalize the text
 	input = norm.NFC.String(input)